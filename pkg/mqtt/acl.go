@@ -0,0 +1,141 @@
+package mqtt
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// TopicACL restricts the topics that can be subscribed and published to.
+//
+// The patterns are MQTT topic filters, where "+" matches a single level and a
+// trailing "#" matches any remaining levels. Each level may also be a glob, such
+// as "sensor-*", where "*" matches any characters within the level.
+type TopicACL struct {
+	// AllowedTopics are the patterns of the topics that can be used.
+	// All topics are allowed if empty.
+	AllowedTopics []string `json:"allowedTopics,omitempty"`
+	// DeniedTopics are the patterns of the topics that can't be used,
+	// even if they are allowed by AllowedTopics.
+	DeniedTopics []string `json:"deniedTopics,omitempty"`
+}
+
+// IsEmpty reports whether the ACL has no rules, and so permits every topic.
+func (a TopicACL) IsEmpty() bool {
+	return len(a.AllowedTopics) == 0 && len(a.DeniedTopics) == 0
+}
+
+// Check returns an error with the reason if the topic filter is not permitted.
+// A topic filter with wildcards is only permitted if all the topics it matches
+// are allowed, and none of them are denied.
+func (a TopicACL) Check(filter string) error {
+	for _, pattern := range a.DeniedTopics {
+		if patternOverlaps(pattern, filter) {
+			return fmt.Errorf("topic %q is denied by the pattern %q", filter, pattern)
+		}
+	}
+
+	if len(a.AllowedTopics) == 0 {
+		return nil
+	}
+	for _, pattern := range a.AllowedTopics {
+		if patternCovers(pattern, filter) {
+			return nil
+		}
+	}
+	return fmt.Errorf("topic %q is not allowed by any of the allowed topic patterns", filter)
+}
+
+// patternCovers reports whether the pattern matches every topic that the filter matches.
+func patternCovers(pattern string, filter string) bool {
+	patternLevels := strings.Split(pattern, "/")
+	filterLevels := strings.Split(filter, "/")
+
+	if isWildcardLevel(patternLevels[0]) && strings.HasPrefix(filterLevels[0], "$") {
+		return false
+	}
+
+	for i, level := range patternLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(filterLevels) {
+			return false
+		}
+
+		switch filterLevel := filterLevels[i]; filterLevel {
+		case "#":
+			return false
+		case "+":
+			if level != "+" && level != "*" {
+				return false
+			}
+		default:
+			if !levelMatches(level, filterLevel) {
+				return false
+			}
+		}
+	}
+	return len(patternLevels) == len(filterLevels)
+}
+
+// patternOverlaps reports whether there is a topic that matches both the pattern and the filter.
+// Two globs in the same level are considered to overlap.
+func patternOverlaps(pattern string, filter string) bool {
+	patternLevels := strings.Split(pattern, "/")
+	filterLevels := strings.Split(filter, "/")
+
+	// wildcards at the first level don't match topics starting with "$"
+	if isWildcardLevel(patternLevels[0]) && strings.HasPrefix(filterLevels[0], "$") ||
+		isWildcardLevel(filterLevels[0]) && strings.HasPrefix(patternLevels[0], "$") {
+		return false
+	}
+
+	for i := 0; i < len(patternLevels) && i < len(filterLevels); i++ {
+		p, f := patternLevels[i], filterLevels[i]
+		switch {
+		case p == "#" || f == "#":
+			return true
+		case p == "+" || f == "+":
+			continue
+		case isGlob(p) && isGlob(f):
+			continue
+		case isGlob(f):
+			if !levelMatches(f, p) {
+				return false
+			}
+		default:
+			if !levelMatches(p, f) {
+				return false
+			}
+		}
+	}
+
+	// "a/#" also matches "a"
+	switch {
+	case len(patternLevels) == len(filterLevels):
+		return true
+	case len(patternLevels) == len(filterLevels)+1:
+		return patternLevels[len(filterLevels)] == "#"
+	case len(filterLevels) == len(patternLevels)+1:
+		return filterLevels[len(patternLevels)] == "#"
+	}
+	return false
+}
+
+// levelMatches reports whether the pattern level, a literal or a glob, matches the topic level.
+func levelMatches(pattern string, level string) bool {
+	if pattern == "+" {
+		return true
+	}
+	matched, err := path.Match(pattern, level)
+	return err == nil && matched
+}
+
+func isWildcardLevel(level string) bool {
+	return level == "+" || level == "#"
+}
+
+func isGlob(level string) bool {
+	return strings.ContainsAny(level, "*?[")
+}
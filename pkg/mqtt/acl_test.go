@@ -0,0 +1,100 @@
+package mqtt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopicACL_Check(t *testing.T) {
+	acl := TopicACL{
+		AllowedTopics: []string{"plant/+/power", "sensors/#", "devices/sensor-*/temperature"},
+		DeniedTopics:  []string{"sensors/secret/#", "$SYS/#"},
+	}
+
+	tests := []struct {
+		filter string
+		err    string
+	}{
+		{filter: "plant/line1/power"},
+		{filter: "plant/+/power"},
+		{filter: "sensors/a/temperature"},
+		{filter: "sensors/a/+"},
+		{filter: "sensors/+/temperature", err: `topic "sensors/+/temperature" is denied by the pattern "sensors/secret/#"`},
+		{filter: "devices/sensor-1/temperature"},
+		{filter: "devices/+/temperature", err: `topic "devices/+/temperature" is not allowed by any of the allowed topic patterns`},
+		{filter: "plant/#", err: `topic "plant/#" is not allowed by any of the allowed topic patterns`},
+		{filter: "plant/line1/temperature", err: `topic "plant/line1/temperature" is not allowed by any of the allowed topic patterns`},
+		{filter: "#", err: `topic "#" is denied by the pattern "sensors/secret/#"`},
+		{filter: "sensors/#", err: `topic "sensors/#" is denied by the pattern "sensors/secret/#"`},
+		{filter: "sensors/+/key", err: `topic "sensors/+/key" is denied by the pattern "sensors/secret/#"`},
+		{filter: "sensors/secret", err: `topic "sensors/secret" is denied by the pattern "sensors/secret/#"`},
+		{filter: "$SYS/broker/uptime", err: `topic "$SYS/broker/uptime" is denied by the pattern "$SYS/#"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			err := acl.Check(tt.filter)
+			if tt.err == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestTopicACL_Check_Empty(t *testing.T) {
+	require.NoError(t, TopicACL{}.Check("#"))
+	require.NoError(t, TopicACL{}.Check("$SYS/#"))
+}
+
+func TestPatternCovers(t *testing.T) {
+	tests := []struct {
+		pattern string
+		filter  string
+		covers  bool
+	}{
+		{pattern: "#", filter: "a/b", covers: true},
+		{pattern: "#", filter: "$SYS/b", covers: false},
+		{pattern: "a/#", filter: "a", covers: true},
+		{pattern: "a/+", filter: "a/+", covers: true},
+		{pattern: "a/*", filter: "a/+", covers: true},
+		{pattern: "a/b*", filter: "a/+", covers: false},
+		{pattern: "a/+", filter: "a/#", covers: false},
+		{pattern: "a/+", filter: "a/b/c", covers: false},
+		{pattern: "a/b/c", filter: "a/b", covers: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.filter, func(t *testing.T) {
+			require.Equal(t, tt.covers, patternCovers(tt.pattern, tt.filter))
+		})
+	}
+}
+
+func TestPatternOverlaps(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		filter   string
+		overlaps bool
+	}{
+		{pattern: "a/b", filter: "a/b", overlaps: true},
+		{pattern: "a/b", filter: "a/c", overlaps: false},
+		{pattern: "a/+", filter: "a/b", overlaps: true},
+		{pattern: "a/b", filter: "+/b", overlaps: true},
+		{pattern: "a/b*", filter: "a/bc", overlaps: true},
+		{pattern: "a/b*", filter: "a/c", overlaps: false},
+		{pattern: "a/#", filter: "a", overlaps: true},
+		{pattern: "a", filter: "a/#", overlaps: true},
+		{pattern: "a", filter: "a/b", overlaps: false},
+		{pattern: "$SYS/#", filter: "#", overlaps: false},
+		{pattern: "#", filter: "$SYS/uptime", overlaps: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.filter, func(t *testing.T) {
+			require.Equal(t, tt.overlaps, patternOverlaps(tt.pattern, tt.filter))
+		})
+	}
+}
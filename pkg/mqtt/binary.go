@@ -0,0 +1,188 @@
+package mqtt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Types of the values of binary payloads.
+const (
+	BinaryTypeInt8    = "int8"
+	BinaryTypeUint8   = "uint8"
+	BinaryTypeInt16   = "int16"
+	BinaryTypeUint16  = "uint16"
+	BinaryTypeInt32   = "int32"
+	BinaryTypeUint32  = "uint32"
+	BinaryTypeInt64   = "int64"
+	BinaryTypeUint64  = "uint64"
+	BinaryTypeFloat32 = "float32"
+	BinaryTypeFloat64 = "float64"
+	// BinaryTypeBool is a byte that is true if it isn't zero.
+	BinaryTypeBool = "bool"
+)
+
+// Byte orders of the values of binary payloads.
+const (
+	EndiannessBig    = "big"
+	EndiannessLittle = "little"
+)
+
+// binaryTypeSizes are the sizes in bytes of the types of binary values.
+var binaryTypeSizes = map[string]int{
+	BinaryTypeInt8:    1,
+	BinaryTypeUint8:   1,
+	BinaryTypeInt16:   2,
+	BinaryTypeUint16:  2,
+	BinaryTypeInt32:   4,
+	BinaryTypeUint32:  4,
+	BinaryTypeInt64:   8,
+	BinaryTypeUint64:  8,
+	BinaryTypeFloat32: 4,
+	BinaryTypeFloat64: 8,
+	BinaryTypeBool:    1,
+}
+
+// BinaryField is a value at a fixed position of binary payloads.
+type BinaryField struct {
+	Name string `json:"name"`
+	// Offset is the position of the first byte of the value in the payload.
+	Offset int `json:"offset,omitempty"`
+	// Type is the type of the value, such as "int16" or "float32".
+	Type string `json:"type"`
+	// Endianness is the byte order of the value, "big" (default) or "little".
+	Endianness string `json:"endianness,omitempty"`
+	// Scale multiplies numeric values, so raw values such as tenths of a degree
+	// are converted to their unit. Scaled values are floats.
+	Scale float64 `json:"scale,omitempty"`
+}
+
+// fieldType returns the type of the frame field of the value. Integers keep
+// their sign, unless they are scaled.
+func (f BinaryField) fieldType() data.FieldType {
+	switch f.Type {
+	case BinaryTypeBool:
+		return data.FieldTypeNullableBool
+	case BinaryTypeFloat32, BinaryTypeFloat64:
+		return data.FieldTypeNullableFloat64
+	}
+	if f.Scale != 0 {
+		return data.FieldTypeNullableFloat64
+	}
+	if f.Type == BinaryTypeUint64 {
+		return data.FieldTypeNullableUint64
+	}
+	return data.FieldTypeNullableInt64
+}
+
+func (f BinaryField) byteOrder() binary.ByteOrder {
+	if f.Endianness == EndiannessLittle {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// validateBinaryFields returns an error if the layout of binary payloads is invalid.
+func (o FrameOptions) validateBinaryFields() error {
+	if len(o.BinaryFields) == 0 {
+		return errors.New("the binary payload format needs at least one field")
+	}
+	names := fieldNames{}
+	for i, f := range o.BinaryFields {
+		if f.Name == "" {
+			return fmt.Errorf("missing name of binary field %d", i+1)
+		}
+		if err := names.add("binary field", f.Name); err != nil {
+			return err
+		}
+		if _, ok := binaryTypeSizes[f.Type]; !ok {
+			return fmt.Errorf("invalid type %q of binary field %q: must be int8, uint8, int16, uint16, int32, uint32, int64, uint64, float32, float64 or bool", f.Type, f.Name)
+		}
+		if f.Offset < 0 {
+			return fmt.Errorf("invalid offset %d of binary field %q", f.Offset, f.Name)
+		}
+		switch f.Endianness {
+		case "", EndiannessBig, EndiannessLittle:
+		default:
+			return fmt.Errorf("invalid endianness %q of binary field %q: must be big or little", f.Endianness, f.Name)
+		}
+	}
+	return nil
+}
+
+// addBinary adds a row with the values of the binary fields of the payload. Fields
+// beyond the end of the payload are null.
+func (df *framer) addBinary(message Message) {
+	for _, f := range df.options.BinaryFields {
+		df.path = append(df.path[:0], f.Name)
+		fieldType := f.fieldType()
+		if v, ok := readBinaryValue(message.Value, f); ok {
+			df.addValue(fieldType, v)
+		} else {
+			df.addNull(fieldType)
+		}
+	}
+	df.path = df.path[:0]
+	df.appendMessage(message)
+}
+
+// readBinaryValue reads the value of the binary field from the payload, as a pointer
+// to a value of the type of its frame field. It returns false if the payload is too short.
+func readBinaryValue(payload []byte, f BinaryField) (any, bool) {
+	size := binaryTypeSizes[f.Type]
+	if f.Offset > len(payload)-size {
+		return nil, false
+	}
+	b := payload[f.Offset : f.Offset+size]
+	order := f.byteOrder()
+
+	var i int64
+	var u uint64
+	var float float64
+	switch f.Type {
+	case BinaryTypeBool:
+		v := b[0] != 0
+		return &v, true
+	case BinaryTypeFloat32:
+		float = float64(math.Float32frombits(order.Uint32(b)))
+	case BinaryTypeFloat64:
+		float = math.Float64frombits(order.Uint64(b))
+	case BinaryTypeInt8:
+		i = int64(int8(b[0]))
+	case BinaryTypeUint8:
+		i = int64(b[0])
+	case BinaryTypeInt16:
+		i = int64(int16(order.Uint16(b)))
+	case BinaryTypeUint16:
+		i = int64(order.Uint16(b))
+	case BinaryTypeInt32:
+		i = int64(int32(order.Uint32(b)))
+	case BinaryTypeUint32:
+		i = int64(order.Uint32(b))
+	case BinaryTypeInt64:
+		i = int64(order.Uint64(b))
+	case BinaryTypeUint64:
+		u = order.Uint64(b)
+	}
+
+	switch f.fieldType() {
+	case data.FieldTypeNullableInt64:
+		return &i, true
+	case data.FieldTypeNullableUint64:
+		return &u, true
+	}
+	switch f.Type {
+	case BinaryTypeFloat32, BinaryTypeFloat64:
+	case BinaryTypeUint64:
+		float = float64(u)
+	default:
+		float = float64(i)
+	}
+	if f.Scale != 0 {
+		float *= f.Scale
+	}
+	return &float, true
+}
@@ -0,0 +1,103 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameOptions_BinaryFields(t *testing.T) {
+	valid := FrameOptions{PayloadFormat: PayloadFormatBinary, BinaryFields: []BinaryField{
+		{Name: "temperature", Type: BinaryTypeInt16, Endianness: EndiannessLittle, Scale: 0.1},
+		{Name: "counter", Offset: 2, Type: BinaryTypeUint32},
+	}}
+	require.NoError(t, valid.Validate())
+
+	tests := []struct {
+		field    BinaryField
+		expected string
+	}{
+		{field: BinaryField{Type: BinaryTypeInt16}, expected: "missing name of binary field 1"},
+		{field: BinaryField{Name: "a", Type: "int24"}, expected: `invalid type "int24" of binary field "a": must be int8, uint8, int16, uint16, int32, uint32, int64, uint64, float32, float64 or bool`},
+		{field: BinaryField{Name: "a", Type: BinaryTypeInt16, Offset: -1}, expected: `invalid offset -1 of binary field "a"`},
+		{field: BinaryField{Name: "a", Type: BinaryTypeInt16, Endianness: "middle"}, expected: `invalid endianness "middle" of binary field "a": must be big or little`},
+		{field: BinaryField{Name: "Time", Type: BinaryTypeInt16}, expected: `the binary field "Time" has the name of the time field`},
+	}
+	for _, tt := range tests {
+		err := FrameOptions{PayloadFormat: PayloadFormatBinary, BinaryFields: []BinaryField{tt.field}}.Validate()
+		require.EqualError(t, err, tt.expected)
+	}
+	require.EqualError(t, FrameOptions{PayloadFormat: PayloadFormatBinary}.Validate(), "the binary payload format needs at least one field")
+
+	duplicate := FrameOptions{PayloadFormat: PayloadFormatBinary, BinaryFields: []BinaryField{
+		{Name: "a", Type: BinaryTypeInt16},
+		{Name: "a", Offset: 2, Type: BinaryTypeInt16},
+	}}
+	require.EqualError(t, duplicate.Validate(), `duplicate binary field "a"`)
+}
+
+func TestReadBinaryValue(t *testing.T) {
+	payload := mustDecodeHex(t, "ff7f0102030441a8000000000000ffffffffffffffff00")
+
+	tests := []struct {
+		field    BinaryField
+		expected any
+	}{
+		{field: BinaryField{Type: BinaryTypeInt8}, expected: int64(-1)},
+		{field: BinaryField{Type: BinaryTypeUint8}, expected: int64(255)},
+		{field: BinaryField{Type: BinaryTypeInt16}, expected: int64(-129)},
+		{field: BinaryField{Type: BinaryTypeInt16, Endianness: EndiannessLittle}, expected: int64(32767)},
+		{field: BinaryField{Offset: 2, Type: BinaryTypeUint16}, expected: int64(0x0102)},
+		{field: BinaryField{Offset: 2, Type: BinaryTypeUint32, Endianness: EndiannessLittle}, expected: int64(0x04030201)},
+		{field: BinaryField{Offset: 2, Type: BinaryTypeInt32}, expected: int64(0x01020304)},
+		{field: BinaryField{Offset: 6, Type: BinaryTypeFloat32}, expected: 21.0},
+		{field: BinaryField{Offset: 10, Type: BinaryTypeInt64, Endianness: EndiannessLittle}, expected: int64(-1 << 32)},
+		{field: BinaryField{Offset: 14, Type: BinaryTypeUint64}, expected: uint64(1<<64 - 1)},
+		{field: BinaryField{Offset: 14, Type: BinaryTypeInt64}, expected: int64(-1)},
+		{field: BinaryField{Offset: 6, Type: BinaryTypeFloat64}, expected: 201326592.0},
+		{field: BinaryField{Offset: 22, Type: BinaryTypeBool}, expected: false},
+		{field: BinaryField{Offset: 21, Type: BinaryTypeBool}, expected: true},
+		// scaled integers are floats
+		{field: BinaryField{Offset: 2, Type: BinaryTypeUint16, Scale: 0.5}, expected: 129.0},
+		{field: BinaryField{Type: BinaryTypeInt8, Scale: 10}, expected: -10.0},
+	}
+	for _, tt := range tests {
+		v, ok := readBinaryValue(payload, tt.field)
+		require.True(t, ok, tt.field)
+		require.Equal(t, tt.expected, derefValue(v), tt.field)
+	}
+
+	for _, field := range []BinaryField{
+		{Offset: 23, Type: BinaryTypeUint8},
+		{Offset: 20, Type: BinaryTypeUint32},
+		{Offset: 1 << 62, Type: BinaryTypeInt16},
+	} {
+		_, ok := readBinaryValue(payload, field)
+		require.False(t, ok, field)
+	}
+}
+
+func Test_framer_Binary(t *testing.T) {
+	received := time.Unix(1714570000, 0)
+	options := FrameOptions{
+		PayloadFormat: PayloadFormatBinary,
+		BinaryFields: []BinaryField{
+			{Name: "temperature", Type: BinaryTypeInt16, Endianness: EndiannessLittle, Scale: 0.1},
+			{Name: "counter", Offset: 2, Type: BinaryTypeUint32, Endianness: EndiannessLittle},
+			{Name: "alarm", Offset: 6, Type: BinaryTypeBool},
+		},
+	}
+	messages := []Message{
+		{Timestamp: received, Value: mustDecodeHex(t, "d7002a00000000")},
+		{Timestamp: received.Add(time.Second), Value: mustDecodeHex(t, "ceff2b00000001")},
+		// values beyond the end of short payloads are null
+		{Timestamp: received.Add(2 * time.Second), Value: mustDecodeHex(t, "d800")},
+	}
+
+	frame, err := newFramer(options).toFrame(messages, log.DefaultLogger)
+	require.NoError(t, err)
+	experimental.CheckGoldenJSONFrame(t, "testdata", "binary", frame, update)
+}
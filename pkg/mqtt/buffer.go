@@ -0,0 +1,179 @@
+package mqtt
+
+import (
+	"sync"
+	"time"
+)
+
+// Default limits of the messages buffered per topic between two frames.
+const (
+	DefaultMaxBufferedMessages = 10000
+	DefaultMaxBufferedBytes    = 16 * 1024 * 1024
+)
+
+// BufferLimits limit the messages that are buffered per topic between two frames.
+// Zero values use the defaults.
+type BufferLimits struct {
+	MaxMessages int `json:"maxBufferedMessages,omitempty"`
+	MaxBytes    int `json:"maxBufferedBytes,omitempty"`
+	// MaxAge removes messages older than the age from the buffer.
+	// Zero keeps the messages regardless of their age.
+	MaxAge time.Duration `json:"-"`
+}
+
+func (l BufferLimits) withDefaults() BufferLimits {
+	if l.MaxMessages <= 0 {
+		l.MaxMessages = DefaultMaxBufferedMessages
+	}
+	if l.MaxBytes <= 0 {
+		l.MaxBytes = DefaultMaxBufferedBytes
+	}
+	return l
+}
+
+// messageBuffer is a ring buffer of messages bounded by the number of messages
+// and their size. When it is full, the oldest messages are dropped to make room
+// for new ones, so the buffer always holds the most recent messages.
+//
+// It is safe for concurrent use, so messages can be added by the MQTT client
+// while they are drained by the stream.
+type messageBuffer struct {
+	mu       sync.Mutex
+	limits   BufferLimits
+	messages []Message
+	// start is the index of the oldest message in messages.
+	start int
+	count int
+	bytes int
+	// dropped counts the messages dropped since the last drain.
+	dropped int
+	// added is closed when the next message is added.
+	added chan struct{}
+}
+
+func newMessageBuffer(limits BufferLimits) *messageBuffer {
+	return &messageBuffer{limits: limits.withDefaults()}
+}
+
+// messageSize returns the number of bytes a message counts towards the limit.
+func messageSize(m Message) int {
+	return len(m.Value) + len(m.Topic)
+}
+
+// add adds a message to the buffer, dropping the oldest messages if the buffer is full.
+func (b *messageBuffer) add(m Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.removeExpired(m.Timestamp)
+
+	size := messageSize(m)
+	if size > b.limits.MaxBytes {
+		b.dropped++
+		return
+	}
+
+	for b.count > 0 && (b.count == b.limits.MaxMessages || b.bytes+size > b.limits.MaxBytes) {
+		b.removeOldest()
+		b.dropped++
+	}
+
+	if b.count == len(b.messages) {
+		b.grow()
+	}
+	b.messages[(b.start+b.count)%len(b.messages)] = m
+	b.count++
+	b.bytes += size
+
+	if b.added != nil {
+		close(b.added)
+		b.added = nil
+	}
+}
+
+// waitForMessage returns a channel that is closed when the next message is added.
+func (b *messageBuffer) waitForMessage() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.added == nil {
+		b.added = make(chan struct{})
+	}
+	return b.added
+}
+
+// removeExpired removes the messages that are older than the maximum age at the given time.
+// These messages are not counted as dropped.
+func (b *messageBuffer) removeExpired(now time.Time) {
+	if b.limits.MaxAge <= 0 {
+		return
+	}
+	expired := now.Add(-b.limits.MaxAge)
+	for b.count > 0 && b.messages[b.start].Timestamp.Before(expired) {
+		b.removeOldest()
+	}
+}
+
+func (b *messageBuffer) removeOldest() {
+	b.bytes -= messageSize(b.messages[b.start])
+	b.messages[b.start] = Message{}
+	b.start = (b.start + 1) % len(b.messages)
+	b.count--
+}
+
+// grow doubles the capacity of the buffer, up to the maximum number of messages,
+// so topics that receive few messages don't allocate the whole buffer.
+func (b *messageBuffer) grow() {
+	size := min(max(2*len(b.messages), 16), b.limits.MaxMessages)
+	messages := make([]Message, size)
+	b.copyTo(messages)
+	b.messages = messages
+	b.start = 0
+}
+
+// copyTo copies the messages in the buffer, oldest first, to dst.
+func (b *messageBuffer) copyTo(dst []Message) {
+	n := copy(dst, b.messages[b.start:min(b.start+b.count, len(b.messages))])
+	copy(dst[n:], b.messages[:b.count-n])
+}
+
+// len returns the number of messages in the buffer.
+func (b *messageBuffer) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.count
+}
+
+// snapshot returns a copy of the messages in the buffer, oldest first.
+func (b *messageBuffer) snapshot() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.removeExpired(time.Now())
+	return b.messagesLocked()
+}
+
+func (b *messageBuffer) messagesLocked() []Message {
+	messages := make([]Message, b.count)
+	b.copyTo(messages)
+	return messages
+}
+
+// drain removes all messages from the buffer and returns them, oldest first,
+// with the number of messages dropped since the last drain. Messages added
+// concurrently are either returned or kept for the next drain, never both.
+func (b *messageBuffer) drain() ([]Message, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	messages := b.messagesLocked()
+	dropped := b.dropped
+
+	clear(b.messages)
+	b.start = 0
+	b.count = 0
+	b.bytes = 0
+	b.dropped = 0
+
+	return messages, dropped
+}
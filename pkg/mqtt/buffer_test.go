@@ -0,0 +1,116 @@
+package mqtt
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func values(messages []Message) []string {
+	result := make([]string, 0, len(messages))
+	for _, m := range messages {
+		result = append(result, string(m.Value))
+	}
+	return result
+}
+
+func TestMessageBuffer(t *testing.T) {
+	t.Run("keeps the messages in order", func(t *testing.T) {
+		b := newMessageBuffer(BufferLimits{})
+		for i := range 40 {
+			b.add(Message{Value: []byte(strconv.Itoa(i))})
+		}
+		messages, dropped := b.drain()
+		require.Len(t, messages, 40)
+		require.Equal(t, "0", string(messages[0].Value))
+		require.Equal(t, "39", string(messages[39].Value))
+		require.Zero(t, dropped)
+	})
+
+	t.Run("drops the oldest messages over the message limit", func(t *testing.T) {
+		b := newMessageBuffer(BufferLimits{MaxMessages: 3})
+		for i := range 5 {
+			b.add(Message{Value: []byte(strconv.Itoa(i))})
+		}
+		require.Equal(t, []string{"2", "3", "4"}, values(b.snapshot()))
+
+		messages, dropped := b.drain()
+		require.Equal(t, []string{"2", "3", "4"}, values(messages))
+		require.Equal(t, 2, dropped)
+	})
+
+	t.Run("drops the oldest messages over the byte limit", func(t *testing.T) {
+		b := newMessageBuffer(BufferLimits{MaxBytes: 10})
+		b.add(Message{Value: []byte("aaaa")})
+		b.add(Message{Value: []byte("bbbb")})
+		b.add(Message{Value: []byte("cccc")})
+
+		messages, dropped := b.drain()
+		require.Equal(t, []string{"bbbb", "cccc"}, values(messages))
+		require.Equal(t, 1, dropped)
+	})
+
+	t.Run("drops messages larger than the byte limit", func(t *testing.T) {
+		b := newMessageBuffer(BufferLimits{MaxBytes: 10})
+		b.add(Message{Value: []byte("aaaa")})
+		b.add(Message{Value: []byte("this message is too large")})
+
+		messages, dropped := b.drain()
+		require.Equal(t, []string{"aaaa"}, values(messages))
+		require.Equal(t, 1, dropped)
+	})
+
+	t.Run("drain resets the buffer", func(t *testing.T) {
+		b := newMessageBuffer(BufferLimits{MaxMessages: 2})
+		for i := range 3 {
+			b.add(Message{Value: []byte(strconv.Itoa(i))})
+		}
+		_, _ = b.drain()
+		require.Zero(t, b.len())
+
+		b.add(Message{Value: []byte("3")})
+		messages, dropped := b.drain()
+		require.Equal(t, []string{"3"}, values(messages))
+		require.Zero(t, dropped)
+	})
+}
+
+func TestTopic_ToDataFrame_DroppedNotice(t *testing.T) {
+	topic := &Topic{Path: "dGVzdC90b3BpYw", Interval: time.Second, messages: newMessageBuffer(BufferLimits{MaxMessages: 2})}
+	for i := range 5 {
+		topic.AddMessage(Message{Timestamp: time.Unix(int64(i), 0), Value: []byte(strconv.Itoa(i))})
+	}
+
+	frame, err := topic.ToDataFrame(log.DefaultLogger)
+	require.NoError(t, err)
+	require.Equal(t, 2, frame.Rows())
+	require.NotNil(t, frame.Meta)
+	require.Equal(t, []data.Notice{{
+		Severity: data.NoticeSeverityWarning,
+		Text:     "3 messages were dropped because the message buffer of the topic was full",
+	}}, frame.Meta.Notices)
+
+	topic.AddMessage(Message{Timestamp: time.Unix(5, 0), Value: []byte("5")})
+	frame, err = topic.ToDataFrame(log.DefaultLogger)
+	require.NoError(t, err)
+	require.Equal(t, 1, frame.Rows())
+	require.Nil(t, frame.Meta)
+}
+
+func TestMessageBuffer_MaxAge(t *testing.T) {
+	b := newMessageBuffer(BufferLimits{MaxAge: time.Hour})
+	now := time.Now()
+	b.add(Message{Timestamp: now.Add(-2 * time.Hour), Value: []byte("0")})
+	b.add(Message{Timestamp: now.Add(-30 * time.Minute), Value: []byte("1")})
+	b.add(Message{Timestamp: now, Value: []byte("2")})
+
+	require.Equal(t, []string{"1", "2"}, values(b.snapshot()))
+
+	// expired messages are not counted as dropped
+	_, dropped := b.drain()
+	require.Zero(t, dropped)
+}
@@ -0,0 +1,37 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// cborDecMode decodes CBOR payloads (RFC 8949) to values that can be converted to JSON.
+// Timestamps are decoded to RFC 3339 strings, and bignums to big integers.
+var cborDecMode = func() cbor.DecMode {
+	dm, err := cbor.DecOptions{
+		MaxNestedLevels: maxPayloadDepth,
+		DefaultMapType:  reflect.TypeOf(map[any]any(nil)),
+		TimeTagToAny:    cbor.TimeTagToRFC3339Nano,
+		BigIntDec:       cbor.BigIntDecodePointer,
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return dm
+}()
+
+// cborToJSON converts a CBOR payload to JSON. Byte strings are converted to base64 strings,
+// and keys that aren't strings to their JSON text.
+func cborToJSON(payload []byte) ([]byte, error) {
+	var v any
+	if err := cborDecMode.Unmarshal(payload, &v); err != nil {
+		return nil, err
+	}
+	v, err := jsonValue(v, 0)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
@@ -4,13 +4,15 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"math/rand"
 	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	paho "github.com/eclipse/paho.mqtt.golang"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 )
@@ -18,8 +20,12 @@ import (
 type Client interface {
 	GetTopic(string) (*Topic, bool)
 	IsConnected() bool
-	Subscribe(string, log.Logger) (*Topic, error)
+	Subscribe(string, byte, log.Logger) (*Topic, error)
 	Unsubscribe(string, log.Logger) error
+	History(string, byte, log.Logger) ([]Message, error)
+	WaitForMessages(context.Context, string, byte, time.Duration, log.Logger) ([]Message, error)
+	Publish(string, []byte, byte, bool, log.Logger) error
+	SparkplugStates(string) []SparkplugState
 	Dispose()
 }
 
@@ -32,33 +38,159 @@ type Options struct {
 	TLSClientCert string `json:"tlsClientCert"`
 	TLSClientKey  string `json:"tlsClientKey"`
 	TLSSkipVerify bool   `json:"tlsSkipVerify"`
+	// ProtocolVersion selects the MQTT protocol version used to connect to the broker.
+	// Zero means MQTT v3.1.1 with a fallback to v3.1.
+	ProtocolVersion uint `json:"protocolVersion,omitempty"`
+	// BufferLimits limit the messages buffered per topic between two frames.
+	BufferLimits
+	// HistoryRetention is how long the messages of a topic are kept for queries,
+	// as a duration string. Empty uses DefaultHistoryRetention and "0" disables the history.
+	HistoryRetention string `json:"historyRetention,omitempty"`
+	// PublishOptions control publishing to topics through Grafana Live.
+	PublishOptions
+	// TopicACL restricts the topics that can be subscribed and published to.
+	TopicACL
+	// ProtoDescriptors is a base64 encoded protobuf FileDescriptorSet with the
+	// message types that queries can decode payloads with.
+	ProtoDescriptors string `json:"protoDescriptors,omitempty"`
+	// DecompressionOptions control the decompression of the payloads of received messages.
+	DecompressionOptions
 }
 
+// PublishOptions control publishing to topics through Grafana Live.
+type PublishOptions struct {
+	// PublishEnabled allows publishing to the topics that match PublishAllowedTopics.
+	PublishEnabled bool `json:"publishEnabled,omitempty"`
+	// PublishAllowedTopics are the topic filters, which may contain MQTT wildcards,
+	// of the topics that can be published to. No topics can be published to if empty.
+	PublishAllowedTopics []string `json:"publishAllowedTopics,omitempty"`
+	// PublishQoS is the QoS level of the published messages.
+	PublishQoS byte `json:"publishQoS,omitempty"`
+	// PublishRetain makes the broker retain the published messages.
+	PublishRetain bool `json:"publishRetain,omitempty"`
+}
+
+// CanPublish reports whether publishing to the topic is allowed.
+func (o PublishOptions) CanPublish(topic string) bool {
+	if !o.PublishEnabled {
+		return false
+	}
+	for _, filter := range o.PublishAllowedTopics {
+		if MatchTopic(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultHistoryRetention is how long the messages of a topic are kept for queries
+// if Options.HistoryRetention is not set.
+const DefaultHistoryRetention = time.Hour
+
+// Supported values for Options.ProtocolVersion. The values match the protocol
+// level sent by the client in the MQTT CONNECT packet.
+const (
+	ProtocolVersion31  = 3
+	ProtocolVersion311 = 4
+	ProtocolVersion5   = 5
+)
+
+// conn is the protocol specific connection to the MQTT broker.
+type conn interface {
+	IsConnected() bool
+	// Subscribe subscribes to the topic with the handler of its messages. A nil handler
+	// changes the QoS of an existing subscription and keeps its handler.
+	Subscribe(topic string, qos byte, handler messageHandler) error
+	Unsubscribe(topic string) error
+	Publish(topic string, payload []byte, qos byte, retain bool) error
+	Disconnect()
+}
+
+// messageHandler is called for each message received on a subscription.
+type messageHandler func(topic string, payload []byte)
+
 type client struct {
-	client paho.Client
-	topics TopicMap
+	conn             conn
+	topics           TopicMap
+	bufferLimits     BufferLimits
+	historyRetention time.Duration
+	// subscriptionMu serializes Subscribe and Unsubscribe so that the
+	// subscriptions on the broker stay consistent with the topic map.
+	subscriptionMu sync.Mutex
+	// historyTimers remove the history of a path once it hasn't been
+	// subscribed to or queried for the history retention.
+	historyTimers map[string]*time.Timer
+	// waits counts the subscriptions of WaitForMessages without a history,
+	// which each have their own topic.
+	waits atomic.Uint64
+	// sparkplug resolves the metric aliases of Sparkplug B messages
+	// and tracks the state of the Sparkplug B entities.
+	sparkplug sparkplugTracker
+	// decompressor decompresses the payloads of received messages.
+	// It is nil if the payloads aren't compressed.
+	decompressor *decompressor
 }
 
 func NewClient(ctx context.Context, o Options, settings backend.DataSourceInstanceSettings) (Client, error) {
 	logger := log.DefaultLogger.FromContext(ctx)
-	opts := paho.NewClientOptions()
-
-	opts.AddBroker(o.URI)
 
 	clientID := o.ClientID
 	if clientID == "" {
 		clientID = fmt.Sprintf("grafana_%d", rand.Int())
 	}
-	opts.SetClientID(clientID)
 
-	if o.Username != "" {
-		opts.SetUsername(o.Username)
+	tlsConfig, err := newTLSConfig(o)
+	if err != nil {
+		return nil, err
+	}
+
+	historyRetention, err := parseHistoryRetention(o.HistoryRetention)
+	if err != nil {
+		return nil, err
+	}
+
+	decompressor, err := newDecompressor(o.DecompressionOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var c conn
+	switch o.ProtocolVersion {
+	case 0, ProtocolVersion31, ProtocolVersion311:
+		c, err = newV3Conn(ctx, o, clientID, tlsConfig, settings, logger)
+	case ProtocolVersion5:
+		c, err = newV5Conn(ctx, o, clientID, tlsConfig, settings, logger)
+	default:
+		return nil, backend.DownstreamErrorf("unsupported MQTT protocol version: %d", o.ProtocolVersion)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	if o.Password != "" {
-		opts.SetPassword(o.Password)
+	return &client{
+		conn:             c,
+		bufferLimits:     o.BufferLimits,
+		historyRetention: historyRetention,
+		historyTimers:    make(map[string]*time.Timer),
+		decompressor:     decompressor,
+	}, nil
+}
+
+func parseHistoryRetention(s string) (time.Duration, error) {
+	switch s {
+	case "":
+		return DefaultHistoryRetention, nil
+	case "0":
+		return 0, nil
 	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, backend.DownstreamErrorf("invalid history retention %q: must be a duration such as 1h", s)
+	}
+	return d, nil
+}
 
+func newTLSConfig(o Options) (*tls.Config, error) {
 	tlsConfig := &tls.Config{
 		InsecureSkipVerify: o.TLSSkipVerify,
 	}
@@ -78,54 +210,67 @@ func NewClient(ctx context.Context, o Options, settings backend.DataSourceInstan
 		tlsConfig.RootCAs = caCertPool
 	}
 
-	opts.SetTLSConfig(tlsConfig)
-	opts.SetPingTimeout(60 * time.Second)
-	opts.SetKeepAlive(60 * time.Second)
-	opts.SetAutoReconnect(true)
-	opts.SetCleanSession(false)
-	opts.SetMaxReconnectInterval(10 * time.Second)
-	opts.SetConnectionLostHandler(func(c paho.Client, err error) {
-		logger.Warn("MQTT Connection lost", "error", err)
-	})
-	opts.SetReconnectingHandler(func(c paho.Client, options *paho.ClientOptions) {
-		logger.Debug("MQTT Reconnecting")
-	})
-
-	// Configure PDC (Private Datasource Connect) if enabled
-	if err := configureProxyIfEnabled(ctx, opts, settings, logger); err != nil {
-		return nil, err
-	}
-
-	logger.Info("MQTT Connecting", "clientID", clientID)
-
-	pahoClient := paho.NewClient(opts)
-	if token := pahoClient.Connect(); token.Wait() && token.Error() != nil {
-		return nil, backend.DownstreamErrorf("error connecting to MQTT broker: %s", token.Error())
-	}
-
-	return &client{
-		client: pahoClient,
-	}, nil
+	return tlsConfig, nil
 }
 
 func (c *client) IsConnected() bool {
-	return c.client.IsConnectionOpen()
+	return c.conn.IsConnected()
 }
 
-func (c *client) HandleMessage(topic string, payload []byte) {
+// HandleMessage adds a message received on the given MQTT topic to the topics
+// subscribed to topicPath. The topic may differ from the subscribed topic path
+// when the subscription uses wildcards.
+func (c *client) HandleMessage(topicPath string, topic string, payload []byte) {
+	if c.decompressor != nil {
+		value, err := c.decompressor.decompress(payload)
+		switch {
+		case errors.Is(err, errDecompressedSizeExceeded):
+			log.DefaultLogger.Warn("Dropping MQTT message", "topic", topic, "error", err, "limit", c.decompressor.maxBytes)
+			return
+		case err != nil:
+			// payloads that aren't compressed are kept as is
+			log.DefaultLogger.Debug("MQTT message decompression failed", "topic", topic, "error", err)
+		default:
+			payload = value
+		}
+	}
+
 	message := Message{
 		Timestamp: time.Now(),
+		Topic:     topic,
 		Value:     payload,
 	}
+	if t, ok := parseSparkplugTopic(topic); ok {
+		// the metric aliases are resolved in the order the messages are received
+		if p, err := c.sparkplug.decode(t, payload, message.Timestamp); err == nil {
+			message.sparkplug = p
+		}
+	} else if hostID, ok := parseSparkplugStateTopic(topic); ok {
+		c.sparkplug.updateHost(topic, hostID, payload, message.Timestamp)
+	}
+
+	c.topics.AddMessage(topicPath, message)
+}
 
-	c.topics.AddMessage(topic, message)
+// SparkplugStates returns the states of the Sparkplug B host applications, edge nodes and
+// devices that match the MQTT topic filter. The states are tracked from the messages received
+// on the subscribed topics, so only the entities of subscribed topics are known.
+func (c *client) SparkplugStates(filter string) []SparkplugState {
+	return c.sparkplug.matchingStates(filter)
 }
 
 func (c *client) GetTopic(reqPath string) (*Topic, bool) {
 	return c.topics.Load(reqPath)
 }
 
-func (c *client) Subscribe(reqPath string, logger log.Logger) (*Topic, error) {
+func (c *client) Subscribe(reqPath string, qos byte, logger log.Logger) (*Topic, error) {
+	if qos > 2 {
+		return nil, backend.DownstreamErrorf("invalid QoS level %d: must be 0, 1 or 2", qos)
+	}
+
+	c.subscriptionMu.Lock()
+	defer c.subscriptionMu.Unlock()
+
 	// Check if there's already a topic with this exact key (reqPath)
 	if existingTopic, ok := c.topics.Load(reqPath); ok {
 		return existingTopic, nil
@@ -142,62 +287,269 @@ func (c *client) Subscribe(reqPath string, logger log.Logger) (*Topic, error) {
 
 	// For MQTT subscription, we only need the actual topic path (without streaming key)
 	// The streaming key is used for topic uniqueness in storage, but MQTT only cares about the topic path
-	topicPath := path.Join(chunks[1:]...)
-
-	// Create topic with the reqPath as the key for storage
-	// The actual topic components will be parsed when needed
 	t := &Topic{
-		Path:     topicPath,
-		Interval: interval,
+		Path:         chunks[1],
+		StreamingKey: path.Join(chunks[2:]...),
+		Interval:     interval,
+		QoS:          qos,
+		messages:     newMessageBuffer(c.bufferLimits),
 	}
 
+	// The key of the topic is reqPath, which includes the streaming key
+	if err := c.store(t, logger); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// store subscribes to the path of the topic on the broker, if needed, and stores the topic.
+func (c *client) store(t *Topic, logger log.Logger) error {
 	topic, err := decodeTopic(t.Path, logger)
 	if err != nil {
-		return nil, backend.DownstreamErrorf("error decoding MQTT topic name %s: %s", t.Path, err)
+		return backend.DownstreamErrorf("error decoding MQTT topic name %s: %s", t.Path, err)
+	}
+
+	// Topics with the same path share a single subscription on the broker, which is
+	// only (re)sent when it doesn't exist yet or when a higher QoS is requested.
+	current, exists := c.topics.HasSubscription(t.Path)
+	switch {
+	case !exists:
+		if err := c.subscribe(t.Path, topic, t.QoS, logger); err != nil {
+			return err
+		}
+	case t.QoS > current:
+		if err := c.resubscribe(topic, t.QoS, logger); err != nil {
+			return err
+		}
 	}
 
-	logger.Debug("Subscribing to MQTT topic", "topic", topic)
+	c.topics.Store(t)
+	return nil
+}
 
-	if token := c.client.Subscribe(topic, 0, func(_ paho.Client, m paho.Message) {
+func (c *client) subscribe(topicPath string, topic string, qos byte, logger log.Logger) error {
+	logger.Debug("Subscribing to MQTT topic", "topic", topic, "qos", qos)
+
+	return c.conn.Subscribe(topic, qos, func(receivedTopic string, payload []byte) {
 		// by wrapping HandleMessage we can directly get the correct topicPath for the incoming topic
 		// and don't need to regex it against + and #.
-		c.HandleMessage(topicPath, []byte(m.Payload()))
-	}); token.Wait() && token.Error() != nil {
-		return nil, backend.DownstreamErrorf("error subscribing to MQTT topic %s: %s", topic, token.Error())
-	}
-	// Store the topic using reqPath as the key (which includes streaming key)
-	c.topics.Map.Store(reqPath, t)
-	return t, nil
+		c.HandleMessage(topicPath, receivedTopic, payload)
+	})
+}
+
+// resubscribe changes the QoS of the existing subscription to the topic.
+func (c *client) resubscribe(topic string, qos byte, logger log.Logger) error {
+	logger.Debug("Changing the QoS of MQTT subscription", "topic", topic, "qos", qos)
+
+	return c.conn.Subscribe(topic, qos, nil)
 }
 
 func (c *client) Unsubscribe(reqPath string, logger log.Logger) error {
+	c.subscriptionMu.Lock()
+	defer c.subscriptionMu.Unlock()
+
+	return c.unsubscribe(reqPath, logger)
+}
+
+func (c *client) unsubscribe(reqPath string, logger log.Logger) error {
 	t, ok := c.GetTopic(reqPath)
 	if !ok {
 		return nil // No error if topic doesn't exist
 	}
 	c.topics.Delete(t.Key())
 
-	if exists := c.topics.HasSubscription(t.Path); exists {
+	topic, err := decodeTopic(t.Path, logger)
+	if err != nil {
+		return backend.DownstreamErrorf("error decoding MQTT topic name %s: %s", t.Path, err)
+	}
+
+	if qos, exists := c.topics.HasSubscription(t.Path); exists {
 		// There are still other subscriptions to this path,
-		// so we shouldn't unsubscribe yet.
+		// so we shouldn't unsubscribe yet. The QoS is downgraded
+		// if none of them requested the QoS of the removed topic.
+		if qos < t.QoS {
+			return c.resubscribe(topic, qos, logger)
+		}
 		return nil
 	}
 
-	logger.Debug("Unsubscribing from MQTT topic", "topic", t.Path)
+	logger.Debug("Unsubscribing from MQTT topic", "topic", topic)
 
-	topic, err := decodeTopic(t.Path, logger)
+	if err := c.conn.Unsubscribe(topic); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// History returns the messages received on the topic path within the history retention,
+// oldest first. If the path is not subscribed to yet, it is subscribed to with the given QoS,
+// so the messages are available to later calls.
+func (c *client) History(topicPath string, qos byte, logger log.Logger) ([]Message, error) {
+	if qos > 2 {
+		return nil, backend.DownstreamErrorf("invalid QoS level %d: must be 0, 1 or 2", qos)
+	}
+
+	c.subscriptionMu.Lock()
+	defer c.subscriptionMu.Unlock()
+
+	t, err := c.history(topicPath, qos, logger)
+	if err != nil || t == nil {
+		return nil, err
+	}
+	return t.Messages(), nil
+}
+
+// WaitForMessages returns the messages in the history of the topic path, like History.
+// If there are none, it waits up to the timeout for a message to be received, and returns
+// no messages if none was received in time. If the history is disabled, the topic path is
+// only subscribed to while waiting, so only retained and new messages are returned.
+func (c *client) WaitForMessages(ctx context.Context, topicPath string, qos byte, timeout time.Duration, logger log.Logger) ([]Message, error) {
+	if qos > 2 {
+		return nil, backend.DownstreamErrorf("invalid QoS level %d: must be 0, 1 or 2", qos)
+	}
+
+	c.subscriptionMu.Lock()
+	t, err := c.history(topicPath, qos, logger)
+	if err == nil && t == nil {
+		// without the history, the topic path is only subscribed to while waiting
+		t = &Topic{
+			Path:         topicPath,
+			StreamingKey: fmt.Sprintf("%s/%d", waitStreamingKey, c.waits.Add(1)),
+			QoS:          qos,
+			messages:     newMessageBuffer(c.bufferLimits),
+		}
+		if err = c.store(t, logger); err == nil {
+			defer func() {
+				if err := c.Unsubscribe(t.Key(), logger); err != nil {
+					logger.Error("Failed to unsubscribe from MQTT topic", "topic", topicPath, "error", err)
+				}
+			}()
+		}
+	}
 	if err != nil {
-		return backend.DownstreamErrorf("error decoding MQTT topic name %s: %s", t.Path, err)
+		c.subscriptionMu.Unlock()
+		return nil, err
 	}
+	// the channel is requested before the history is read, so a message
+	// received in between is not missed.
+	added := t.waitForMessage()
+	c.subscriptionMu.Unlock()
 
-	if token := c.client.Unsubscribe(topic); token.Wait() && token.Error() != nil {
-		return backend.DownstreamErrorf("error unsubscribing from MQTT topic %s: %s", t.Path, token.Error())
+	if messages := t.Messages(); len(messages) > 0 {
+		return messages, nil
 	}
 
-	return nil
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-added:
+		return t.Messages(), nil
+	case <-timer.C:
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// historyKey returns the key of the topic that keeps the history of the topic path.
+func historyKey(topicPath string) string {
+	t := Topic{Path: topicPath, StreamingKey: historyStreamingKey}
+	return t.Key()
+}
+
+// historyStreamingKey is the streaming key of the topics that keep the history of a path,
+// and waitStreamingKey the prefix of the streaming keys of the topics that WaitForMessages
+// subscribes to without a history. Streaming keys of channels have three segments,
+// so they can't collide with them.
+const (
+	historyStreamingKey = "history"
+	waitStreamingKey    = "wait"
+)
+
+// history returns the topic that keeps the history of the topic path, which is created
+// and subscribed to if it doesn't exist. The history is kept for the history retention
+// after the last call. It returns nil if the history is disabled.
+func (c *client) history(topicPath string, qos byte, logger log.Logger) (*Topic, error) {
+	if c.historyRetention <= 0 {
+		return nil, nil
+	}
+
+	t, ok := c.topics.Load(historyKey(topicPath))
+	if !ok || qos > t.QoS {
+		limits := c.bufferLimits
+		limits.MaxAge = c.historyRetention
+		h := &Topic{
+			Path:         topicPath,
+			StreamingKey: historyStreamingKey,
+			QoS:          qos,
+			messages:     newMessageBuffer(limits),
+		}
+		if ok {
+			// keep the history when the QoS is upgraded
+			h.QoS = max(qos, t.QoS)
+			h.messages = t.messages
+		}
+		if err := c.store(h, logger); err != nil {
+			return nil, err
+		}
+		t = h
+	}
+
+	if timer, ok := c.historyTimers[topicPath]; ok {
+		timer.Reset(c.historyRetention)
+	} else {
+		c.historyTimers[topicPath] = time.AfterFunc(c.historyRetention, func() {
+			c.expireHistory(topicPath, logger)
+		})
+	}
+
+	return t, nil
+}
+
+// expireHistory removes the history of the topic path, unless it is still subscribed to.
+func (c *client) expireHistory(topicPath string, logger log.Logger) {
+	c.subscriptionMu.Lock()
+	defer c.subscriptionMu.Unlock()
+
+	timer, ok := c.historyTimers[topicPath]
+	if !ok {
+		return
+	}
+	if c.topics.subscriberCount(topicPath) > 1 {
+		timer.Reset(c.historyRetention)
+		return
+	}
+	delete(c.historyTimers, topicPath)
+
+	if err := c.unsubscribe(historyKey(topicPath), logger); err != nil {
+		logger.Error("Failed to unsubscribe from MQTT topic", "topic", topicPath, "error", err)
+	}
+}
+
+// Publish publishes the payload to the MQTT topic.
+func (c *client) Publish(topic string, payload []byte, qos byte, retain bool, logger log.Logger) error {
+	if qos > 2 {
+		return backend.DownstreamErrorf("invalid QoS level %d: must be 0, 1 or 2", qos)
+	}
+	if topic == "" || hasWildcards(topic) {
+		return backend.DownstreamErrorf("invalid MQTT topic name %q: must not be empty or contain wildcards", topic)
+	}
+
+	logger.Debug("Publishing to MQTT topic", "topic", topic, "qos", qos, "retain", retain)
+	return c.conn.Publish(topic, payload, qos, retain)
 }
 
 func (c *client) Dispose() {
+	c.subscriptionMu.Lock()
+	for topicPath, timer := range c.historyTimers {
+		timer.Stop()
+		delete(c.historyTimers, topicPath)
+	}
+	c.subscriptionMu.Unlock()
+
 	log.DefaultLogger.Info("MQTT Disconnecting")
-	c.client.Disconnect(250)
+	c.conn.Disconnect()
 }
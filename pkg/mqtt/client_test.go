@@ -1,12 +1,16 @@
 package mqtt
 
 import (
+	"context"
+	"fmt"
 	"path"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
 )
 
 // Mock client that implements our Client interface directly
@@ -24,7 +28,7 @@ func (m *mockClient) IsConnected() bool {
 	return m.connected
 }
 
-func (m *mockClient) Subscribe(reqPath string, logger log.Logger) (*Topic, error) {
+func (m *mockClient) Subscribe(reqPath string, qos byte, logger log.Logger) (*Topic, error) {
 	// Check if already exists
 	if existingTopic, ok := m.topics.Load(reqPath); ok {
 		return existingTopic, nil
@@ -43,7 +47,6 @@ func (m *mockClient) Subscribe(reqPath string, logger log.Logger) (*Topic, error
 	t := &Topic{
 		Path:     topicPath,
 		Interval: interval,
-		Messages: []Message{},
 	}
 
 	// Track MQTT subscription (simplified for testing)
@@ -53,8 +56,8 @@ func (m *mockClient) Subscribe(reqPath string, logger log.Logger) (*Topic, error
 	// For testing, assume we decode the topic properly
 	m.subscriptions["test/topic"] = true
 
-	// Store with reqPath as key
-	m.topics.Map.Store(reqPath, t)
+	// The key of the topic is reqPath
+	m.topics.Store(t)
 	return t, nil
 }
 
@@ -120,7 +123,7 @@ func TestClient_Subscribe_WithStreamingKey(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			topic, err := c.Subscribe(tt.reqPath, log.DefaultLogger)
+			topic, err := c.Subscribe(tt.reqPath, 0, log.DefaultLogger)
 			if err != nil && tt.expectTopic {
 				t.Fatalf("Subscribe failed: %v", err)
 			}
@@ -155,7 +158,7 @@ func TestClient_Subscribe_Deduplication(t *testing.T) {
 	reqPath := "1s/dGVzdC90b3BpYw/user1/hash123/org456"
 
 	// Subscribe first time
-	topic1, err := c.Subscribe(reqPath, log.DefaultLogger)
+	topic1, err := c.Subscribe(reqPath, 0, log.DefaultLogger)
 	if err != nil {
 		t.Fatalf("Subscribe failed: %v", err)
 	}
@@ -164,7 +167,7 @@ func TestClient_Subscribe_Deduplication(t *testing.T) {
 	}
 
 	// Subscribe second time - should return same topic
-	topic2, err := c.Subscribe(reqPath, log.DefaultLogger)
+	topic2, err := c.Subscribe(reqPath, 0, log.DefaultLogger)
 	if err != nil {
 		t.Fatalf("Subscribe failed: %v", err)
 	}
@@ -177,12 +180,7 @@ func TestClient_Subscribe_Deduplication(t *testing.T) {
 	}
 
 	// Verify only one topic is stored
-	count := 0
-	c.topics.Range(func(key, value any) bool {
-		count++
-		return true
-	})
-
+	count := c.topics.Len()
 	if count != 1 {
 		t.Errorf("Expected 1 stored topic, got %d", count)
 	}
@@ -196,15 +194,15 @@ func TestClient_Subscribe_MultipleStreamingKeys(t *testing.T) {
 	reqPath2 := "1s/dGVzdC90b3BpYw/user2/hash456/org456"
 	reqPath3 := "1s/dGVzdC90b3BpYw/user1/hash123/org789"
 
-	topic1, err := c.Subscribe(reqPath1, log.DefaultLogger)
+	topic1, err := c.Subscribe(reqPath1, 0, log.DefaultLogger)
 	if err != nil {
 		t.Fatalf("Subscribe failed: %v", err)
 	}
-	topic2, err := c.Subscribe(reqPath2, log.DefaultLogger)
+	topic2, err := c.Subscribe(reqPath2, 0, log.DefaultLogger)
 	if err != nil {
 		t.Fatalf("Subscribe failed: %v", err)
 	}
-	topic3, err := c.Subscribe(reqPath3, log.DefaultLogger)
+	topic3, err := c.Subscribe(reqPath3, 0, log.DefaultLogger)
 	if err != nil {
 		t.Fatalf("Subscribe failed: %v", err)
 	}
@@ -219,12 +217,7 @@ func TestClient_Subscribe_MultipleStreamingKeys(t *testing.T) {
 	}
 
 	// Verify all three topics are stored separately
-	count := 0
-	c.topics.Range(func(key, value any) bool {
-		count++
-		return true
-	})
-
+	count := c.topics.Len()
 	if count != 3 {
 		t.Errorf("Expected 3 stored topics, got %d", count)
 	}
@@ -255,7 +248,7 @@ func TestClient_GetTopic(t *testing.T) {
 	}
 
 	// Create topic
-	topic, err := c.Subscribe(reqPath, log.DefaultLogger)
+	topic, err := c.Subscribe(reqPath, 0, log.DefaultLogger)
 	if err != nil {
 		t.Fatalf("Subscribe failed: %v", err)
 	}
@@ -281,11 +274,11 @@ func TestClient_MessageHandling_WithStreamingKeys(t *testing.T) {
 	reqPath1 := "1s/dGVzdC90b3BpYw/user1/hash123/org456"
 	reqPath2 := "1s/dGVzdC90b3BpYw/user2/hash456/org456"
 
-	topic1, err := c.Subscribe(reqPath1, log.DefaultLogger)
+	topic1, err := c.Subscribe(reqPath1, 0, log.DefaultLogger)
 	if err != nil {
 		t.Fatalf("Subscribe failed: %v", err)
 	}
-	topic2, err := c.Subscribe(reqPath2, log.DefaultLogger)
+	topic2, err := c.Subscribe(reqPath2, 0, log.DefaultLogger)
 	if err != nil {
 		t.Fatalf("Subscribe failed: %v", err)
 	}
@@ -302,10 +295,369 @@ func TestClient_MessageHandling_WithStreamingKeys(t *testing.T) {
 	updatedTopic1, _ := c.GetTopic(reqPath1)
 	updatedTopic2, _ := c.GetTopic(reqPath2)
 
-	if len(updatedTopic1.Messages) != 1 {
-		t.Errorf("Expected 1 message in topic1, got %d", len(updatedTopic1.Messages))
+	if len(updatedTopic1.Messages()) != 1 {
+		t.Errorf("Expected 1 message in topic1, got %d", len(updatedTopic1.Messages()))
+	}
+	if len(updatedTopic2.Messages()) != 0 {
+		t.Errorf("Expected 0 messages in topic2, got %d", len(updatedTopic2.Messages()))
+	}
+}
+
+// fakeConn records the subscriptions made on the broker.
+type fakeConn struct {
+	subscriptions map[string]byte
+	handlers      map[string]messageHandler
+	subscribes    int
+	published     []fakePublish
+}
+
+type fakePublish struct {
+	topic   string
+	payload string
+	qos     byte
+	retain  bool
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{
+		subscriptions: make(map[string]byte),
+		handlers:      make(map[string]messageHandler),
 	}
-	if len(updatedTopic2.Messages) != 0 {
-		t.Errorf("Expected 0 messages in topic2, got %d", len(updatedTopic2.Messages))
+}
+
+func (f *fakeConn) IsConnected() bool { return true }
+
+// Subscribe fails when a second handler is registered for a topic, because
+// the MQTT v5 connection would deliver each message to both handlers.
+func (f *fakeConn) Subscribe(topic string, qos byte, handler messageHandler) error {
+	if handler == nil {
+		if _, ok := f.subscriptions[topic]; !ok {
+			return fmt.Errorf("no subscription to change the QoS of: %s", topic)
+		}
+	} else {
+		if _, ok := f.handlers[topic]; ok {
+			return fmt.Errorf("handler already registered for topic: %s", topic)
+		}
+		f.handlers[topic] = handler
+	}
+	f.subscribes++
+	f.subscriptions[topic] = qos
+	return nil
+}
+
+func (f *fakeConn) Unsubscribe(topic string) error {
+	delete(f.subscriptions, topic)
+	delete(f.handlers, topic)
+	return nil
+}
+
+func (f *fakeConn) Publish(topic string, payload []byte, qos byte, retain bool) error {
+	f.published = append(f.published, fakePublish{topic: topic, payload: string(payload), qos: qos, retain: retain})
+	return nil
+}
+
+func (f *fakeConn) Disconnect() {}
+
+func TestClient_Subscribe_QoS(t *testing.T) {
+	conn := newFakeConn()
+	c := &client{conn: conn}
+	logger := log.DefaultLogger
+
+	// "dGVzdC90b3BpYw" is the encoded "test/topic"
+	reqPath1 := "1s/dGVzdC90b3BpYw/user1/hash123/org456"
+	reqPath2 := "1s/dGVzdC90b3BpYw/user2/hash456/org456"
+	reqPath3 := "1s/dGVzdC90b3BpYw/user3/hash789/org456"
+
+	_, err := c.Subscribe(reqPath1, 0, logger)
+	require.NoError(t, err)
+	require.Equal(t, map[string]byte{"test/topic": 0}, conn.subscriptions)
+
+	// a higher QoS upgrades the subscription on the broker
+	_, err = c.Subscribe(reqPath2, 2, logger)
+	require.NoError(t, err)
+	require.Equal(t, map[string]byte{"test/topic": 2}, conn.subscriptions)
+	require.Equal(t, 2, conn.subscribes)
+
+	// a lower QoS reuses the existing subscription
+	_, err = c.Subscribe(reqPath3, 1, logger)
+	require.NoError(t, err)
+	require.Equal(t, map[string]byte{"test/topic": 2}, conn.subscriptions)
+	require.Equal(t, 2, conn.subscribes)
+
+	// removing the topic with the highest QoS downgrades the subscription
+	require.NoError(t, c.Unsubscribe(reqPath2, logger))
+	require.Equal(t, map[string]byte{"test/topic": 1}, conn.subscriptions)
+
+	require.NoError(t, c.Unsubscribe(reqPath3, logger))
+	require.Equal(t, map[string]byte{"test/topic": 0}, conn.subscriptions)
+
+	require.NoError(t, c.Unsubscribe(reqPath1, logger))
+	require.Empty(t, conn.subscriptions)
+}
+
+func TestClient_Subscribe_InvalidQoS(t *testing.T) {
+	conn := newFakeConn()
+	c := &client{conn: conn}
+
+	_, err := c.Subscribe("1s/dGVzdC90b3BpYw", 3, log.DefaultLogger)
+	require.EqualError(t, err, "invalid QoS level 3: must be 0, 1 or 2")
+	require.Empty(t, conn.subscriptions)
+}
+
+func TestClient_Subscribe_SharedSubscription(t *testing.T) {
+	conn := newFakeConn()
+	c := &client{conn: conn}
+
+	reqPath1 := "1s/dGVzdC90b3BpYw/user1/hash123/org456"
+	reqPath2 := "5s/dGVzdC90b3BpYw/user2/hash456/org456"
+
+	topic1, err := c.Subscribe(reqPath1, 0, log.DefaultLogger)
+	require.NoError(t, err)
+	topic2, err := c.Subscribe(reqPath2, 0, log.DefaultLogger)
+	require.NoError(t, err)
+	require.Equal(t, reqPath1, topic1.Key())
+	require.Equal(t, reqPath2, topic2.Key())
+
+	// messages on the broker subscription are delivered to both topics
+	conn.handlers["test/topic"]("test/topic", []byte("1"))
+	require.Len(t, topic1.Messages(), 1)
+	require.Len(t, topic2.Messages(), 1)
+	require.Equal(t, "test/topic", topic1.Messages()[0].Topic)
+}
+
+func TestClient_HandleMessage_Concurrent(t *testing.T) {
+	const (
+		publishers = 8
+		messages   = 5000
+	)
+
+	conn := newFakeConn()
+	c := &client{conn: conn, bufferLimits: BufferLimits{MaxMessages: publishers * messages}}
+	logger := log.DefaultLogger
+
+	// "dGVzdC90b3BpYw" is the encoded "test/topic"
+	topic, err := c.Subscribe("1s/dGVzdC90b3BpYw/user1/hash123/org456", 0, logger)
+	require.NoError(t, err)
+	handler := conn.handlers["test/topic"]
+
+	var wg sync.WaitGroup
+	for p := range publishers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range messages {
+				handler("test/topic", []byte(fmt.Sprintf("%d-%d", p, i)))
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	received := make(map[string]int)
+	frames := 0
+	drain := func() {
+		batch, dropped := topic.drain()
+		require.Zero(t, dropped)
+		for _, m := range batch {
+			received[string(m.Value)]++
+		}
+		frames++
+	}
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			drain()
+		}
+	}
+	// the messages added after the last drain
+	drain()
+
+	require.Len(t, received, publishers*messages, "messages were lost")
+	for value, count := range received {
+		require.Equal(t, 1, count, "message %s was received more than once", value)
+	}
+	t.Logf("received %d messages in %d drains", len(received), frames)
+}
+
+func TestClient_History(t *testing.T) {
+	conn := newFakeConn()
+	c := &client{conn: conn, historyRetention: time.Hour, historyTimers: make(map[string]*time.Timer)}
+	defer c.Dispose()
+	logger := log.DefaultLogger
+
+	t.Run("subscribes on demand", func(t *testing.T) {
+		// "dGVzdC90b3BpYw" is the encoded "test/topic"
+		messages, err := c.History("dGVzdC90b3BpYw", 1, logger)
+		require.NoError(t, err)
+		require.Empty(t, messages)
+		require.Equal(t, map[string]byte{"test/topic": 1}, conn.subscriptions)
+
+		conn.handlers["test/topic"]("test/topic", []byte("21.5"))
+
+		messages, err = c.History("dGVzdC90b3BpYw", 1, logger)
+		require.NoError(t, err)
+		require.Equal(t, []string{"21.5"}, values(messages))
+	})
+
+	t.Run("keeps the messages of streams of queried paths", func(t *testing.T) {
+		// "c2Vuc29yL2h1bWlkaXR5" is the encoded "sensor/humidity"
+		_, err := c.History("c2Vuc29yL2h1bWlkaXR5", 0, logger)
+		require.NoError(t, err)
+
+		reqPath := "1s/c2Vuc29yL2h1bWlkaXR5/user1/hash123/org456"
+		_, err = c.Subscribe(reqPath, 0, logger)
+		require.NoError(t, err)
+
+		conn.handlers["sensor/humidity"]("sensor/humidity", []byte("40"))
+
+		// the broker subscription is kept for the history after the stream ends
+		require.NoError(t, c.Unsubscribe(reqPath, logger))
+		require.Contains(t, conn.subscriptions, "sensor/humidity")
+
+		messages, err := c.History("c2Vuc29yL2h1bWlkaXR5", 0, logger)
+		require.NoError(t, err)
+		require.Equal(t, []string{"40"}, values(messages))
+	})
+
+	t.Run("expires without queries", func(t *testing.T) {
+		c.expireHistory("c2Vuc29yL2h1bWlkaXR5", logger)
+		require.NotContains(t, conn.subscriptions, "sensor/humidity")
+		_, found := c.GetTopic(historyKey("c2Vuc29yL2h1bWlkaXR5"))
+		require.False(t, found)
+	})
+
+	t.Run("is kept while subscribed", func(t *testing.T) {
+		reqPath := "1s/dGVzdC90b3BpYw/user1/hash123/org456"
+		_, err := c.Subscribe(reqPath, 0, logger)
+		require.NoError(t, err)
+
+		c.expireHistory("dGVzdC90b3BpYw", logger)
+		_, found := c.GetTopic(historyKey("dGVzdC90b3BpYw"))
+		require.True(t, found)
+	})
+}
+
+func TestClient_Unsubscribe_Stream(t *testing.T) {
+	conn := newFakeConn()
+	c := &client{conn: conn, historyRetention: time.Hour, historyTimers: make(map[string]*time.Timer)}
+	defer c.Dispose()
+	logger := log.DefaultLogger
+
+	// streams don't keep a history, so the path is unsubscribed from with the stream
+	reqPath := "1s/dGVzdC90b3BpYw/user1/hash123/org456"
+	_, err := c.Subscribe(reqPath, 0, logger)
+	require.NoError(t, err)
+	require.Equal(t, map[string]byte{"test/topic": 0}, conn.subscriptions)
+
+	require.NoError(t, c.Unsubscribe(reqPath, logger))
+	require.Empty(t, conn.subscriptions)
+	_, found := c.GetTopic(historyKey("dGVzdC90b3BpYw"))
+	require.False(t, found)
+}
+
+func TestClient_History_Disabled(t *testing.T) {
+	conn := newFakeConn()
+	c := &client{conn: conn}
+
+	messages, err := c.History("dGVzdC90b3BpYw", 0, log.DefaultLogger)
+	require.NoError(t, err)
+	require.Nil(t, messages)
+	require.Empty(t, conn.subscriptions)
+}
+
+func TestParseHistoryRetention(t *testing.T) {
+	d, err := parseHistoryRetention("30m")
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Minute, d)
+
+	d, err = parseHistoryRetention("")
+	require.NoError(t, err)
+	require.Equal(t, DefaultHistoryRetention, d)
+
+	d, err = parseHistoryRetention("0")
+	require.NoError(t, err)
+	require.Zero(t, d)
+
+	_, err = parseHistoryRetention("forever")
+	require.EqualError(t, err, `invalid history retention "forever": must be a duration such as 1h`)
+}
+
+func TestClient_WaitForMessages(t *testing.T) {
+	conn := newFakeConn()
+	c := &client{conn: conn, historyRetention: time.Hour, historyTimers: make(map[string]*time.Timer)}
+	defer c.Dispose()
+	logger := log.DefaultLogger
+
+	t.Run("times out without messages", func(t *testing.T) {
+		// "dGVzdC90b3BpYw" is the encoded "test/topic"
+		messages, err := c.WaitForMessages(context.Background(), "dGVzdC90b3BpYw", 0, 10*time.Millisecond, logger)
+		require.NoError(t, err)
+		require.Empty(t, messages)
+	})
+
+	t.Run("waits for a message", func(t *testing.T) {
+		handler := conn.handlers["test/topic"]
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			handler("test/topic", []byte("21.5"))
+		}()
+
+		messages, err := c.WaitForMessages(context.Background(), "dGVzdC90b3BpYw", 0, 10*time.Second, logger)
+		require.NoError(t, err)
+		require.Equal(t, []string{"21.5"}, values(messages))
+	})
+
+	t.Run("returns the history without waiting", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		messages, err := c.WaitForMessages(ctx, "dGVzdC90b3BpYw", 0, 10*time.Second, logger)
+		require.NoError(t, err)
+		require.Equal(t, []string{"21.5"}, values(messages))
+	})
+
+	t.Run("subscribes while waiting without the history", func(t *testing.T) {
+		conn := newFakeConn()
+		c := &client{conn: conn}
+		messages, err := c.WaitForMessages(context.Background(), "dGVzdC90b3BpYw", 1, 10*time.Millisecond, logger)
+		require.NoError(t, err)
+		require.Empty(t, messages)
+		require.Equal(t, 1, conn.subscribes)
+		require.Empty(t, conn.subscriptions)
+	})
+}
+
+func TestClient_Publish(t *testing.T) {
+	conn := newFakeConn()
+	c := &client{conn: conn}
+	logger := log.DefaultLogger
+
+	require.NoError(t, c.Publish("plant/line1/setpoint", []byte("21.5"), 1, true, logger))
+	require.Equal(t, []fakePublish{{topic: "plant/line1/setpoint", payload: "21.5", qos: 1, retain: true}}, conn.published)
+
+	require.EqualError(t, c.Publish("plant/+/setpoint", []byte("21.5"), 0, false, logger), `invalid MQTT topic name "plant/+/setpoint": must not be empty or contain wildcards`)
+	require.EqualError(t, c.Publish("plant/line1/setpoint", []byte("21.5"), 3, false, logger), "invalid QoS level 3: must be 0, 1 or 2")
+}
+
+func TestPublishOptions_CanPublish(t *testing.T) {
+	o := PublishOptions{
+		PublishEnabled:       true,
+		PublishAllowedTopics: []string{"plant/+/setpoint", "commands/#"},
 	}
+
+	require.True(t, o.CanPublish("plant/line1/setpoint"))
+	require.True(t, o.CanPublish("commands/restart"))
+	require.False(t, o.CanPublish("plant/line1/temperature"))
+
+	o.PublishEnabled = false
+	require.False(t, o.CanPublish("plant/line1/setpoint"))
+
+	require.False(t, PublishOptions{PublishEnabled: true}.CanPublish("plant/line1/setpoint"))
 }
@@ -0,0 +1,100 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// v3Conn is a connection to an MQTT v3.1/v3.1.1 broker.
+type v3Conn struct {
+	client paho.Client
+}
+
+func newV3Conn(ctx context.Context, o Options, clientID string, tlsConfig *tls.Config, settings backend.DataSourceInstanceSettings, logger log.Logger) (*v3Conn, error) {
+	opts := paho.NewClientOptions()
+
+	opts.AddBroker(o.URI)
+	opts.SetClientID(clientID)
+
+	if o.ProtocolVersion != 0 {
+		opts.SetProtocolVersion(o.ProtocolVersion)
+	}
+
+	if o.Username != "" {
+		opts.SetUsername(o.Username)
+	}
+
+	if o.Password != "" {
+		opts.SetPassword(o.Password)
+	}
+
+	opts.SetTLSConfig(tlsConfig)
+	opts.SetPingTimeout(60 * time.Second)
+	opts.SetKeepAlive(60 * time.Second)
+	opts.SetAutoReconnect(true)
+	opts.SetCleanSession(false)
+	opts.SetMaxReconnectInterval(10 * time.Second)
+	opts.SetConnectionLostHandler(func(c paho.Client, err error) {
+		logger.Warn("MQTT Connection lost", "error", err)
+	})
+	opts.SetReconnectingHandler(func(c paho.Client, options *paho.ClientOptions) {
+		logger.Debug("MQTT Reconnecting")
+	})
+
+	// Configure PDC (Private Datasource Connect) if enabled
+	if err := configureProxyIfEnabled(ctx, opts, settings, logger); err != nil {
+		return nil, err
+	}
+
+	logger.Info("MQTT Connecting", "clientID", clientID)
+
+	pahoClient := paho.NewClient(opts)
+	if token := pahoClient.Connect(); token.Wait() && token.Error() != nil {
+		return nil, backend.DownstreamErrorf("error connecting to MQTT broker: %s", token.Error())
+	}
+
+	return &v3Conn{
+		client: pahoClient,
+	}, nil
+}
+
+func (c *v3Conn) IsConnected() bool {
+	return c.client.IsConnectionOpen()
+}
+
+func (c *v3Conn) Subscribe(topic string, qos byte, handler messageHandler) error {
+	// paho keeps the route of the topic when the callback is nil
+	var callback paho.MessageHandler
+	if handler != nil {
+		callback = func(_ paho.Client, m paho.Message) {
+			handler(m.Topic(), m.Payload())
+		}
+	}
+	if token := c.client.Subscribe(topic, qos, callback); token.Wait() && token.Error() != nil {
+		return backend.DownstreamErrorf("error subscribing to MQTT topic %s: %s", topic, token.Error())
+	}
+	return nil
+}
+
+func (c *v3Conn) Unsubscribe(topic string) error {
+	if token := c.client.Unsubscribe(topic); token.Wait() && token.Error() != nil {
+		return backend.DownstreamErrorf("error unsubscribing from MQTT topic %s: %s", topic, token.Error())
+	}
+	return nil
+}
+
+func (c *v3Conn) Publish(topic string, payload []byte, qos byte, retain bool) error {
+	if token := c.client.Publish(topic, qos, retain, payload); token.Wait() && token.Error() != nil {
+		return backend.DownstreamErrorf("error publishing to MQTT topic %s: %s", topic, token.Error())
+	}
+	return nil
+}
+
+func (c *v3Conn) Disconnect() {
+	c.client.Disconnect(250)
+}
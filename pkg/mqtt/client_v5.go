@@ -0,0 +1,328 @@
+package mqtt
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+const (
+	v5ConnectTimeout = 30 * time.Second
+	v5RequestTimeout = 10 * time.Second
+)
+
+// v5Conn is a connection to an MQTT v5 broker.
+type v5Conn struct {
+	cm        *autopaho.ConnectionManager
+	router    *paho.StandardRouter
+	connected atomic.Bool
+	logger    log.Logger
+
+	mu sync.Mutex
+	// subscriptions holds the active subscriptions so that they can be restored
+	// when the broker did not keep the session after a reconnect.
+	subscriptions map[string]byte
+	connectErr    error
+}
+
+func newV5Conn(ctx context.Context, o Options, clientID string, tlsConfig *tls.Config, settings backend.DataSourceInstanceSettings, logger log.Logger) (*v5Conn, error) {
+	serverURL, err := url.Parse(o.URI)
+	if err != nil {
+		return nil, backend.DownstreamErrorf("invalid MQTT broker URI %s: %s", o.URI, err)
+	}
+
+	c := &v5Conn{
+		router:        paho.NewStandardRouter(),
+		logger:        logger,
+		subscriptions: make(map[string]byte),
+	}
+
+	cfg := autopaho.ClientConfig{
+		ServerUrls:                    []*url.URL{serverURL},
+		TlsCfg:                        tlsConfig,
+		KeepAlive:                     60,
+		CleanStartOnInitialConnection: false,
+		ReconnectBackoff:              autopaho.NewExponentialBackoff(time.Second, 10*time.Second, 2*time.Second, 2),
+		OnConnectionUp:                c.onConnectionUp,
+		OnConnectionDown:              c.onConnectionDown,
+		OnConnectError:                c.onConnectError,
+		ClientConfig: paho.ClientConfig{
+			ClientID: clientID,
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){
+				func(pr paho.PublishReceived) (bool, error) {
+					c.router.Route(pr.Packet.Packet())
+					return true, nil
+				},
+			},
+			OnServerDisconnect: func(d *paho.Disconnect) {
+				logger.Warn("MQTT Server requested disconnect", "reason", reasonCodeText(d.ReasonCode))
+			},
+		},
+	}
+	cfg.SetUsernamePassword(o.Username, []byte(o.Password))
+
+	// Configure PDC (Private Datasource Connect) if enabled
+	proxyDialer, err := newProxyDialerIfEnabled(ctx, settings, logger)
+	if err != nil {
+		return nil, err
+	}
+	if proxyDialer != nil {
+		cfg.AttemptConnection = newProxyAttemptConnectionFunc(proxyDialer, logger)
+	}
+
+	logger.Info("MQTT Connecting", "clientID", clientID, "protocolVersion", ProtocolVersion5)
+
+	// The connection manager outlives the request that created the datasource instance,
+	// so it must not be bound to ctx. It is stopped in Disconnect.
+	cm, err := autopaho.NewConnection(context.Background(), cfg)
+	if err != nil {
+		return nil, backend.DownstreamErrorf("error connecting to MQTT broker: %s", err)
+	}
+	c.cm = cm
+
+	connectCtx, cancel := context.WithTimeout(ctx, v5ConnectTimeout)
+	defer cancel()
+	if err := cm.AwaitConnection(connectCtx); err != nil {
+		c.Disconnect()
+		c.mu.Lock()
+		if c.connectErr != nil {
+			err = c.connectErr
+		}
+		c.mu.Unlock()
+		return nil, backend.DownstreamErrorf("error connecting to MQTT broker: %s", err)
+	}
+
+	return c, nil
+}
+
+func (c *v5Conn) onConnectionUp(cm *autopaho.ConnectionManager, connack *paho.Connack) {
+	c.connected.Store(true)
+	if connack.SessionPresent {
+		return
+	}
+
+	c.mu.Lock()
+	subscriptions := maps.Clone(c.subscriptions)
+	c.mu.Unlock()
+
+	if len(subscriptions) == 0 {
+		return
+	}
+
+	// OnConnectionUp must not block, so restore the subscriptions in the background.
+	go func() {
+		for topic, qos := range subscriptions {
+			if err := c.subscribe(topic, qos); err != nil {
+				c.logger.Error("Failed to restore MQTT subscription", "topic", topic, "error", err)
+			}
+		}
+	}()
+}
+
+func (c *v5Conn) onConnectionDown() bool {
+	c.connected.Store(false)
+	c.logger.Warn("MQTT Connection lost")
+	c.logger.Debug("MQTT Reconnecting")
+	return true
+}
+
+func (c *v5Conn) onConnectError(err error) {
+	var connackErr *autopaho.ConnackError
+	if errors.As(err, &connackErr) {
+		err = reasonCodeError(connackErr.ReasonCode, connackErr.Reason)
+	}
+	c.logger.Debug("MQTT Connection attempt failed", "error", err)
+
+	c.mu.Lock()
+	c.connectErr = err
+	c.mu.Unlock()
+}
+
+func (c *v5Conn) IsConnected() bool {
+	return c.connected.Load()
+}
+
+func (c *v5Conn) Subscribe(topic string, qos byte, handler messageHandler) error {
+	// The router adds handlers instead of replacing them, so the handler is only
+	// registered for new subscriptions, or each message would be handled again.
+	if handler != nil {
+		c.router.RegisterHandler(topic, func(p *paho.Publish) {
+			handler(p.Topic, p.Payload)
+		})
+	}
+
+	if err := c.subscribe(topic, qos); err != nil {
+		if handler != nil {
+			c.router.UnregisterHandler(topic)
+		}
+		return err
+	}
+
+	c.mu.Lock()
+	c.subscriptions[topic] = qos
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *v5Conn) subscribe(topic string, qos byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), v5RequestTimeout)
+	defer cancel()
+
+	suback, err := c.cm.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{
+			{Topic: topic, QoS: qos},
+		},
+	})
+	if suback != nil {
+		for _, code := range suback.Reasons {
+			if code >= packets.SubackUnspecifiederror {
+				var reason string
+				if suback.Properties != nil {
+					reason = suback.Properties.ReasonString
+				}
+				return backend.DownstreamErrorf("error subscribing to MQTT topic %s: %w", topic, reasonCodeError(code, reason))
+			}
+		}
+	}
+	if err != nil {
+		return backend.DownstreamErrorf("error subscribing to MQTT topic %s: %s", topic, err)
+	}
+	return nil
+}
+
+func (c *v5Conn) Unsubscribe(topic string) error {
+	c.mu.Lock()
+	delete(c.subscriptions, topic)
+	c.mu.Unlock()
+	c.router.UnregisterHandler(topic)
+
+	ctx, cancel := context.WithTimeout(context.Background(), v5RequestTimeout)
+	defer cancel()
+
+	unsuback, err := c.cm.Unsubscribe(ctx, &paho.Unsubscribe{
+		Topics: []string{topic},
+	})
+	if unsuback != nil {
+		for _, code := range unsuback.Reasons {
+			if code >= packets.UnsubackUnspecifiedError {
+				var reason string
+				if unsuback.Properties != nil {
+					reason = unsuback.Properties.ReasonString
+				}
+				return backend.DownstreamErrorf("error unsubscribing from MQTT topic %s: %w", topic, reasonCodeError(code, reason))
+			}
+		}
+	}
+	if err != nil {
+		return backend.DownstreamErrorf("error unsubscribing from MQTT topic %s: %s", topic, err)
+	}
+	return nil
+}
+
+func (c *v5Conn) Publish(topic string, payload []byte, qos byte, retain bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), v5RequestTimeout)
+	defer cancel()
+
+	resp, err := c.cm.Publish(ctx, &paho.Publish{
+		Topic:   topic,
+		QoS:     qos,
+		Retain:  retain,
+		Payload: payload,
+	})
+	if resp != nil && resp.ReasonCode >= packets.PubackUnspecifiedError {
+		var reason string
+		if resp.Properties != nil {
+			reason = resp.Properties.ReasonString
+		}
+		return backend.DownstreamErrorf("error publishing to MQTT topic %s: %w", topic, reasonCodeError(resp.ReasonCode, reason))
+	}
+	if err != nil {
+		return backend.DownstreamErrorf("error publishing to MQTT topic %s: %s", topic, err)
+	}
+	return nil
+}
+
+func (c *v5Conn) Disconnect() {
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	_ = c.cm.Disconnect(ctx)
+	c.connected.Store(false)
+}
+
+// reasonCodes maps the MQTT v5 reason codes to their description in the specification.
+//
+//	https://docs.oasis-open.org/mqtt/mqtt/v5.0/os/mqtt-v5.0-os.html#_Toc3901031
+var reasonCodes = map[byte]string{
+	0x00: "success",
+	0x01: "granted QoS 1",
+	0x02: "granted QoS 2",
+	0x04: "disconnect with will message",
+	0x10: "no matching subscribers",
+	0x11: "no subscription existed",
+	0x18: "continue authentication",
+	0x19: "re-authenticate",
+	0x80: "unspecified error",
+	0x81: "malformed packet",
+	0x82: "protocol error",
+	0x83: "implementation specific error",
+	0x84: "unsupported protocol version",
+	0x85: "client identifier not valid",
+	0x86: "bad user name or password",
+	0x87: "not authorized",
+	0x88: "server unavailable",
+	0x89: "server busy",
+	0x8A: "banned",
+	0x8B: "server shutting down",
+	0x8C: "bad authentication method",
+	0x8D: "keep alive timeout",
+	0x8E: "session taken over",
+	0x8F: "topic filter invalid",
+	0x90: "topic name invalid",
+	0x91: "packet identifier in use",
+	0x92: "packet identifier not found",
+	0x93: "receive maximum exceeded",
+	0x94: "topic alias invalid",
+	0x95: "packet too large",
+	0x96: "message rate too high",
+	0x97: "quota exceeded",
+	0x98: "administrative action",
+	0x99: "payload format invalid",
+	0x9A: "retain not supported",
+	0x9B: "QoS not supported",
+	0x9C: "use another server",
+	0x9D: "server moved",
+	0x9E: "shared subscriptions not supported",
+	0x9F: "connection rate exceeded",
+	0xA0: "maximum connect time",
+	0xA1: "subscription identifiers not supported",
+	0xA2: "wildcard subscriptions not supported",
+}
+
+// reasonCodeText returns the description of an MQTT v5 reason code.
+func reasonCodeText(code byte) string {
+	if text, ok := reasonCodes[code]; ok {
+		return text
+	}
+	return "unknown reason"
+}
+
+// reasonCodeError converts an MQTT v5 reason code, and the optional reason string
+// sent by the broker, to an error.
+func reasonCodeError(code byte, reason string) error {
+	if reason != "" {
+		return fmt.Errorf("%s (reason code 0x%02X): %s", reasonCodeText(code), code, reason)
+	}
+	return fmt.Errorf("%s (reason code 0x%02X)", reasonCodeText(code), code)
+}
@@ -0,0 +1,50 @@
+package mqtt
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReasonCodeError(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     byte
+		reason   string
+		expected string
+	}{
+		{
+			name:     "known reason code",
+			code:     0x87,
+			expected: "not authorized (reason code 0x87)",
+		},
+		{
+			name:     "known reason code with reason string",
+			code:     0xA2,
+			reason:   "wildcards are disabled",
+			expected: "wildcard subscriptions not supported (reason code 0xA2): wildcards are disabled",
+		},
+		{
+			name:     "unknown reason code",
+			code:     0xFF,
+			expected: "unknown reason (reason code 0xFF)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.EqualError(t, reasonCodeError(tt.code, tt.reason), tt.expected)
+		})
+	}
+}
+
+func TestNewClient_UnsupportedProtocolVersion(t *testing.T) {
+	_, err := NewClient(context.Background(), Options{
+		URI:             "tcp://localhost:1883",
+		ProtocolVersion: 6,
+	}, backend.DataSourceInstanceSettings{})
+	require.EqualError(t, err, "unsupported MQTT protocol version: 6")
+	require.True(t, backend.IsDownstreamError(err))
+}
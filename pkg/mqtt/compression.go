@@ -0,0 +1,153 @@
+package mqtt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressions of the payloads of received messages.
+const (
+	// CompressionAuto detects gzip, zlib, zstd and framed snappy payloads by their header.
+	// Other payloads aren't decompressed.
+	CompressionAuto   = "auto"
+	CompressionGzip   = "gzip"
+	CompressionZlib   = "zlib"
+	CompressionZstd   = "zstd"
+	CompressionSnappy = "snappy"
+)
+
+// DefaultMaxDecompressedBytes is the default size limit of decompressed payloads.
+const DefaultMaxDecompressedBytes = 16 * 1024 * 1024
+
+// zstdMaxWindow limits the window of zstd payloads, which is the memory needed to
+// decompress them. This is the largest window of the default zstd compression levels.
+const zstdMaxWindow = 8 * 1024 * 1024
+
+// errDecompressedSizeExceeded is returned for payloads that decompress to more than
+// the size limit, such as decompression bombs.
+var errDecompressedSizeExceeded = errors.New("decompressed payload exceeds the size limit")
+
+var (
+	zstdMagic         = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	snappyStreamMagic = []byte("\xff\x06\x00\x00sNaPpY")
+)
+
+// DecompressionOptions control the decompression of the payloads of received messages,
+// before they are decoded.
+type DecompressionOptions struct {
+	// Compression is the compression of the payloads: "auto", "gzip", "zlib", "zstd" or
+	// "snappy". Payloads aren't decompressed if empty.
+	Compression string `json:"compression,omitempty"`
+	// MaxDecompressedBytes limits the size of decompressed payloads. Messages that
+	// exceed it are dropped. Zero uses DefaultMaxDecompressedBytes.
+	MaxDecompressedBytes int `json:"maxDecompressedBytes,omitempty"`
+}
+
+// decompressor decompresses the payloads of received messages.
+type decompressor struct {
+	compression string
+	maxBytes    int
+}
+
+// newDecompressor returns a decompressor for the options, or nil if payloads aren't decompressed.
+func newDecompressor(o DecompressionOptions) (*decompressor, error) {
+	switch o.Compression {
+	case "":
+		return nil, nil
+	case CompressionAuto, CompressionGzip, CompressionZlib, CompressionZstd, CompressionSnappy:
+	default:
+		return nil, backend.DownstreamErrorf("invalid compression %q: must be auto, gzip, zlib, zstd or snappy", o.Compression)
+	}
+
+	d := &decompressor{compression: o.Compression, maxBytes: o.MaxDecompressedBytes}
+	if d.maxBytes <= 0 {
+		d.maxBytes = DefaultMaxDecompressedBytes
+	}
+	return d, nil
+}
+
+// detectCompression returns the compression of the payload from its header,
+// or an empty string if it isn't compressed.
+func detectCompression(payload []byte) string {
+	switch {
+	case len(payload) > 2 && payload[0] == 0x1f && payload[1] == 0x8b:
+		return CompressionGzip
+	case bytes.HasPrefix(payload, zstdMagic):
+		return CompressionZstd
+	case bytes.HasPrefix(payload, snappyStreamMagic):
+		return CompressionSnappy
+	case len(payload) > 2 && payload[0]&0x0f == 8 && payload[0]>>4 <= 7 && payload[1]&0x20 == 0 &&
+		(uint16(payload[0])<<8|uint16(payload[1]))%31 == 0:
+		// deflate without a preset dictionary, and a valid header checksum
+		return CompressionZlib
+	}
+	return ""
+}
+
+// decompress returns the decompressed payload. Payloads that aren't detected as compressed
+// with the auto compression are returned as is.
+func (d *decompressor) decompress(payload []byte) ([]byte, error) {
+	compression := d.compression
+	if compression == CompressionAuto {
+		if compression = detectCompression(payload); compression == "" {
+			return payload, nil
+		}
+	}
+
+	switch compression {
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		return d.readAll(r)
+	case CompressionZlib:
+		r, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		return d.readAll(r)
+	case CompressionZstd:
+		r, err := zstd.NewReader(bytes.NewReader(payload),
+			zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true), zstd.WithDecoderMaxWindow(zstdMaxWindow))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return d.readAll(r)
+	case CompressionSnappy:
+		if bytes.HasPrefix(payload, snappyStreamMagic) {
+			return d.readAll(snappy.NewReader(bytes.NewReader(payload)))
+		}
+		// the block format starts with the decompressed size
+		n, err := snappy.DecodedLen(payload)
+		if err != nil {
+			return nil, err
+		}
+		if n > d.maxBytes {
+			return nil, errDecompressedSizeExceeded
+		}
+		return snappy.Decode(nil, payload)
+	}
+	return nil, fmt.Errorf("unknown compression %q", compression)
+}
+
+// readAll reads the decompressed payload, up to the size limit.
+func (d *decompressor) readAll(r io.Reader) ([]byte, error) {
+	value, err := io.ReadAll(io.LimitReader(r, int64(d.maxBytes)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(value) > d.maxBytes {
+		return nil, errDecompressedSizeExceeded
+	}
+	return value, nil
+}
@@ -0,0 +1,144 @@
+package mqtt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func compress(t *testing.T, compression string, payload []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	switch compression {
+	case CompressionGzip:
+		w := gzip.NewWriter(&b)
+		_, err := w.Write(payload)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	case CompressionZlib:
+		w := zlib.NewWriter(&b)
+		_, err := w.Write(payload)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	case CompressionZstd:
+		w, err := zstd.NewWriter(&b)
+		require.NoError(t, err)
+		_, err = w.Write(payload)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	case CompressionSnappy:
+		w := snappy.NewBufferedWriter(&b)
+		_, err := w.Write(payload)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+	}
+	return b.Bytes()
+}
+
+func TestNewDecompressor(t *testing.T) {
+	d, err := newDecompressor(DecompressionOptions{})
+	require.NoError(t, err)
+	require.Nil(t, d)
+
+	d, err = newDecompressor(DecompressionOptions{Compression: CompressionGzip})
+	require.NoError(t, err)
+	require.Equal(t, DefaultMaxDecompressedBytes, d.maxBytes)
+
+	_, err = newDecompressor(DecompressionOptions{Compression: "brotli"})
+	require.EqualError(t, err, `invalid compression "brotli": must be auto, gzip, zlib, zstd or snappy`)
+}
+
+func TestDecompressor(t *testing.T) {
+	payload := []byte(`{"temperature":21.5,"humidity":40}`)
+
+	for _, compression := range []string{CompressionGzip, CompressionZlib, CompressionZstd, CompressionSnappy} {
+		t.Run(compression, func(t *testing.T) {
+			compressed := compress(t, compression, payload)
+			require.Equal(t, compression, detectCompression(compressed))
+
+			for _, c := range []string{compression, CompressionAuto} {
+				d, err := newDecompressor(DecompressionOptions{Compression: c})
+				require.NoError(t, err)
+
+				value, err := d.decompress(compressed)
+				require.NoError(t, err)
+				require.Equal(t, payload, value)
+			}
+		})
+	}
+
+	t.Run("snappy block", func(t *testing.T) {
+		d, err := newDecompressor(DecompressionOptions{Compression: CompressionSnappy})
+		require.NoError(t, err)
+		value, err := d.decompress(snappy.Encode(nil, payload))
+		require.NoError(t, err)
+		require.Equal(t, payload, value)
+	})
+
+	t.Run("uncompressed", func(t *testing.T) {
+		d, err := newDecompressor(DecompressionOptions{Compression: CompressionAuto})
+		require.NoError(t, err)
+		for _, p := range [][]byte{nil, payload, []byte("21.5"), []byte("online")} {
+			require.Empty(t, detectCompression(p), string(p))
+			value, err := d.decompress(p)
+			require.NoError(t, err)
+			require.Equal(t, p, value)
+		}
+
+		d, err = newDecompressor(DecompressionOptions{Compression: CompressionGzip})
+		require.NoError(t, err)
+		_, err = d.decompress(payload)
+		require.Error(t, err)
+	})
+}
+
+func TestDecompressor_SizeLimit(t *testing.T) {
+	bomb := []byte(strings.Repeat("0", 1024*1024))
+
+	for _, compression := range []string{CompressionGzip, CompressionZlib, CompressionZstd, CompressionSnappy} {
+		t.Run(compression, func(t *testing.T) {
+			d, err := newDecompressor(DecompressionOptions{Compression: compression, MaxDecompressedBytes: 1024})
+			require.NoError(t, err)
+
+			_, err = d.decompress(compress(t, compression, bomb))
+			require.ErrorIs(t, err, errDecompressedSizeExceeded)
+
+			value, err := d.decompress(compress(t, compression, bomb[:1024]))
+			require.NoError(t, err)
+			require.Len(t, value, 1024)
+		})
+	}
+
+	d, err := newDecompressor(DecompressionOptions{Compression: CompressionSnappy, MaxDecompressedBytes: 1024})
+	require.NoError(t, err)
+	_, err = d.decompress(snappy.Encode(nil, bomb))
+	require.ErrorIs(t, err, errDecompressedSizeExceeded)
+}
+
+func TestClient_HandleMessage_Decompression(t *testing.T) {
+	conn := newFakeConn()
+	d, err := newDecompressor(DecompressionOptions{Compression: CompressionAuto, MaxDecompressedBytes: 1024})
+	require.NoError(t, err)
+	c := &client{conn: conn, decompressor: d}
+	defer c.Dispose()
+
+	// "dGVzdC90b3BpYw" is the encoded "test/topic"
+	topic, err := c.Subscribe("1s/dGVzdC90b3BpYw/user1/hash123/org456", 0, log.DefaultLogger)
+	require.NoError(t, err)
+	handler := conn.handlers["test/topic"]
+
+	handler("test/topic", compress(t, CompressionGzip, []byte(`{"temperature":21.5}`)))
+	handler("test/topic", []byte("21.5"))
+	// messages that exceed the size limit are dropped
+	handler("test/topic", compress(t, CompressionZstd, bytes.Repeat([]byte("0"), 2048)))
+
+	messages, _ := topic.drain()
+	require.Equal(t, []string{`{"temperature":21.5}`, "21.5"}, values(messages))
+}
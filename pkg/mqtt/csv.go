@@ -0,0 +1,167 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// DefaultCSVDelimiter separates the values of delimited text payloads.
+const DefaultCSVDelimiter = ","
+
+// CSVColumn names a column of delimited text payloads and the type of its values.
+type CSVColumn struct {
+	// Name is the name of the field. Defaults to the name in the header
+	// row, if there is one, or "Column <n>".
+	Name string `json:"name,omitempty"`
+	// Type is the type the values are converted to: "number", "string" or
+	// "boolean". Without a type, the type is inferred from the values.
+	Type string `json:"type,omitempty"`
+}
+
+// csvDelimiter returns the delimiter of the options. "\t" is a tab.
+func (o FrameOptions) csvDelimiter() (rune, error) {
+	delimiter := o.CSVDelimiter
+	switch delimiter {
+	case "":
+		delimiter = DefaultCSVDelimiter
+	case `\t`:
+		delimiter = "\t"
+	}
+	r, size := utf8.DecodeRuneInString(delimiter)
+	if size != len(delimiter) || r == utf8.RuneError || r == '"' || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("invalid CSV delimiter %q: must be a single character", o.CSVDelimiter)
+	}
+	return r, nil
+}
+
+// validateCSV returns an error if the options of delimited text payloads are invalid.
+func (o FrameOptions) validateCSV() error {
+	if _, err := o.csvDelimiter(); err != nil {
+		return err
+	}
+	names := fieldNames{}
+	for i, c := range o.CSVColumns {
+		if _, ok := convertedFieldType(c.Type); !ok && c.Type != "" {
+			return fmt.Errorf("invalid type %q for column %d: must be number, string or boolean", c.Type, i+1)
+		}
+		if c.Name != "" {
+			if err := names.add("column", c.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addCSV adds a row for each line of a delimited text payload. If the payload has a
+// header row, its first line names the columns that aren't named by the options.
+func (df *framer) addCSV(message Message, logger log.Logger) {
+	delimiter, err := df.options.csvDelimiter()
+	if err != nil {
+		return
+	}
+	r := csv.NewReader(bytes.NewReader(message.Value))
+	r.Comma = delimiter
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	r.TrimLeadingSpace = true
+
+	var header, names []string
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			logger.Debug("invalid CSV", "error", err)
+			break
+		}
+		if df.options.CSVHeader && header == nil {
+			header = record
+			continue
+		}
+
+		names = df.csvColumnNames(names, len(record), header)
+		for i, value := range record {
+			df.path = append(df.path[:0], names[i])
+			df.addCSVValue(i, value)
+		}
+		df.path = df.path[:0]
+		df.appendMessage(message)
+	}
+}
+
+// csvColumnNames extends the names of the columns to n columns. Names that were already
+// used, such as repeated names of the header row, or the name of the time field, get the
+// suffix "_2", "_3", and so on, so each column is a separate field.
+func (df *framer) csvColumnNames(names []string, n int, header []string) []string {
+	for i := len(names); i < n; i++ {
+		name := df.csvColumnName(i, header)
+		unique := name
+		for k := 2; unique == "Time" || slices.Contains(names, unique); k++ {
+			unique = name + "_" + strconv.Itoa(k)
+		}
+		names = append(names, unique)
+	}
+	return names
+}
+
+// csvColumnName returns the name of the column with the given index.
+func (df *framer) csvColumnName(i int, header []string) string {
+	if i < len(df.options.CSVColumns) && df.options.CSVColumns[i].Name != "" {
+		return df.options.CSVColumns[i].Name
+	}
+	if i < len(header) && strings.TrimSpace(header[i]) != "" {
+		return strings.TrimSpace(header[i])
+	}
+	return "Column " + strconv.Itoa(i+1)
+}
+
+// addCSVValue adds a value of the column with the given index to the field of the current key,
+// converted to the type of the column. Empty values are null.
+func (df *framer) addCSVValue(i int, value string) {
+	value = strings.TrimSpace(value)
+	var typ string
+	if i < len(df.options.CSVColumns) {
+		typ = df.options.CSVColumns[i].Type
+	}
+
+	if fieldType, ok := convertedFieldType(typ); ok {
+		var v any
+		if value != "" {
+			v = convertJSONValue(value, fieldType)
+		}
+		_ = df.addJSONValue(fieldType, v)
+		return
+	}
+
+	v := inferTextValue(value)
+	_ = df.addJSONValue(jsonValueType(v), v)
+}
+
+// inferTextValue converts a text value without a type, such as a column of delimited text,
+// to a number or boolean, if it is one, or keeps it as a string. Empty values are nil.
+func inferTextValue(value string) any {
+	if value == "" {
+		return nil
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+	switch strings.ToLower(value) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return value
+}
@@ -0,0 +1,104 @@
+package mqtt
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameOptions_CSV(t *testing.T) {
+	for _, delimiter := range []string{"", ",", ";", "|", `\t`, "\t", "§"} {
+		require.NoError(t, FrameOptions{PayloadFormat: PayloadFormatCSV, CSVDelimiter: delimiter}.Validate(), delimiter)
+	}
+	for _, delimiter := range []string{",,", `"`, "\n", `\n`} {
+		require.Error(t, FrameOptions{PayloadFormat: PayloadFormatCSV, CSVDelimiter: delimiter}.Validate(), delimiter)
+	}
+
+	require.NoError(t, FrameOptions{PayloadFormat: PayloadFormatCSV, CSVColumns: []CSVColumn{{Name: "a"}, {Type: JSONPathTypeNumber}}}.Validate())
+	require.EqualError(t, FrameOptions{PayloadFormat: PayloadFormatCSV, CSVColumns: []CSVColumn{{Name: "a"}, {Type: "date"}}}.Validate(),
+		`invalid type "date" for column 2: must be number, string or boolean`)
+	require.EqualError(t, FrameOptions{PayloadFormat: PayloadFormatCSV, CSVColumns: []CSVColumn{{Name: "a"}, {}, {Name: "a"}}}.Validate(),
+		`duplicate column "a"`)
+	require.EqualError(t, FrameOptions{PayloadFormat: PayloadFormatCSV, CSVColumns: []CSVColumn{{Name: "Time"}}}.Validate(),
+		`the column "Time" has the name of the time field`)
+
+	// the options of delimited text payloads are ignored for other formats
+	require.NoError(t, FrameOptions{CSVDelimiter: ",,"}.Validate())
+}
+
+func TestInferTextValue(t *testing.T) {
+	require.Nil(t, inferTextValue(""))
+	require.Equal(t, 21.5, inferTextValue("21.5"))
+	require.Equal(t, -3.0, inferTextValue("-3"))
+	require.Equal(t, true, inferTextValue("TRUE"))
+	require.Equal(t, false, inferTextValue("false"))
+	require.Equal(t, "online", inferTextValue("online"))
+}
+
+func Test_framer_CSV(t *testing.T) {
+	received := time.Unix(1714570000, 0)
+
+	tests := []struct {
+		name     string
+		options  FrameOptions
+		payloads []string
+	}{
+		{
+			name:    "header",
+			options: FrameOptions{CSVHeader: true},
+			payloads: []string{
+				"sensor,temperature,ok\nkitchen,21.5,true\nbedroom,19,false",
+				// empty values are null
+				"sensor,temperature,ok\n\"hall, upstairs\",,true",
+			},
+		},
+		{
+			name: "columns",
+			options: FrameOptions{
+				CSVDelimiter: ";",
+				CSVColumns: []CSVColumn{
+					{Name: "id", Type: JSONPathTypeString},
+					{Name: "value", Type: JSONPathTypeNumber},
+					{Name: "alarm", Type: JSONPathTypeBoolean},
+				},
+			},
+			payloads: []string{
+				"0042;1.5;1",
+				// values that can't be converted are null, and extra columns are numbered
+				"0043;high;0;extra",
+			},
+		},
+		{
+			name:    "duplicate header",
+			options: FrameOptions{CSVHeader: true},
+			payloads: []string{
+				// repeated names and the name of the time field get a suffix
+				"a,a,Time\n1,2,3",
+				"a,a,Time\n4,5,6",
+			},
+		},
+		{
+			name:    "time field",
+			options: FrameOptions{CSVDelimiter: `\t`, CSVHeader: true, TimeField: "ts", TimeFormat: TimeFormatEpochSeconds},
+			payloads: []string{
+				"ts\tvalue\n1714566615\t1\n1714566616\t2",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var messages []Message
+			for i, payload := range tt.payloads {
+				messages = append(messages, Message{Timestamp: received.Add(time.Duration(i) * time.Second), Value: []byte(payload)})
+			}
+			tt.options.PayloadFormat = PayloadFormatCSV
+			frame, err := newFramer(tt.options).toFrame(messages, log.DefaultLogger)
+			require.NoError(t, err)
+			experimental.CheckGoldenJSONFrame(t, "testdata", "csv-"+strings.ReplaceAll(tt.name, " ", "-"), frame, update)
+		})
+	}
+}
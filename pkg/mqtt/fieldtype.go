@@ -0,0 +1,227 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// FieldTypeTime is the type of a field type override that converts the values to times,
+// in addition to the types that path expressions convert values to.
+const FieldTypeTime = "time"
+
+// Policies for the values that don't have the type of their field, such as the
+// string "21.5" in a numeric field.
+const (
+	// TypeConversionDrop drops the values, so their rows are null.
+	TypeConversionDrop = "drop"
+	// TypeConversionConvert converts the values to the type of their field.
+	// Values that can't be converted are dropped.
+	TypeConversionConvert = "convert"
+)
+
+// FieldTypeOverride pins the type of a field, instead of inferring it from the first value.
+type FieldTypeOverride struct {
+	// Field is the name of the field, such as "temperature" or "env.temp".
+	Field string `json:"field"`
+	// Type is the type the values are converted to: "number", "string", "boolean" or "time".
+	Type string `json:"type"`
+	// Format is the time format of the values of time fields, as for the time field of the
+	// options. Defaults to "rfc3339" for strings and "epoch_ms" for numbers.
+	Format string `json:"format,omitempty"`
+}
+
+// fieldType returns the type of the frame field.
+func (o FieldTypeOverride) fieldType() data.FieldType {
+	if o.Type == FieldTypeTime {
+		return data.FieldTypeNullableTime
+	}
+	fieldType, _ := convertedFieldType(o.Type)
+	return fieldType
+}
+
+// validateFieldTypes returns an error if the field type overrides or the conversion policy are invalid.
+func (o FrameOptions) validateFieldTypes() error {
+	switch o.TypeConversion {
+	case "", TypeConversionDrop, TypeConversionConvert:
+	default:
+		return fmt.Errorf("invalid type conversion %q: must be drop or convert", o.TypeConversion)
+	}
+	for i, t := range o.FieldTypes {
+		if t.Field == "" {
+			return fmt.Errorf("missing field of field type %d", i+1)
+		}
+		if _, ok := convertedFieldType(t.Type); !ok && t.Type != FieldTypeTime {
+			return fmt.Errorf("invalid type %q of field %q: must be number, string, boolean or time", t.Type, t.Field)
+		}
+	}
+	return nil
+}
+
+// fieldTypeOverride returns the field type override of the field with the given name.
+func (df *framer) fieldTypeOverride(name string) (FieldTypeOverride, bool) {
+	for _, t := range df.options.FieldTypes {
+		if t.Field == name {
+			return t, true
+		}
+	}
+	return FieldTypeOverride{}, false
+}
+
+// addTypeNotices adds notices to the frame with the number of values that were converted
+// to the type of their field, or dropped because they didn't have it.
+func (df *framer) addTypeNotices(frame *data.Frame) {
+	if df.converted > 0 {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text: fmt.Sprintf(plural(df.converted,
+				"%d value was converted to the type of its field",
+				"%d values were converted to the type of their field"), df.converted),
+		})
+	}
+	if df.dropped > 0 {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text: fmt.Sprintf(plural(df.dropped,
+				"%d value was dropped because it didn't match the type of its field",
+				"%d values were dropped because they didn't match the type of their field")+
+				". Set the field types or convert the values to keep them.", df.dropped),
+		})
+	}
+}
+
+// plural returns the singular text for a count of one, and else the plural text.
+func plural(n int, singular, plural string) string {
+	if n == 1 {
+		return singular
+	}
+	return plural
+}
+
+// convertFieldValue converts a value of a field to the given field type. The values are pointers
+// for nullable field types, as added to the fields. It returns false if the value can't be converted.
+func convertFieldValue(v any, fieldType data.FieldType, timeFormat string) (any, bool) {
+	if fieldType == data.FieldTypeJSON {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, false
+		}
+		return json.RawMessage(raw), true
+	}
+
+	switch value := v.(type) {
+	case *float64:
+		return convertFloat(*value, fieldType, timeFormat)
+	case *int64:
+		if fieldType == data.FieldTypeNullableUint64 && *value >= 0 {
+			u := uint64(*value)
+			return &u, true
+		}
+		if fieldType == data.FieldTypeNullableString {
+			s := strconv.FormatInt(*value, 10)
+			return &s, true
+		}
+		return convertFloat(float64(*value), fieldType, timeFormat)
+	case *uint64:
+		if fieldType == data.FieldTypeNullableInt64 && *value <= math.MaxInt64 {
+			i := int64(*value)
+			return &i, true
+		}
+		if fieldType == data.FieldTypeNullableString {
+			s := strconv.FormatUint(*value, 10)
+			return &s, true
+		}
+		return convertFloat(float64(*value), fieldType, timeFormat)
+	case *bool:
+		if fieldType == data.FieldTypeNullableString {
+			s := strconv.FormatBool(*value)
+			return &s, true
+		}
+		if fieldType == data.FieldTypeNullableTime {
+			return nil, false
+		}
+		f := 0.0
+		if *value {
+			f = 1
+		}
+		return convertFloat(f, fieldType, timeFormat)
+	case *string:
+		return convertString(*value, fieldType, timeFormat)
+	case *time.Time:
+		if fieldType == data.FieldTypeNullableString {
+			s := value.Format(time.RFC3339Nano)
+			return &s, true
+		}
+	case json.RawMessage:
+		if fieldType == data.FieldTypeNullableString {
+			s := string(value)
+			return &s, true
+		}
+	}
+	return nil, false
+}
+
+// convertFloat converts a number to the field type.
+func convertFloat(f float64, fieldType data.FieldType, timeFormat string) (any, bool) {
+	switch fieldType {
+	case data.FieldTypeNullableFloat64:
+		return &f, true
+	case data.FieldTypeNullableInt64:
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return nil, false
+		}
+		i := int64(f)
+		return &i, true
+	case data.FieldTypeNullableUint64:
+		if f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+			return nil, false
+		}
+		u := uint64(f)
+		return &u, true
+	case data.FieldTypeNullableBool:
+		b := f != 0
+		return &b, true
+	case data.FieldTypeNullableString:
+		s := strconv.FormatFloat(f, 'f', -1, 64)
+		return &s, true
+	case data.FieldTypeNullableTime:
+		if timeFormat == "" {
+			timeFormat = TimeFormatEpochMilliseconds
+		}
+		t, err := parseTime(f, timeFormat)
+		if err != nil {
+			return nil, false
+		}
+		return &t, true
+	}
+	return nil, false
+}
+
+// convertString converts a string to the field type.
+func convertString(s string, fieldType data.FieldType, timeFormat string) (any, bool) {
+	switch fieldType {
+	case data.FieldTypeNullableString:
+		return &s, true
+	case data.FieldTypeNullableBool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, false
+		}
+		return &b, true
+	case data.FieldTypeNullableTime:
+		t, err := parseTime(s, timeFormat)
+		if err != nil {
+			return nil, false
+		}
+		return &t, true
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, false
+	}
+	return convertFloat(f, fieldType, timeFormat)
+}
@@ -0,0 +1,126 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameOptions_FieldTypes(t *testing.T) {
+	require.NoError(t, FrameOptions{
+		FieldTypes: []FieldTypeOverride{
+			{Field: "temperature", Type: JSONPathTypeNumber},
+			{Field: "ts", Type: FieldTypeTime, Format: TimeFormatEpochSeconds},
+		},
+		TypeConversion: TypeConversionConvert,
+	}.Validate())
+
+	require.EqualError(t, FrameOptions{TypeConversion: "coerce"}.Validate(), `invalid type conversion "coerce": must be drop or convert`)
+	require.EqualError(t, FrameOptions{FieldTypes: []FieldTypeOverride{{Type: JSONPathTypeNumber}}}.Validate(), "missing field of field type 1")
+	require.EqualError(t, FrameOptions{FieldTypes: []FieldTypeOverride{{Field: "a", Type: "date"}}}.Validate(),
+		`invalid type "date" of field "a": must be number, string, boolean or time`)
+}
+
+func TestConvertFieldValue(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	i := func(v int64) *int64 { return &v }
+	u := func(v uint64) *uint64 { return &v }
+	s := func(v string) *string { return &v }
+	b := func(v bool) *bool { return &v }
+
+	tests := []struct {
+		name      string
+		value     any
+		fieldType data.FieldType
+		format    string
+		expected  any
+	}{
+		{name: "string to number", value: s("21.5"), fieldType: data.FieldTypeNullableFloat64, expected: 21.5},
+		{name: "string to integer", value: s("42"), fieldType: data.FieldTypeNullableInt64, expected: int64(42)},
+		{name: "string to bool", value: s("true"), fieldType: data.FieldTypeNullableBool, expected: true},
+		{name: "string to time", value: s("2024-05-01T12:30:15Z"), fieldType: data.FieldTypeNullableTime, expected: time.Unix(1714566615, 0)},
+		{name: "number to string", value: f(21.5), fieldType: data.FieldTypeNullableString, expected: "21.5"},
+		{name: "number to bool", value: f(0), fieldType: data.FieldTypeNullableBool, expected: false},
+		{name: "number to integer", value: f(3), fieldType: data.FieldTypeNullableInt64, expected: int64(3)},
+		{name: "epoch milliseconds to time", value: f(1714566615000), fieldType: data.FieldTypeNullableTime, expected: time.Unix(1714566615, 0)},
+		{name: "epoch seconds to time", value: i(1714566615), fieldType: data.FieldTypeNullableTime, format: TimeFormatEpochSeconds, expected: time.Unix(1714566615, 0)},
+		{name: "integer to number", value: i(-3), fieldType: data.FieldTypeNullableFloat64, expected: -3.0},
+		{name: "integer to unsigned integer", value: i(3), fieldType: data.FieldTypeNullableUint64, expected: uint64(3)},
+		{name: "unsigned integer to string", value: u(18446744073709551615), fieldType: data.FieldTypeNullableString, expected: "18446744073709551615"},
+		{name: "bool to number", value: b(true), fieldType: data.FieldTypeNullableFloat64, expected: 1.0},
+		{name: "bool to string", value: b(false), fieldType: data.FieldTypeNullableString, expected: "false"},
+		{name: "json to string", value: json.RawMessage(`[1,2]`), fieldType: data.FieldTypeNullableString, expected: "[1,2]"},
+		{name: "string to json", value: s("on"), fieldType: data.FieldTypeJSON, expected: json.RawMessage(`"on"`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, ok := convertFieldValue(tt.value, tt.fieldType, tt.format)
+			require.True(t, ok)
+			if expected, isTime := tt.expected.(time.Time); isTime {
+				require.True(t, expected.Equal(*v.(*time.Time)), "expected %s, got %s", expected, v)
+				return
+			}
+			require.Equal(t, tt.expected, derefValue(v))
+		})
+	}
+
+	for _, tt := range []struct {
+		value     any
+		fieldType data.FieldType
+	}{
+		{value: s("high"), fieldType: data.FieldTypeNullableFloat64},
+		{value: s("maybe"), fieldType: data.FieldTypeNullableBool},
+		{value: s("yesterday"), fieldType: data.FieldTypeNullableTime},
+		{value: f(1.5), fieldType: data.FieldTypeNullableInt64},
+		{value: i(-1), fieldType: data.FieldTypeNullableUint64},
+		{value: b(true), fieldType: data.FieldTypeNullableTime},
+		{value: json.RawMessage(`[1,2]`), fieldType: data.FieldTypeNullableFloat64},
+	} {
+		_, ok := convertFieldValue(tt.value, tt.fieldType, "")
+		require.False(t, ok, "%v to %s", derefValue(tt.value), tt.fieldType)
+	}
+}
+
+func Test_framer_FieldTypes(t *testing.T) {
+	received := time.Unix(1714570000, 0)
+	messages := []Message{
+		{Timestamp: received, Value: []byte(`{"temperature":"21.5","alarm":0,"ts":1714566615,"state":"ok"}`)},
+		{Timestamp: received.Add(time.Second), Value: []byte(`{"temperature":22,"alarm":1,"ts":1714566616,"state":3}`)},
+		// values that can't be converted are dropped
+		{Timestamp: received.Add(2 * time.Second), Value: []byte(`{"temperature":"high","alarm":"on","ts":"now","state":true}`)},
+	}
+
+	tests := []struct {
+		name    string
+		options FrameOptions
+	}{
+		{
+			name: "overrides",
+			options: FrameOptions{
+				FieldTypes: []FieldTypeOverride{
+					{Field: "temperature", Type: JSONPathTypeNumber},
+					{Field: "alarm", Type: JSONPathTypeBoolean},
+					{Field: "ts", Type: FieldTypeTime, Format: TimeFormatEpochSeconds},
+				},
+				TimeField: "ts",
+			},
+		},
+		{
+			// the fields have the type of their first value
+			name:    "convert",
+			options: FrameOptions{TypeConversion: TypeConversionConvert},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := newFramer(tt.options).toFrame(messages, log.DefaultLogger)
+			require.NoError(t, err)
+			experimental.CheckGoldenJSONFrame(t, "testdata", "field-types-"+tt.name, frame, update)
+		})
+	}
+}
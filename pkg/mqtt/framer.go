@@ -1,20 +1,44 @@
 package mqtt
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	jsoniter "github.com/json-iterator/go"
 )
 
+// DefaultJSONSeparator joins the keys of nested JSON objects to field names.
+const DefaultJSONSeparator = "."
+
 type framer struct {
+	options  FrameOptions
 	path     []string
 	iterator *jsoniter.Iterator
 	fields   []*data.Field
 	fieldMap map[string]int
+	// topicField holds the topic of each message. It is not part of the
+	// fieldMap, so it can't collide with the fields of the payload.
+	topicField *data.Field
+	// receivedField holds the time each message was received, when the
+	// time of the messages is read from their payload.
+	receivedField *data.Field
+	// expressions are the compiled path expressions of the options.
+	expressions []pathExpression
+	// regex is the compiled regular expression of text payloads.
+	regex *regexp.Regexp
+	// name is the name of the frame, if the payloads name it, such as the
+	// measurement of line protocol payloads. The default name is "mqtt".
+	name string
+	// converted and dropped count the values of the frame that were converted to
+	// the type of their field, or dropped because they didn't have it.
+	converted int
+	dropped   int
 }
 
 func (df *framer) next(logger log.Logger) error {
@@ -34,31 +58,40 @@ func (df *framer) next(logger log.Logger) error {
 	case jsoniter.ArrayValue:
 		df.addValue(data.FieldTypeJSON, json.RawMessage(df.iterator.SkipAndReturnBytes()))
 	case jsoniter.ObjectValue:
+		// objects deeper than the maximum depth are kept as JSON
 		size := len(df.path)
-		if size > 0 {
+		if df.options.JSONMaxDepth > 0 && size >= df.options.JSONMaxDepth {
 			df.addValue(data.FieldTypeJSON, json.RawMessage(df.iterator.SkipAndReturnBytes()))
 			break
 		}
 		for fname := df.iterator.ReadObject(); fname != ""; fname = df.iterator.ReadObject() {
-			if size == 0 {
-				df.path = append(df.path, fname)
-				if err := df.next(logger); err != nil {
-					return err
-				}
+			df.path = append(df.path[:size], fname)
+			if err := df.next(logger); err != nil {
+				return err
 			}
 		}
+		df.path = df.path[:size]
 	case jsoniter.InvalidValue:
 		return fmt.Errorf("invalid value")
 	}
-	df.path = []string{}
 	return nil
 }
 
+// key returns the field name of the current value, which joins the keys
+// of the nested objects containing it with the separator.
 func (df *framer) key() string {
 	if len(df.path) == 0 {
 		return "Value"
 	}
-	return strings.Join(df.path, "")
+	return strings.Join(df.path, df.separator())
+}
+
+// separator returns the separator of the keys of nested objects in field names.
+func (df *framer) separator() string {
+	if df.options.JSONSeparator == "" {
+		return DefaultJSONSeparator
+	}
+	return df.options.JSONSeparator
 }
 
 func (df *framer) addNil(logger log.Logger) {
@@ -69,33 +102,112 @@ func (df *framer) addNil(logger log.Logger) {
 	logger.Debug("nil value for unknown field", "key", df.key())
 }
 
+// addNull adds a field of the given type for the current key, if there is none,
+// so the field is part of the frame even if its first values are null.
+func (df *framer) addNull(fieldType data.FieldType) {
+	if t, ok := df.fieldTypeOverride(df.key()); ok {
+		fieldType = t.fieldType()
+	}
+	if _, ok := df.fieldMap[df.key()]; ok || fieldType == data.FieldTypeUnknown {
+		return
+	}
+	field := data.NewFieldFromFieldType(fieldType, df.fields[0].Len())
+	field.Name = df.key()
+	df.fields = append(df.fields, field)
+	df.fieldMap[df.key()] = len(df.fields) - 1
+}
+
 func (df *framer) addValue(fieldType data.FieldType, v interface{}) {
-	if idx, ok := df.fieldMap[df.key()]; ok {
-		if df.fields[idx].Type() != fieldType {
-			log.DefaultLogger.Debug("field type mismatch", "key", df.key(), "existing", df.fields[idx], "new", fieldType)
-			return
+	df.addNamedValue(df.key(), nil, fieldType, v)
+}
+
+// addNamedValue adds the value to the field with the name and labels, which
+// is added if there is none. Fields with different labels are separate fields.
+//
+// Fields have the type of their type override, or of their first value. Values of
+// another type are converted if the options convert them, or else dropped.
+func (df *framer) addNamedValue(name string, labels data.Labels, fieldType data.FieldType, v interface{}) {
+	key := name
+	if len(labels) > 0 {
+		key += labels.String()
+	}
+
+	timeFormat := df.options.TimeFormat
+	if t, ok := df.fieldTypeOverride(name); ok {
+		timeFormat = t.Format
+		if t.fieldType() != fieldType {
+			converted, ok := convertFieldValue(v, t.fieldType(), timeFormat)
+			if !ok {
+				log.DefaultLogger.Debug("field type conversion failed", "key", key, "type", t.Type, "value", v)
+				df.dropped++
+				return
+			}
+			v, fieldType = converted, t.fieldType()
+			df.converted++
+		}
+	}
+
+	if idx, ok := df.fieldMap[key]; ok {
+		field := df.fields[idx]
+		if field.Type() != fieldType {
+			converted, ok := convertFieldValue(v, field.Type(), timeFormat)
+			if df.options.TypeConversion != TypeConversionConvert || !ok {
+				log.DefaultLogger.Debug("field type mismatch", "key", key, "existing", field, "new", fieldType)
+				df.dropped++
+				return
+			}
+			v = converted
+			df.converted++
 		}
-		df.fields[idx].Append(v)
+		field.Append(v)
 		return
 	}
 	field := data.NewFieldFromFieldType(fieldType, df.fields[0].Len())
-	field.Name = df.key()
+	field.Name = name
+	field.Labels = labels
 	field.Append(v)
 	df.fields = append(df.fields, field)
-	df.fieldMap[df.key()] = len(df.fields) - 1
+	df.fieldMap[key] = len(df.fields) - 1
 }
 
-func newFramer() *framer {
+func newFramer(options FrameOptions) *framer {
 	df := &framer{
+		options:  options,
 		fieldMap: make(map[string]int),
 	}
 	timeField := data.NewFieldFromFieldType(data.FieldTypeTime, 0)
 	timeField.Name = "Time"
 	df.fields = append(df.fields, timeField)
 	df.fieldMap["Time"] = 0
+	if options.TimeField != "" {
+		df.addReceivedField()
+	}
 	return df
 }
 
+// fieldNames are the names of the fields configured by the options. Each name can only
+// be used once, and not for the time field, so a row has a single value per field.
+type fieldNames map[string]bool
+
+// add returns an error if the name was added before or is the name of the time field.
+func (n fieldNames) add(kind string, name string) error {
+	if name == "Time" {
+		return fmt.Errorf("the %s %q has the name of the time field", kind, name)
+	}
+	if n[name] {
+		return fmt.Errorf("duplicate %s %q", kind, name)
+	}
+	n[name] = true
+	return nil
+}
+
+// addTopicField adds a field with the topic each message was received on.
+func (df *framer) addTopicField() {
+	df.topicField = data.NewFieldFromFieldType(data.FieldTypeString, 0)
+	df.topicField.Name = "Topic"
+	df.fields = append(df.fields, df.topicField)
+}
+
 func (df *framer) toFrame(messages []Message, logger log.Logger) (*data.Frame, error) {
 	// clear the data in the fields
 	for _, field := range df.fields {
@@ -103,21 +215,120 @@ func (df *framer) toFrame(messages []Message, logger log.Logger) (*data.Frame, e
 			field.Delete(i)
 		}
 	}
+	df.converted, df.dropped = 0, 0
 
 	for _, message := range messages {
-		df.iterator = jsoniter.ParseBytes(jsoniter.ConfigDefault, message.Value)
-		err := df.next(logger)
+		message, err := df.decode(message)
 		if err != nil {
-			// If JSON parsing fails, treat the raw bytes as a string value
-			logger.Debug("JSON parsing failed, treating as raw string", "error", err, "value", string(message.Value))
-			rawValue := string(message.Value)
-			df.addValue(data.FieldTypeNullableString, &rawValue)
+			// the row of the message has no values
+			logger.Debug("payload decoding failed", "error", err, "format", df.options.PayloadFormat, "protoMessage", df.options.ProtoMessage)
+			df.appendMessage(message)
+			continue
+		}
+
+		rows := []Message{message}
+		if df.options.ExplodeArrays && df.options.decodesToJSON() {
+			rows = explode(message)
 		}
-		df.fields[0].Append(message.Timestamp)
-		df.extendFields(df.fields[0].Len() - 1)
+		for _, row := range rows {
+			if err := df.addMessage(row, logger); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	name := "mqtt"
+	if df.name != "" {
+		name = df.name
+	}
+	frame := data.NewFrame(name, df.fields...)
+	df.addTypeNotices(frame)
+	return frame, nil
+}
+
+// addMessage adds the message to the fields as a new row.
+func (df *framer) addMessage(message Message, logger log.Logger) error {
+	if p := df.sparkplugPayload(message); p != nil {
+		df.addSparkplugMetrics(message, p)
+		return nil
+	}
+
+	switch df.options.PayloadFormat {
+	case PayloadFormatInflux:
+		df.addLineProtocol(message, logger)
+		return nil
+	case PayloadFormatCSV:
+		df.addCSV(message, logger)
+		return nil
+	case PayloadFormatBinary:
+		df.addBinary(message)
+		return nil
+	case PayloadFormatRegex:
+		return df.addRegex(message, logger)
+	case PayloadFormatRaw:
+		df.path = df.path[:0]
+		v := string(message.Value)
+		df.addValue(data.FieldTypeNullableString, &v)
+		df.appendMessage(message)
+		return nil
 	}
 
-	return data.NewFrame("mqtt", df.fields...), nil
+	if len(df.options.JSONPaths) > 0 {
+		if err := df.extract(message.Value); err != nil {
+			return err
+		}
+		df.appendMessage(message)
+		return nil
+	}
+
+	df.iterator = jsoniter.ParseBytes(jsoniter.ConfigDefault, message.Value)
+	df.path = df.path[:0]
+	err := df.next(logger)
+	if err != nil {
+		// If JSON parsing fails, treat the raw bytes as a string value
+		logger.Debug("JSON parsing failed, treating as raw string", "error", err, "value", string(message.Value))
+		rawValue := string(message.Value)
+		df.addValue(data.FieldTypeNullableString, &rawValue)
+	}
+	df.appendMessage(message)
+	return nil
+}
+
+// explode splits a message with a JSON array payload into a message per element,
+// so each element is converted to its own row. Other messages are returned as is.
+func explode(message Message) []Message {
+	iterator := jsoniter.ParseBytes(jsoniter.ConfigDefault, message.Value)
+	if iterator.WhatIsNext() != jsoniter.ArrayValue {
+		return []Message{message}
+	}
+
+	var messages []Message
+	for iterator.ReadArray() {
+		element := message
+		element.Value = bytes.TrimSpace(iterator.SkipAndReturnBytes())
+		messages = append(messages, element)
+	}
+	if iterator.Error != nil {
+		return []Message{message}
+	}
+	return messages
+}
+
+// appendMessage completes the row of the message, after the values of its payload were added.
+func (df *framer) appendMessage(message Message) {
+	df.appendMessageAt(message, df.messageTime(message))
+}
+
+// appendMessageAt completes the row of the message with the given time.
+func (df *framer) appendMessageAt(message Message, t time.Time) {
+	df.fields[0].Append(t)
+	if df.receivedField != nil {
+		df.receivedField.Append(message.Timestamp)
+	}
+	if df.topicField != nil {
+		df.topicField.Append(message.Topic)
+	}
+	df.extendFields(df.fields[0].Len() - 1)
 }
 
 func (df *framer) extendFields(idx int) {
@@ -59,6 +59,34 @@ func Test_framer(t *testing.T) {
 		runTest(t, "nested-object", map[string]interface{}{"a": 1, "b": map[string]any{"c": []any{1, 2, 3}}})
 	})
 
+	t.Run("deeply nested object", func(t *testing.T) {
+		runTest(t, "deeply-nested-object",
+			map[string]any{"env": map[string]any{"temp": map[string]any{"c": 21}, "humidity": 40}},
+			map[string]any{"env": map[string]any{"temp": map[string]any{"c": 22.5}}},
+		)
+	})
+
+	t.Run("nested object with separator and max depth", func(t *testing.T) {
+		runOptionsTest(t, "nested-object-max-depth", FrameOptions{JSONSeparator: "_", JSONMaxDepth: 2},
+			map[string]any{"env": map[string]any{"temp": map[string]any{"c": 21}, "humidity": 40}},
+		)
+	})
+
+	t.Run("exploded array of records", func(t *testing.T) {
+		runOptionsTest(t, "explode-array", FrameOptions{ExplodeArrays: true},
+			[]any{map[string]any{"v": 1}, map[string]any{"v": 2, "unit": "C"}},
+			[]any{},
+			map[string]any{"v": 3},
+		)
+	})
+
+	t.Run("exploded array of records with time field", func(t *testing.T) {
+		runOptionsTest(t, "explode-array-time-field", FrameOptions{ExplodeArrays: true, TimeField: "ts", TimeFormat: TimeFormatEpochSeconds},
+			[]any{map[string]any{"ts": 1, "v": 1.5}, map[string]any{"ts": 2, "v": 2.5}},
+			[]any{map[string]any{"v": 3.5}},
+		)
+	})
+
 	t.Run("null", func(t *testing.T) {
 		runTest(t, "null", nil)
 	})
@@ -77,11 +105,29 @@ func Test_framer(t *testing.T) {
 	t.Run("mixed raw values", func(t *testing.T) {
 		runRawTest(t, "raw-mixed", []byte("25"), []byte("on"), []byte("123.45"))
 	})
+
+	t.Run("topic", func(t *testing.T) {
+		f := newFramer(FrameOptions{})
+		f.addTopicField()
+		timestamp := time.Unix(0, 0)
+		messages := []Message{
+			{Timestamp: timestamp, Topic: "sensors/a/temperature", Value: toJSON(map[string]any{"value": 21.5})},
+			{Timestamp: timestamp.Add(time.Minute), Topic: "sensors/b/temperature", Value: toJSON(map[string]any{"value": 19})},
+		}
+		frame, err := f.toFrame(messages, log.DefaultLogger)
+		require.NoError(t, err)
+		experimental.CheckGoldenJSONFrame(t, "testdata", "topic", frame, update)
+	})
 }
 
 func runTest(t *testing.T, name string, values ...any) {
 	t.Helper()
-	f := newFramer()
+	runOptionsTest(t, name, FrameOptions{}, values...)
+}
+
+func runOptionsTest(t *testing.T, name string, options FrameOptions, values ...any) {
+	t.Helper()
+	f := newFramer(options)
 	timestamp := time.Unix(0, 0)
 	messages := []Message{}
 	for i, v := range values {
@@ -93,9 +139,40 @@ func runTest(t *testing.T, name string, values ...any) {
 	experimental.CheckGoldenJSONFrame(t, "testdata", name, frame, update)
 }
 
+func Test_framer_nestedKeys(t *testing.T) {
+	f := newFramer(FrameOptions{})
+	messages := []Message{
+		{Timestamp: time.Unix(0, 0), Value: []byte(`{"a":{"bc":1},"ab":{"c":2}}`)},
+	}
+	frame, err := f.toFrame(messages, log.DefaultLogger)
+	require.NoError(t, err)
+
+	// the keys are separated, so nested keys don't collide
+	require.Len(t, frame.Fields, 3)
+	require.Equal(t, "a.bc", frame.Fields[1].Name)
+	require.Equal(t, "ab.c", frame.Fields[2].Name)
+}
+
+func TestExplode(t *testing.T) {
+	message := Message{Timestamp: time.Unix(0, 0), Topic: "gateway/1", Value: []byte(`[{"v":1}, 2, "three"]`)}
+	var values []string
+	for _, m := range explode(message) {
+		require.Equal(t, message.Timestamp, m.Timestamp)
+		require.Equal(t, message.Topic, m.Topic)
+		values = append(values, string(m.Value))
+	}
+	require.Equal(t, []string{`{"v":1}`, "2", `"three"`}, values)
+
+	// payloads that aren't arrays aren't split
+	for _, payload := range []string{`{"v":1}`, `[1, 2`, `not json`} {
+		m := Message{Value: []byte(payload)}
+		require.Equal(t, []Message{m}, explode(m))
+	}
+}
+
 func runRawTest(t *testing.T, name string, rawValues ...[]byte) {
 	t.Helper()
-	f := newFramer()
+	f := newFramer(FrameOptions{})
 	timestamp := time.Unix(0, 0)
 	messages := []Message{}
 	for i, v := range rawValues {
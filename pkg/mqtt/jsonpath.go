@@ -0,0 +1,242 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/jmespath/go-jmespath"
+	"github.com/ohler55/ojg/jp"
+)
+
+// Languages of the path expressions that extract values from JSON payloads.
+const (
+	JSONPathLanguage = "jsonpath"
+	JMESPathLanguage = "jmespath"
+)
+
+// Types a value extracted by a path expression can be converted to.
+// Without a type, the field has the type of the JSON value.
+const (
+	JSONPathTypeNumber  = "number"
+	JSONPathTypeString  = "string"
+	JSONPathTypeBoolean = "boolean"
+)
+
+// JSONPath extracts a value from the JSON payload of the messages to a field.
+type JSONPath struct {
+	// Path is the expression that selects the value, such as "$.env.temp"
+	// for JSONPath or "env.temp" for JMESPath.
+	Path string `json:"path"`
+	// Alias is the name of the field. Defaults to the path.
+	Alias string `json:"alias,omitempty"`
+	// Type is the type the value is converted to: "number", "string" or "boolean".
+	Type string `json:"type,omitempty"`
+}
+
+// name returns the name of the field the value is extracted to.
+func (p JSONPath) name() string {
+	if p.Alias != "" {
+		return p.Alias
+	}
+	return p.Path
+}
+
+// fieldType returns the type of the field for the configured type,
+// and whether the value is converted to it.
+func (p JSONPath) fieldType() (data.FieldType, bool) {
+	return convertedFieldType(p.Type)
+}
+
+// convertedFieldType returns the field type of a type that values are converted to,
+// such as JSONPathTypeNumber, and whether it is one.
+func convertedFieldType(typ string) (data.FieldType, bool) {
+	switch typ {
+	case JSONPathTypeNumber:
+		return data.FieldTypeNullableFloat64, true
+	case JSONPathTypeString:
+		return data.FieldTypeNullableString, true
+	case JSONPathTypeBoolean:
+		return data.FieldTypeNullableBool, true
+	}
+	return data.FieldTypeUnknown, false
+}
+
+// pathExpression evaluates a compiled path expression on a decoded JSON value.
+type pathExpression func(v any) (any, error)
+
+// compileJSONPaths compiles the path expressions in the given language.
+func compileJSONPaths(language string, paths []JSONPath) ([]pathExpression, error) {
+	expressions := make([]pathExpression, 0, len(paths))
+	names := fieldNames{}
+	for _, p := range paths {
+		if p.Path == "" {
+			return nil, fmt.Errorf("path expression is required")
+		}
+		if err := names.add("field", p.name()); err != nil {
+			return nil, err
+		}
+		switch p.Type {
+		case "", JSONPathTypeNumber, JSONPathTypeString, JSONPathTypeBoolean:
+		default:
+			return nil, fmt.Errorf("invalid type %q for path %q: must be number, string or boolean", p.Type, p.Path)
+		}
+
+		switch language {
+		case "", JSONPathLanguage:
+			x, err := jp.ParseString(p.Path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid JSONPath %q: %w", p.Path, err)
+			}
+			expressions = append(expressions, func(v any) (any, error) {
+				return jsonPathResult(x.Get(v)), nil
+			})
+		case JMESPathLanguage:
+			x, err := jmespath.Compile(p.Path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid JMESPath %q: %w", p.Path, err)
+			}
+			expressions = append(expressions, x.Search)
+		default:
+			return nil, fmt.Errorf("invalid path language %q: must be jsonpath or jmespath", language)
+		}
+	}
+	return expressions, nil
+}
+
+// jsonPathResult returns the value selected by a JSONPath. A path that selects
+// several values, such as "$..temp", returns them as an array.
+func jsonPathResult(results []any) any {
+	switch len(results) {
+	case 0:
+		return nil
+	case 1:
+		return results[0]
+	}
+	return results
+}
+
+// extract adds the values selected by the path expressions in the payload to the fields.
+// Values of payloads that aren't JSON are null.
+func (df *framer) extract(payload []byte) error {
+	if df.expressions == nil {
+		expressions, err := compileJSONPaths(df.options.JSONPathLanguage, df.options.JSONPaths)
+		if err != nil {
+			return err
+		}
+		df.expressions = expressions
+	}
+
+	var doc any
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		doc = nil
+	}
+
+	for i, p := range df.options.JSONPaths {
+		df.path = append(df.path[:0], p.name())
+
+		v, err := df.expressions[i](doc)
+		if err != nil {
+			v = nil
+		}
+		fieldType, convert := p.fieldType()
+		if convert {
+			v = convertJSONValue(v, fieldType)
+		} else {
+			fieldType = jsonValueType(v)
+		}
+
+		if err := df.addJSONValue(fieldType, v); err != nil {
+			return err
+		}
+	}
+	df.path = df.path[:0]
+	return nil
+}
+
+// addJSONValue adds a decoded JSON value of the given field type to the field of the current key.
+// Arrays and objects are added as JSON.
+func (df *framer) addJSONValue(fieldType data.FieldType, v any) error {
+	switch value := v.(type) {
+	case nil:
+		df.addNull(fieldType)
+	case float64:
+		df.addValue(fieldType, &value)
+	case string:
+		df.addValue(fieldType, &value)
+	case bool:
+		df.addValue(fieldType, &value)
+	default:
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		df.addValue(data.FieldTypeJSON, json.RawMessage(raw))
+	}
+	return nil
+}
+
+// jsonValueType returns the field type of a decoded JSON value. Null values
+// have an unknown type, so they don't determine the type of the field.
+func jsonValueType(v any) data.FieldType {
+	switch v.(type) {
+	case nil:
+		return data.FieldTypeUnknown
+	case float64:
+		return data.FieldTypeNullableFloat64
+	case string:
+		return data.FieldTypeNullableString
+	case bool:
+		return data.FieldTypeNullableBool
+	}
+	return data.FieldTypeJSON
+}
+
+// convertJSONValue converts a decoded JSON value to the given field type.
+// Values that can't be converted are nil.
+func convertJSONValue(v any, fieldType data.FieldType) any {
+	switch fieldType {
+	case data.FieldTypeNullableFloat64:
+		switch value := v.(type) {
+		case float64:
+			return value
+		case string:
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				return f
+			}
+		case bool:
+			if value {
+				return 1.0
+			}
+			return 0.0
+		}
+	case data.FieldTypeNullableString:
+		switch value := v.(type) {
+		case string:
+			return value
+		case float64:
+			return strconv.FormatFloat(value, 'f', -1, 64)
+		case bool:
+			return strconv.FormatBool(value)
+		case nil:
+			return nil
+		default:
+			if raw, err := json.Marshal(value); err == nil {
+				return string(raw)
+			}
+		}
+	case data.FieldTypeNullableBool:
+		switch value := v.(type) {
+		case bool:
+			return value
+		case string:
+			if b, err := strconv.ParseBool(value); err == nil {
+				return b
+			}
+		case float64:
+			return value != 0
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_framer_JSONPaths(t *testing.T) {
+	payloads := []string{
+		`{"device":{"id":"abc","env":{"temp":21.5,"humidity":"40"}},"readings":[1,2,3],"ok":true}`,
+		`{"device":{"id":"abc","env":{"temp":22}},"readings":[4],"ok":false}`,
+		`not json`,
+	}
+	messages := make([]Message, 0, len(payloads))
+	for i, p := range payloads {
+		messages = append(messages, Message{Timestamp: time.Unix(int64(i), 0), Value: []byte(p)})
+	}
+
+	tests := []struct {
+		name     string
+		language string
+		paths    []JSONPath
+	}{
+		{
+			name: "jsonpath",
+			paths: []JSONPath{
+				{Path: "$.device.env.temp", Alias: "temperature"},
+				{Path: "$.device.env.humidity", Alias: "humidity", Type: JSONPathTypeNumber},
+				{Path: "$.readings[0]", Alias: "first", Type: JSONPathTypeString},
+				{Path: "$.readings", Alias: "readings"},
+				{Path: "$.ok", Alias: "ok"},
+			},
+		},
+		{
+			name:     "jmespath",
+			language: JMESPathLanguage,
+			paths: []JSONPath{
+				{Path: "device.env.temp", Alias: "temperature"},
+				{Path: "device.env.humidity", Alias: "humidity", Type: JSONPathTypeNumber},
+				{Path: "readings[0]", Alias: "first", Type: JSONPathTypeString},
+				{Path: "readings", Alias: "readings"},
+				{Path: "ok", Alias: "ok"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFramer(FrameOptions{JSONPaths: tt.paths, JSONPathLanguage: tt.language})
+			frame, err := f.toFrame(messages, log.DefaultLogger)
+			require.NoError(t, err)
+
+			require.Len(t, frame.Fields, 6)
+			require.Equal(t, 3, frame.Rows())
+
+			temperature := frame.Fields[1]
+			require.Equal(t, "temperature", temperature.Name)
+			require.Equal(t, data.FieldTypeNullableFloat64, temperature.Type())
+			require.Equal(t, []any{21.5, 22.0, nil}, fieldValues(temperature))
+
+			humidity := frame.Fields[2]
+			require.Equal(t, "humidity", humidity.Name)
+			require.Equal(t, data.FieldTypeNullableFloat64, humidity.Type())
+			require.Equal(t, []any{40.0, nil, nil}, fieldValues(humidity))
+
+			first := frame.Fields[3]
+			require.Equal(t, data.FieldTypeNullableString, first.Type())
+			require.Equal(t, []any{"1", "4", nil}, fieldValues(first))
+
+			readings := frame.Fields[4]
+			require.Equal(t, data.FieldTypeJSON, readings.Type())
+			require.Equal(t, json.RawMessage(`[1,2,3]`), readings.At(0))
+
+			ok := frame.Fields[5]
+			require.Equal(t, data.FieldTypeNullableBool, ok.Type())
+			require.Equal(t, []any{true, false, nil}, fieldValues(ok))
+		})
+	}
+}
+
+func Test_framer_JSONPaths_DefaultName(t *testing.T) {
+	f := newFramer(FrameOptions{JSONPaths: []JSONPath{{Path: "$.env.temp"}}})
+	frame, err := f.toFrame([]Message{{Timestamp: time.Unix(0, 0), Value: []byte(`{"env":{"temp":21}}`)}}, log.DefaultLogger)
+	require.NoError(t, err)
+	require.Equal(t, "$.env.temp", frame.Fields[1].Name)
+}
+
+func Test_framer_JSONPaths_Invalid(t *testing.T) {
+	f := newFramer(FrameOptions{JSONPaths: []JSONPath{{Path: "$.["}}})
+	_, err := f.toFrame([]Message{{Timestamp: time.Unix(0, 0), Value: []byte(`{}`)}}, log.DefaultLogger)
+	require.ErrorContains(t, err, `invalid JSONPath "$.["`)
+}
+
+func TestFrameOptions_Validate(t *testing.T) {
+	require.NoError(t, FrameOptions{}.Validate())
+	require.NoError(t, FrameOptions{JSONPaths: []JSONPath{{Path: "$.a", Type: JSONPathTypeBoolean}}}.Validate())
+	require.NoError(t, FrameOptions{JSONPathLanguage: JMESPathLanguage, JSONPaths: []JSONPath{{Path: "a.b"}}}.Validate())
+
+	require.EqualError(t, FrameOptions{JSONPaths: []JSONPath{{Alias: "a"}}}.Validate(), "path expression is required")
+	require.EqualError(t, FrameOptions{JSONPaths: []JSONPath{{Path: "$.a", Type: "time"}}}.Validate(),
+		`invalid type "time" for path "$.a": must be number, string or boolean`)
+	require.ErrorContains(t, FrameOptions{JSONPathLanguage: JMESPathLanguage, JSONPaths: []JSONPath{{Path: "a.["}}}.Validate(),
+		`invalid JMESPath "a.["`)
+	require.EqualError(t, FrameOptions{JSONPaths: []JSONPath{{Path: "$.a", Alias: "x"}, {Path: "$.b", Alias: "x"}}}.Validate(),
+		`duplicate field "x"`)
+	require.EqualError(t, FrameOptions{JSONPaths: []JSONPath{{Path: "$.a"}, {Path: "$.a"}}}.Validate(),
+		`duplicate field "$.a"`)
+	require.EqualError(t, FrameOptions{JSONPaths: []JSONPath{{Path: "$.ts", Alias: "Time"}}}.Validate(),
+		`the field "Time" has the name of the time field`)
+	require.EqualError(t, FrameOptions{JSONPathLanguage: "xpath", JSONPaths: []JSONPath{{Path: "a"}}}.Validate(),
+		`invalid path language "xpath": must be jsonpath or jmespath`)
+}
+
+func TestConvertJSONValue(t *testing.T) {
+	tests := []struct {
+		value     any
+		fieldType data.FieldType
+		expected  any
+	}{
+		{"21.5", data.FieldTypeNullableFloat64, 21.5},
+		{true, data.FieldTypeNullableFloat64, 1.0},
+		{"warm", data.FieldTypeNullableFloat64, nil},
+		{21.5, data.FieldTypeNullableString, "21.5"},
+		{map[string]any{"a": 1.0}, data.FieldTypeNullableString, `{"a":1}`},
+		{"true", data.FieldTypeNullableBool, true},
+		{0.0, data.FieldTypeNullableBool, false},
+		{"on", data.FieldTypeNullableBool, nil},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, convertJSONValue(tt.value, tt.fieldType), "%v to %s", tt.value, tt.fieldType)
+	}
+}
+
+// fieldValues returns the values of a nullable field, dereferenced.
+func fieldValues(f *data.Field) []any {
+	values := make([]any, f.Len())
+	for i := range values {
+		if v, ok := f.ConcreteAt(i); ok {
+			values[i] = v
+		}
+	}
+	return values
+}
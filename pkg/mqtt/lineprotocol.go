@@ -0,0 +1,208 @@
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// lineProtocolPoint is a line of an InfluxDB line protocol payload:
+//
+//	measurement[,tag=value...] field=value[,field=value...] [timestamp]
+type lineProtocolPoint struct {
+	measurement string
+	tags        data.Labels
+	fields      []lineProtocolField
+	timestamp   int64
+	hasTime     bool
+}
+
+type lineProtocolField struct {
+	key       string
+	fieldType data.FieldType
+	value     any
+}
+
+// addLineProtocol adds a row for each line of an InfluxDB line protocol payload. The fields
+// of the lines are labeled with their tags, and the frame is named after the measurement
+// of the first line. The fields of other measurements are prefixed with their measurement.
+func (df *framer) addLineProtocol(message Message, logger log.Logger) {
+	for _, line := range strings.Split(string(message.Value), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		p, err := parseLineProtocol(line)
+		if err != nil {
+			logger.Debug("invalid line protocol", "error", err, "line", line)
+			continue
+		}
+
+		if df.name == "" {
+			df.name = p.measurement
+		}
+		for _, f := range p.fields {
+			name := f.key
+			if p.measurement != df.name {
+				name = p.measurement + df.separator() + f.key
+			}
+			df.addNamedValue(name, p.tags, f.fieldType, f.value)
+		}
+
+		t := message.Timestamp
+		if p.hasTime {
+			t = lineProtocolTime(p.timestamp, df.options.TimeFormat)
+		}
+		df.appendMessageAt(message, t)
+	}
+}
+
+// lineProtocolTime converts the timestamp of a line in the precision of the epoch time format,
+// which defaults to nanoseconds.
+func lineProtocolTime(timestamp int64, format string) time.Time {
+	switch format {
+	case TimeFormatEpochSeconds:
+		return time.Unix(timestamp, 0)
+	case TimeFormatEpochMilliseconds:
+		return time.UnixMilli(timestamp)
+	case TimeFormatEpochMicroseconds:
+		return time.UnixMicro(timestamp)
+	}
+	return time.Unix(0, timestamp)
+}
+
+// parseLineProtocol parses a line of InfluxDB line protocol.
+func parseLineProtocol(line string) (lineProtocolPoint, error) {
+	var p lineProtocolPoint
+
+	measurement, i := readLineProtocolToken(line, 0, ", ")
+	if measurement == "" {
+		return p, errors.New("missing measurement")
+	}
+	p.measurement = measurement
+
+	for i < len(line) && line[i] == ',' {
+		key, next := readLineProtocolToken(line, i+1, ",= ")
+		if key == "" || next >= len(line) || line[next] != '=' {
+			return p, fmt.Errorf("invalid tag at position %d", i+1)
+		}
+		value, next := readLineProtocolToken(line, next+1, ", ")
+		if value == "" {
+			return p, fmt.Errorf("missing value of tag %q", key)
+		}
+		if p.tags == nil {
+			p.tags = data.Labels{}
+		}
+		p.tags[key] = value
+		i = next
+	}
+
+	i = skipSpaces(line, i)
+	for {
+		key, next := readLineProtocolToken(line, i, ",= ")
+		if key == "" || next >= len(line) || line[next] != '=' {
+			return p, fmt.Errorf("invalid field at position %d", i)
+		}
+		f, next, err := readLineProtocolValue(line, next+1)
+		if err != nil {
+			return p, fmt.Errorf("invalid value of field %q: %w", key, err)
+		}
+		f.key = key
+		p.fields = append(p.fields, f)
+		i = next
+		if i >= len(line) || line[i] != ',' {
+			break
+		}
+		i++
+	}
+
+	if i < len(line) && line[i] != ' ' {
+		return p, fmt.Errorf("unexpected character at position %d", i)
+	}
+	if timestamp := strings.TrimSpace(line[i:]); timestamp != "" {
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid timestamp %q", timestamp)
+		}
+		p.timestamp, p.hasTime = ts, true
+	}
+	return p, nil
+}
+
+// readLineProtocolToken reads an unquoted token from position i until one of the stop
+// characters, unescaping the escaped commas, equal signs, spaces and backslashes.
+// It returns the token and the position of the stop character.
+func readLineProtocolToken(line string, i int, stops string) (string, int) {
+	var b strings.Builder
+	for ; i < len(line); i++ {
+		c := line[i]
+		if c == '\\' && i+1 < len(line) && strings.IndexByte(",= \\", line[i+1]) >= 0 {
+			i++
+			b.WriteByte(line[i])
+			continue
+		}
+		if strings.IndexByte(stops, c) >= 0 {
+			break
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), i
+}
+
+// readLineProtocolValue reads a field value from position i. Strings are quoted, integers have the
+// suffix "i", unsigned integers the suffix "u", and numbers without a suffix are floats.
+func readLineProtocolValue(line string, i int) (lineProtocolField, int, error) {
+	if i < len(line) && line[i] == '"' {
+		var b strings.Builder
+		for i++; i < len(line); i++ {
+			c := line[i]
+			if c == '\\' && i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\') {
+				i++
+				b.WriteByte(line[i])
+				continue
+			}
+			if c == '"' {
+				s := b.String()
+				return lineProtocolField{fieldType: data.FieldTypeNullableString, value: &s}, i + 1, nil
+			}
+			b.WriteByte(c)
+		}
+		return lineProtocolField{}, i, errors.New("unterminated string")
+	}
+
+	end := i
+	for end < len(line) && line[end] != ',' && line[end] != ' ' {
+		end++
+	}
+	token := line[i:end]
+	switch token {
+	case "t", "T", "true", "True", "TRUE":
+		v := true
+		return lineProtocolField{fieldType: data.FieldTypeNullableBool, value: &v}, end, nil
+	case "f", "F", "false", "False", "FALSE":
+		v := false
+		return lineProtocolField{fieldType: data.FieldTypeNullableBool, value: &v}, end, nil
+	}
+	if n, ok := strings.CutSuffix(token, "i"); ok {
+		v, err := strconv.ParseInt(n, 10, 64)
+		return lineProtocolField{fieldType: data.FieldTypeNullableInt64, value: &v}, end, err
+	}
+	if n, ok := strings.CutSuffix(token, "u"); ok {
+		v, err := strconv.ParseUint(n, 10, 64)
+		return lineProtocolField{fieldType: data.FieldTypeNullableUint64, value: &v}, end, err
+	}
+	v, err := strconv.ParseFloat(token, 64)
+	return lineProtocolField{fieldType: data.FieldTypeNullableFloat64, value: &v}, end, err
+}
+
+func skipSpaces(line string, i int) int {
+	for i < len(line) && line[i] == ' ' {
+		i++
+	}
+	return i
+}
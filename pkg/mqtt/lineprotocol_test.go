@@ -0,0 +1,73 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLineProtocol(t *testing.T) {
+	p, err := parseLineProtocol(`weather,location=us-midwest,season=summer temperature=82,humidity=71i,count=3u,raining=f,note="it's \"hot\"" 1465839830100400200`)
+	require.NoError(t, err)
+	require.Equal(t, "weather", p.measurement)
+	require.Equal(t, data.Labels{"location": "us-midwest", "season": "summer"}, p.tags)
+	require.True(t, p.hasTime)
+	require.Equal(t, int64(1465839830100400200), p.timestamp)
+
+	var keys []string
+	var values []any
+	for _, f := range p.fields {
+		keys = append(keys, f.key)
+		values = append(values, derefValue(f.value))
+	}
+	require.Equal(t, []string{"temperature", "humidity", "count", "raining", "note"}, keys)
+	require.Equal(t, []any{82.0, int64(71), uint64(3), false, `it's "hot"`}, values)
+
+	// escaped characters
+	p, err = parseLineProtocol(`my\ weather,my\,tag=a\ b\=c temp\=c=1.5`)
+	require.NoError(t, err)
+	require.Equal(t, "my weather", p.measurement)
+	require.Equal(t, data.Labels{"my,tag": "a b=c"}, p.tags)
+	require.Equal(t, "temp=c", p.fields[0].key)
+	require.False(t, p.hasTime)
+
+	for _, line := range []string{
+		"weather",
+		"weather temperature",
+		"weather temperature=",
+		"weather,location temperature=1",
+		"weather temperature=1x",
+		`weather note="unterminated`,
+		"weather temperature=1 yesterday",
+	} {
+		_, err := parseLineProtocol(line)
+		require.Error(t, err, line)
+	}
+}
+
+func Test_framer_LineProtocol(t *testing.T) {
+	received := time.Unix(1714570000, 0)
+	messages := []Message{
+		{Timestamp: received, Value: []byte("cpu,host=a usage=12.5,cores=4i 1714566615000000000\ncpu,host=b usage=40 1714566615000000000")},
+		// comments, invalid lines and other measurements
+		{Timestamp: received.Add(time.Second), Value: []byte("# comment\ncpu,host=a usage=13.5,cores=4i 1714566616000000000\ninvalid\nmem,host=a used=1024u 1714566616000000000")},
+		// lines without a timestamp have the time the message was received
+		{Timestamp: received.Add(2 * time.Second), Value: []byte("cpu,host=b usage=38")},
+	}
+
+	frame, err := newFramer(FrameOptions{PayloadFormat: PayloadFormatInflux}).toFrame(messages, log.DefaultLogger)
+	require.NoError(t, err)
+	experimental.CheckGoldenJSONFrame(t, "testdata", "line-protocol", frame, update)
+}
+
+func TestLineProtocolTime(t *testing.T) {
+	expected := time.Unix(1714566615, 0)
+	require.Equal(t, expected, lineProtocolTime(1714566615000000000, ""))
+	require.Equal(t, expected, lineProtocolTime(1714566615, TimeFormatEpochSeconds))
+	require.Equal(t, expected, lineProtocolTime(1714566615000, TimeFormatEpochMilliseconds))
+	require.Equal(t, expected, lineProtocolTime(1714566615000000, TimeFormatEpochMicroseconds))
+}
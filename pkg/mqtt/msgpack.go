@@ -0,0 +1,79 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/vmihailenco/msgpack/v5/msgpcode"
+)
+
+// msgpackToJSON converts a MessagePack payload to JSON. Binary data is converted to base64
+// strings, timestamps to RFC 3339 strings, and keys that aren't strings to their JSON text.
+// Payloads with other extension types can't be converted.
+func msgpackToJSON(payload []byte) ([]byte, error) {
+	r := bytes.NewReader(payload)
+	v, err := decodeMsgpack(msgpack.NewDecoder(r), 0)
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() > 0 {
+		return nil, errors.New("msgpack: unexpected data after the value")
+	}
+	v, err = jsonValue(v, 0)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// decodeMsgpack decodes the next value of a MessagePack payload. The maps and arrays are
+// decoded here, so their nesting is limited while decoding, like the nesting of CBOR payloads.
+func decodeMsgpack(d *msgpack.Decoder, depth int) (any, error) {
+	if depth > maxPayloadDepth {
+		return nil, errors.New("msgpack: maximum nesting depth exceeded")
+	}
+	c, err := d.PeekCode()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case msgpcode.IsFixedMap(c) || c == msgpcode.Map16 || c == msgpcode.Map32:
+		n, err := d.DecodeMapLen()
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]any)
+		for i := 0; i < n; i++ {
+			key, err := decodeMsgpack(d, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			k, err := jsonKey(key)
+			if err != nil {
+				return nil, err
+			}
+			if m[k], err = decodeMsgpack(d, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case msgpcode.IsFixedArray(c) || c == msgpcode.Array16 || c == msgpcode.Array32:
+		n, err := d.DecodeArrayLen()
+		if err != nil {
+			return nil, err
+		}
+		var a []any
+		for i := 0; i < n; i++ {
+			value, err := decodeMsgpack(d, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, value)
+		}
+		return a, nil
+	}
+	return d.DecodeInterface()
+}
@@ -0,0 +1,167 @@
+package mqtt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Payload formats of the messages. The CBOR and MessagePack formats are converted
+// to JSON, so they are converted to fields like JSON payloads.
+const (
+	// PayloadFormatAuto decodes JSON payloads. Other payloads, including CBOR and
+	// MessagePack payloads, are strings.
+	PayloadFormatAuto        = "auto"
+	PayloadFormatJSON        = "json"
+	PayloadFormatCBOR        = "cbor"
+	PayloadFormatMessagePack = "msgpack"
+	// PayloadFormatInflux converts each line of InfluxDB line protocol payloads to a row.
+	PayloadFormatInflux = "influx"
+	// PayloadFormatCSV converts each line of delimited text payloads to a row.
+	PayloadFormatCSV = "csv"
+	// PayloadFormatBinary converts the values at the positions of the binary fields to a row.
+	PayloadFormatBinary = "binary"
+	// PayloadFormatRegex converts the named capture groups of a regular expression
+	// matching text payloads to fields.
+	PayloadFormatRegex = "regex"
+	// PayloadFormatRaw converts payloads to a string field without decoding them.
+	PayloadFormatRaw = "raw"
+)
+
+// maxPayloadDepth limits the nesting of the maps and arrays of binary payloads.
+const maxPayloadDepth = 100
+
+// validatePayloadFormat returns an error if the payload format is unknown or can't be
+// combined with the other options.
+func (o FrameOptions) validatePayloadFormat() error {
+	switch o.PayloadFormat {
+	case "", PayloadFormatAuto:
+		return nil
+	case PayloadFormatJSON, PayloadFormatCBOR, PayloadFormatMessagePack, PayloadFormatInflux, PayloadFormatCSV, PayloadFormatBinary, PayloadFormatRegex, PayloadFormatRaw:
+		if o.ProtoMessage != "" {
+			return fmt.Errorf("the protobuf message %q can't be combined with the payload format %q", o.ProtoMessage, o.PayloadFormat)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid payload format %q: must be auto, json, cbor, msgpack, influx, csv, binary, regex or raw", o.PayloadFormat)
+}
+
+// decodesToJSON reports whether the payloads are decoded to JSON, so the options
+// for JSON payloads, such as exploding arrays, apply to them.
+func (o FrameOptions) decodesToJSON() bool {
+	switch o.PayloadFormat {
+	case PayloadFormatInflux, PayloadFormatCSV, PayloadFormatBinary, PayloadFormatRegex, PayloadFormatRaw:
+		return false
+	}
+	return true
+}
+
+// decode converts the payload of the message to JSON according to the payload format.
+// Payloads that are JSON, or strings for the auto format, are returned as is. CBOR and
+// MessagePack payloads are only decoded with their format, and are strings with the auto format.
+func (df *framer) decode(message Message) (Message, error) {
+	if df.sparkplugPayload(message) != nil {
+		return message, nil
+	}
+
+	var err error
+	switch df.options.PayloadFormat {
+	case "", PayloadFormatAuto:
+		if df.options.protoMessage != nil {
+			message.Value, err = protoToJSON(df.options.protoMessage, message.Value)
+		}
+	case PayloadFormatCBOR:
+		message.Value, err = cborToJSON(message.Value)
+	case PayloadFormatMessagePack:
+		message.Value, err = msgpackToJSON(message.Value)
+	}
+	return message, err
+}
+
+// jsonValue converts a value decoded from a binary payload to a value encoding/json can
+// marshal. Maps get string keys, NaN and infinities are null, and tags are their content.
+func jsonValue(v any, depth int) (any, error) {
+	if depth > maxPayloadDepth {
+		return nil, errors.New("maximum nesting depth exceeded")
+	}
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for key, value := range v {
+			k, err := jsonKey(key)
+			if err != nil {
+				return nil, err
+			}
+			if m[k], err = jsonValue(value, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for key, value := range v {
+			var err error
+			if m[key], err = jsonValue(value, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []any:
+		a := make([]any, len(v))
+		for i, value := range v {
+			var err error
+			if a[i], err = jsonValue(value, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	case cbor.Tag:
+		return jsonValue(v.Content, depth+1)
+	case cbor.ByteString:
+		return base64.StdEncoding.EncodeToString([]byte(v)), nil
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano), nil
+	case float32:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return nil, nil
+		}
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, nil
+		}
+	}
+	return v, nil
+}
+
+// jsonKey converts a map key to a string, so keys that aren't strings, such as the
+// integer keys of CBOR maps, are kept as their JSON text.
+func jsonKey(key any) (string, error) {
+	v, err := jsonValue(key, 0)
+	if err != nil {
+		return "", err
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// appendJSONFloat appends a float as a JSON number, or null for NaN and infinities.
+func appendJSONFloat(b []byte, f float64, bitSize int) []byte {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return append(b, "null"...)
+	}
+	return strconv.AppendFloat(b, f, 'g', -1, bitSize)
+}
+
+func appendJSONString(b []byte, s string) []byte {
+	quoted, _ := json.Marshal(s)
+	return append(b, quoted...)
+}
@@ -0,0 +1,181 @@
+package mqtt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental"
+	"github.com/stretchr/testify/require"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+func TestCBORToJSON(t *testing.T) {
+	// the examples of RFC 8949, appendix A
+	tests := []struct {
+		cbor     string
+		expected string
+	}{
+		{cbor: "00", expected: `0`},
+		{cbor: "17", expected: `23`},
+		{cbor: "1903e8", expected: `1000`},
+		{cbor: "1bffffffffffffffff", expected: `18446744073709551615`},
+		{cbor: "20", expected: `-1`},
+		{cbor: "3903e7", expected: `-1000`},
+		{cbor: "c249010000000000000000", expected: `18446744073709551616`},
+		{cbor: "f93e00", expected: `1.5`},
+		{cbor: "f97bff", expected: `65504`},
+		{cbor: "f90001", expected: `5.960464477539063e-8`},
+		{cbor: "fa47c35000", expected: `100000`},
+		{cbor: "fb3ff199999999999a", expected: `1.1`},
+		{cbor: "f97c00", expected: `null`},
+		{cbor: "f4", expected: `false`},
+		{cbor: "f5", expected: `true`},
+		{cbor: "f6", expected: `null`},
+		{cbor: "c074323031332d30332d32315432303a30343a30305a", expected: `"2013-03-21T20:04:00Z"`},
+		{cbor: "c11a514b67b0", expected: `"2013-03-21T20:04:00Z"`},
+		{cbor: "4401020304", expected: `"AQIDBA=="`},
+		{cbor: "6449455446", expected: `"IETF"`},
+		{cbor: "62c3bc", expected: `"ü"`},
+		{cbor: "8301820203820405", expected: `[1,[2,3],[4,5]]`},
+		{cbor: "a201020304", expected: `{"1":2,"3":4}`},
+		{cbor: "a26161016162820203", expected: `{"a":1,"b":[2,3]}`},
+		{cbor: "5f42010243030405ff", expected: `"AQIDBAU="`},
+		{cbor: "7f657374726561646d696e67ff", expected: `"streaming"`},
+		{cbor: "9f018202039f0405ffff", expected: `[1,[2,3],[4,5]]`},
+		{cbor: "bf61610161629f0203ffff", expected: `{"a":1,"b":[2,3]}`},
+		// the keys are sorted
+		{cbor: "a2617a016161f5", expected: `{"a":true,"z":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cbor, func(t *testing.T) {
+			actual, err := cborToJSON(mustDecodeHex(t, tt.cbor))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, string(actual))
+		})
+	}
+
+	for _, invalid := range []string{"", "18", "62c3", "830102", "0001", "ff", "1c", "9f01", "1f"} {
+		_, err := cborToJSON(mustDecodeHex(t, invalid))
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestMsgpackToJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		msgpack  string
+		expected string
+	}{
+		{name: "positive fixint", msgpack: "7f", expected: `127`},
+		{name: "negative fixint", msgpack: "e0", expected: `-32`},
+		{name: "uint16", msgpack: "cd03e8", expected: `1000`},
+		{name: "uint64", msgpack: "cfffffffffffffffff", expected: `18446744073709551615`},
+		{name: "int8", msgpack: "d080", expected: `-128`},
+		{name: "int32", msgpack: "d2fffffc18", expected: `-1000`},
+		{name: "float32", msgpack: "ca3fc00000", expected: `1.5`},
+		{name: "float64", msgpack: "cb3ff199999999999a", expected: `1.1`},
+		{name: "nil", msgpack: "c0", expected: `null`},
+		{name: "bool", msgpack: "c3", expected: `true`},
+		{name: "fixstr", msgpack: "a3616263", expected: `"abc"`},
+		{name: "str8", msgpack: "d903616263", expected: `"abc"`},
+		{name: "bin8", msgpack: "c40401020304", expected: `"AQIDBA=="`},
+		{name: "fixarray", msgpack: "9301c2c0", expected: `[1,false,null]`},
+		{name: "array16", msgpack: "dc00020102", expected: `[1,2]`},
+		{name: "fixmap", msgpack: "82a17a01a161c3", expected: `{"a":true,"z":1}`},
+		{name: "map16 with integer keys", msgpack: "de00010102", expected: `{"1":2}`},
+		{name: "timestamp32", msgpack: "d6ff514b67b0", expected: `"2013-03-21T20:04:00Z"`},
+		{name: "timestamp64", msgpack: "d7ff3b9aca00514b67b0", expected: `"2013-03-21T20:04:00.25Z"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := msgpackToJSON(mustDecodeHex(t, tt.msgpack))
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, string(actual))
+		})
+	}
+
+	for _, invalid := range []string{"", "c1", "cd03", "a36162", "920102ff", "0101", "d7ff01", "d40105"} {
+		_, err := msgpackToJSON(mustDecodeHex(t, invalid))
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestMaxPayloadDepth(t *testing.T) {
+	// deeply nested arrays are rejected instead of exhausting the stack
+	nested := bytes.Repeat([]byte{0x91}, 5<<20)
+	_, err := msgpackToJSON(append(nested, 0x01))
+	require.EqualError(t, err, "msgpack: maximum nesting depth exceeded")
+
+	nested = bytes.Repeat([]byte{0x81}, 5<<20)
+	_, err = cborToJSON(append(nested, 0x01))
+	require.Error(t, err)
+
+	_, err = msgpackToJSON(append(bytes.Repeat([]byte{0x91}, maxPayloadDepth), 0x01))
+	require.NoError(t, err)
+}
+
+func TestFrameOptions_PayloadFormat(t *testing.T) {
+	require.NoError(t, FrameOptions{PayloadFormat: PayloadFormatCBOR}.Validate())
+	require.NoError(t, FrameOptions{PayloadFormat: PayloadFormatAuto, ProtoMessage: "acme.Reading"}.Validate())
+	require.EqualError(t, FrameOptions{PayloadFormat: "xml"}.Validate(), `invalid payload format "xml": must be auto, json, cbor, msgpack, influx, csv, binary, regex or raw`)
+	require.EqualError(t, FrameOptions{PayloadFormat: PayloadFormatCBOR, ProtoMessage: "acme.Reading"}.Validate(),
+		`the protobuf message "acme.Reading" can't be combined with the payload format "cbor"`)
+}
+
+func Test_framer_PayloadFormat(t *testing.T) {
+	timestamp := time.Unix(0, 0)
+	cbor := mustDecodeHex(t, "a26474656d70f94d60626f6bf5")
+	msgpack := mustDecodeHex(t, "82a474656d70cb4036800000000000a26f6bc2")
+
+	tests := []struct {
+		name     string
+		format   string
+		payloads [][]byte
+	}{
+		{name: "auto", format: PayloadFormatAuto, payloads: [][]byte{[]byte(`{"temp":23.5,"ok":true}`), []byte("online")}},
+		{name: "cbor", format: PayloadFormatCBOR, payloads: [][]byte{cbor, msgpack}},
+		{name: "msgpack", format: PayloadFormatMessagePack, payloads: [][]byte{msgpack, cbor}},
+		{name: "raw", format: PayloadFormatRaw, payloads: [][]byte{[]byte(`{"temp":23.5}`), []byte("21.5")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var messages []Message
+			for i, payload := range tt.payloads {
+				messages = append(messages, Message{Timestamp: timestamp.Add(time.Duration(i) * time.Minute), Value: payload})
+			}
+			frame, err := newFramer(FrameOptions{PayloadFormat: tt.format}).toFrame(messages, log.DefaultLogger)
+			require.NoError(t, err)
+			experimental.CheckGoldenJSONFrame(t, "testdata", "payload-format-"+tt.name, frame, update)
+		})
+	}
+}
+
+func Test_framer_PayloadFormat_AutoKeepsBinary(t *testing.T) {
+	// CBOR and MessagePack payloads are only decoded with their payload format
+	payloads := [][]byte{
+		mustDecodeHex(t, "a26474656d70f94d60626f6bf5"),
+		mustDecodeHex(t, "82a474656d70cb4036800000000000a26f6bc2"),
+	}
+	for _, format := range []string{"", PayloadFormatAuto} {
+		var messages []Message
+		for _, payload := range payloads {
+			messages = append(messages, Message{Timestamp: time.Unix(0, 0), Value: payload})
+		}
+		frame, err := newFramer(FrameOptions{PayloadFormat: format}).toFrame(messages, log.DefaultLogger)
+		require.NoError(t, err)
+		require.Len(t, frame.Fields, 2)
+		require.Equal(t, "Value", frame.Fields[1].Name)
+		for i, payload := range payloads {
+			require.Equal(t, string(payload), *frame.Fields[1].At(i).(*string))
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package mqtt
+
+import (
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// ProtoRegistry holds the protobuf message types of the datasource, which
+// decode the payloads of queries that select a message type.
+type ProtoRegistry struct {
+	files *protoregistry.Files
+}
+
+// NewProtoRegistry creates a registry from a base64 encoded FileDescriptorSet, as generated
+// by "protoc --include_imports --descriptor_set_out". An empty string creates an empty registry.
+func NewProtoRegistry(descriptors string) (*ProtoRegistry, error) {
+	if descriptors == "" {
+		return &ProtoRegistry{files: new(protoregistry.Files)}, nil
+	}
+
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(descriptors))
+	if err != nil {
+		return nil, fmt.Errorf("invalid protobuf descriptors: %w", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("invalid protobuf descriptors: %w", err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("invalid protobuf descriptors: %w", err)
+	}
+	return &ProtoRegistry{files: files}, nil
+}
+
+// message returns the descriptor of the message type with the given full name.
+func (r *ProtoRegistry) message(name string) (protoreflect.MessageDescriptor, error) {
+	if r == nil || r.files.NumFiles() == 0 {
+		return nil, fmt.Errorf("protobuf message %q: no protobuf descriptors are configured", name)
+	}
+	d, err := r.files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("protobuf message %q not found in the descriptors", name)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a protobuf message", name)
+	}
+	return md, nil
+}
+
+// ResolveProtoMessage looks up the message type of ProtoMessage in the registry,
+// so the payloads are decoded with it. It returns an error if the type is unknown.
+func (o *FrameOptions) ResolveProtoMessage(r *ProtoRegistry) error {
+	o.protoMessage = nil
+	if o.ProtoMessage == "" {
+		return nil
+	}
+	md, err := r.message(o.ProtoMessage)
+	if err != nil {
+		return err
+	}
+	o.protoMessage = md
+	return nil
+}
+
+// protoToJSON decodes a protobuf payload and converts it to JSON, so it is converted to fields
+// like JSON payloads. Enums are converted to their names, bytes to base64 strings and
+// google.protobuf.Timestamp messages to RFC 3339 strings.
+func protoToJSON(md protoreflect.MessageDescriptor, payload []byte) ([]byte, error) {
+	m := dynamicpb.NewMessage(md)
+	if err := proto.Unmarshal(payload, m); err != nil {
+		return nil, err
+	}
+	return appendProtoMessage(nil, m), nil
+}
+
+// appendProtoMessage appends a message as a JSON object with its fields in declaration order.
+// Fields with presence, such as messages, oneofs and optional fields, are left out if unset.
+func appendProtoMessage(b []byte, m protoreflect.Message) []byte {
+	md := m.Descriptor()
+	switch md.FullName() {
+	case "google.protobuf.Timestamp":
+		fields := md.Fields()
+		t := time.Unix(m.Get(fields.ByNumber(1)).Int(), m.Get(fields.ByNumber(2)).Int()).UTC()
+		return appendJSONString(b, t.Format(time.RFC3339Nano))
+	case "google.protobuf.DoubleValue", "google.protobuf.FloatValue",
+		"google.protobuf.Int64Value", "google.protobuf.UInt64Value",
+		"google.protobuf.Int32Value", "google.protobuf.UInt32Value",
+		"google.protobuf.BoolValue", "google.protobuf.StringValue", "google.protobuf.BytesValue":
+		fd := md.Fields().ByNumber(1)
+		return appendProtoValue(b, fd, m.Get(fd))
+	}
+
+	b = append(b, '{')
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.HasPresence() && !m.Has(fd) {
+			continue
+		}
+		if b[len(b)-1] != '{' {
+			b = append(b, ',')
+		}
+		b = appendJSONString(b, string(fd.Name()))
+		b = append(b, ':')
+		b = appendProtoField(b, fd, m.Get(fd))
+	}
+	return append(b, '}')
+}
+
+// appendProtoField appends the value of a field, as a JSON array for repeated
+// fields and as a JSON object with sorted keys for maps.
+func appendProtoField(b []byte, fd protoreflect.FieldDescriptor, v protoreflect.Value) []byte {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		b = append(b, '[')
+		for i := 0; i < list.Len(); i++ {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendProtoValue(b, fd, list.Get(i))
+		}
+		return append(b, ']')
+	case fd.IsMap():
+		m := v.Map()
+		keys := make([]protoreflect.MapKey, 0, m.Len())
+		m.Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+			keys = append(keys, k)
+			return true
+		})
+		slices.SortFunc(keys, func(a, b protoreflect.MapKey) int {
+			return strings.Compare(a.String(), b.String())
+		})
+		b = append(b, '{')
+		for i, k := range keys {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSONString(b, k.String())
+			b = append(b, ':')
+			b = appendProtoValue(b, fd.MapValue(), m.Get(k))
+		}
+		return append(b, '}')
+	}
+	return appendProtoValue(b, fd, v)
+}
+
+// appendProtoValue appends a single value of a field.
+func appendProtoValue(b []byte, fd protoreflect.FieldDescriptor, v protoreflect.Value) []byte {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return strconv.AppendBool(b, v.Bool())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return strconv.AppendInt(b, v.Int(), 10)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return strconv.AppendUint(b, v.Uint(), 10)
+	case protoreflect.FloatKind:
+		return appendJSONFloat(b, v.Float(), 32)
+	case protoreflect.DoubleKind:
+		return appendJSONFloat(b, v.Float(), 64)
+	case protoreflect.StringKind:
+		return appendJSONString(b, v.String())
+	case protoreflect.BytesKind:
+		return appendJSONString(b, base64.StdEncoding.EncodeToString(v.Bytes()))
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return appendJSONString(b, string(ev.Name()))
+		}
+		// values that aren't defined in the enum keep their number
+		return strconv.AppendInt(b, int64(v.Enum()), 10)
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return appendProtoMessage(b, v.Message())
+	}
+	return append(b, "null"...)
+}
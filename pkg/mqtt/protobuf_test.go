@@ -0,0 +1,232 @@
+package mqtt
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// testProtoDescriptors returns the base64 encoded FileDescriptorSet of:
+//
+//	syntax = "proto3";
+//	package acme.sensors;
+//	import "google/protobuf/timestamp.proto";
+//
+//	enum Status { STATUS_UNKNOWN = 0; STATUS_OK = 1; STATUS_FAULT = 2; }
+//	message Location { double lat = 1; double lon = 2; }
+//	message Reading {
+//	  string device = 1;
+//	  double temperature = 2;
+//	  Status status = 3;
+//	  Location location = 4;
+//	  repeated int32 samples = 5;
+//	  google.protobuf.Timestamp time = 6;
+//	  optional int64 battery = 7;
+//	}
+func testProtoDescriptors(t *testing.T) string {
+	t.Helper()
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     typ.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	samples := field("samples", 5, descriptorpb.FieldDescriptorProto_TYPE_INT32, "")
+	samples.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	battery := field("battery", 7, descriptorpb.FieldDescriptorProto_TYPE_INT64, "")
+	battery.Proto3Optional = proto.Bool(true)
+	battery.OneofIndex = proto.Int32(0)
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("acme/sensors.proto"),
+		Package:    proto.String("acme.sensors"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Status"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("STATUS_UNKNOWN"), Number: proto.Int32(0)},
+				{Name: proto.String("STATUS_OK"), Number: proto.Int32(1)},
+				{Name: proto.String("STATUS_FAULT"), Number: proto.Int32(2)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Location"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("lat", 1, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, ""),
+					field("lon", 2, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, ""),
+				},
+			},
+			{
+				Name: proto.String("Reading"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("device", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					field("temperature", 2, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, ""),
+					field("status", 3, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".acme.sensors.Status"),
+					field("location", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".acme.sensors.Location"),
+					samples,
+					field("time", 6, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Timestamp"),
+					battery,
+				},
+				OneofDecl: []*descriptorpb.OneofDescriptorProto{{Name: proto.String("_battery")}},
+			},
+		},
+	}
+
+	b, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		protodesc.ToFileDescriptorProto(timestamppb.File_google_protobuf_timestamp_proto),
+		file,
+	}})
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// encodeProtoMessage encodes a message of the given type with the given field values.
+func encodeProtoMessage(t *testing.T, md protoreflect.MessageDescriptor, values map[string]any) []byte {
+	t.Helper()
+	m := dynamicpb.NewMessage(md)
+	for name, v := range values {
+		fd := md.Fields().ByName(protoreflect.Name(name))
+		require.NotNil(t, fd, name)
+		switch v := v.(type) {
+		case []int32:
+			list := m.Mutable(fd).List()
+			for _, e := range v {
+				list.Append(protoreflect.ValueOfInt32(e))
+			}
+		case map[string]any:
+			m.Set(fd, protoreflect.ValueOfMessage(dynamicpb.NewMessage(fd.Message()).ProtoReflect()))
+			nested := m.Get(fd).Message()
+			for k, e := range v {
+				nested.Set(fd.Message().Fields().ByName(protoreflect.Name(k)), protoreflect.ValueOf(e))
+			}
+		case protoreflect.EnumNumber:
+			m.Set(fd, protoreflect.ValueOfEnum(v))
+		default:
+			m.Set(fd, protoreflect.ValueOf(v))
+		}
+	}
+	b, err := proto.Marshal(m)
+	require.NoError(t, err)
+	return b
+}
+
+func TestNewProtoRegistry(t *testing.T) {
+	r, err := NewProtoRegistry(testProtoDescriptors(t))
+	require.NoError(t, err)
+
+	o := FrameOptions{ProtoMessage: "acme.sensors.Reading"}
+	require.NoError(t, o.ResolveProtoMessage(r))
+	require.Equal(t, protoreflect.FullName("acme.sensors.Reading"), o.protoMessage.FullName())
+
+	o = FrameOptions{ProtoMessage: "acme.sensors.Missing"}
+	require.EqualError(t, o.ResolveProtoMessage(r), `protobuf message "acme.sensors.Missing" not found in the descriptors`)
+	o = FrameOptions{ProtoMessage: "acme.sensors.Status"}
+	require.EqualError(t, o.ResolveProtoMessage(r), `"acme.sensors.Status" is not a protobuf message`)
+
+	empty, err := NewProtoRegistry("")
+	require.NoError(t, err)
+	o = FrameOptions{ProtoMessage: "acme.sensors.Reading"}
+	require.EqualError(t, o.ResolveProtoMessage(empty), `protobuf message "acme.sensors.Reading": no protobuf descriptors are configured`)
+	require.NoError(t, (&FrameOptions{}).ResolveProtoMessage(nil))
+
+	_, err = NewProtoRegistry("not base64")
+	require.Error(t, err)
+	_, err = NewProtoRegistry(base64.StdEncoding.EncodeToString([]byte("not a descriptor set")))
+	require.Error(t, err)
+}
+
+func TestProtoToJSON(t *testing.T) {
+	r, err := NewProtoRegistry(testProtoDescriptors(t))
+	require.NoError(t, err)
+	md, err := r.message("acme.sensors.Reading")
+	require.NoError(t, err)
+
+	ts := md.Fields().ByName("time").Message()
+	payload := encodeProtoMessage(t, md, map[string]any{
+		"device":      "pump1",
+		"temperature": 21.5,
+		"status":      protoreflect.EnumNumber(2),
+		"location":    map[string]any{"lat": 52.5, "lon": 13.4},
+		"samples":     []int32{1, -2},
+	})
+	// the timestamp is set separately, as its fields are int64 and int32
+	m := dynamicpb.NewMessage(md)
+	require.NoError(t, proto.Unmarshal(payload, m))
+	tm := dynamicpb.NewMessage(ts)
+	tm.Set(ts.Fields().ByName("seconds"), protoreflect.ValueOfInt64(1714566615))
+	tm.Set(ts.Fields().ByName("nanos"), protoreflect.ValueOfInt32(250000000))
+	m.Set(md.Fields().ByName("time"), protoreflect.ValueOfMessage(tm))
+	payload, err = proto.Marshal(m)
+	require.NoError(t, err)
+
+	value, err := protoToJSON(md, payload)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"device": "pump1",
+		"temperature": 21.5,
+		"status": "STATUS_FAULT",
+		"location": {"lat": 52.5, "lon": 13.4},
+		"samples": [1, -2],
+		"time": "2024-05-01T12:30:15.25Z"
+	}`, string(value))
+
+	// unset fields without presence have their zero value, and unknown enum values keep their number
+	value, err = protoToJSON(md, encodeProtoMessage(t, md, map[string]any{
+		"status":  protoreflect.EnumNumber(7),
+		"battery": int64(80),
+	}))
+	require.NoError(t, err)
+	require.Equal(t, `{"device":"","temperature":0,"status":7,"samples":[],"battery":80}`, string(value))
+
+	_, err = protoToJSON(md, []byte{0xff})
+	require.Error(t, err)
+}
+
+func Test_framer_Protobuf(t *testing.T) {
+	r, err := NewProtoRegistry(testProtoDescriptors(t))
+	require.NoError(t, err)
+	options := FrameOptions{ProtoMessage: "acme.sensors.Reading", JSONSeparator: "_"}
+	require.NoError(t, options.ResolveProtoMessage(r))
+
+	md := options.protoMessage
+	timestamp := time.Unix(0, 0)
+	messages := []Message{
+		{Timestamp: timestamp, Value: encodeProtoMessage(t, md, map[string]any{
+			"device":      "pump1",
+			"temperature": 21.5,
+			"status":      protoreflect.EnumNumber(1),
+			"location":    map[string]any{"lat": 52.5, "lon": 13.4},
+		})},
+		// invalid payloads are rows without values
+		{Timestamp: timestamp.Add(time.Minute), Value: []byte{0xff}},
+		{Timestamp: timestamp.Add(2 * time.Minute), Value: encodeProtoMessage(t, md, map[string]any{
+			"device":      "pump1",
+			"temperature": 22.5,
+			"status":      protoreflect.EnumNumber(2),
+			"battery":     int64(80),
+		})},
+	}
+
+	frame, err := newFramer(options).toFrame(messages, log.DefaultLogger)
+	require.NoError(t, err)
+	experimental.CheckGoldenJSONFrame(t, "testdata", "protobuf", frame, update)
+}
@@ -2,9 +2,13 @@ package mqtt
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
 	"net"
 	"net/url"
 
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/packets"
 	paho "github.com/eclipse/paho.mqtt.golang"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
@@ -22,24 +26,35 @@ type proxyDialer interface {
 // It checks if secure SOCKS proxy is enabled and if so, creates a custom connection function
 // that routes all MQTT traffic through the configured proxy.
 func configureProxyIfEnabled(ctx context.Context, opts *paho.ClientOptions, settings backend.DataSourceInstanceSettings, logger log.Logger) error {
+	proxyDialer, err := newProxyDialerIfEnabled(ctx, settings, logger)
+	if err != nil || proxyDialer == nil {
+		return err
+	}
+
+	opts.SetCustomOpenConnectionFn(newProxyConnectionFunc(proxyDialer, logger))
+	return nil
+}
+
+// newProxyDialerIfEnabled returns a dialer for the secure SOCKS proxy if Private Datasource
+// Connect (PDC) is enabled in the datasource settings, and nil otherwise.
+func newProxyDialerIfEnabled(ctx context.Context, settings backend.DataSourceInstanceSettings, logger log.Logger) (proxyDialer, error) {
 	proxyClient, err := settings.ProxyClient(ctx)
 	if err != nil {
-		return backend.DownstreamErrorf("MQTT proxy client creation failed: %s", err)
+		return nil, backend.DownstreamErrorf("MQTT proxy client creation failed: %s", err)
 	}
 
 	if !proxyClient.SecureSocksProxyEnabled() {
 		// PDC not enabled, use standard connection
-		return nil
+		return nil, nil
 	}
 
 	logger.Info("MQTT using secure socks proxy")
 	proxyDialer, err := proxyClient.NewSecureSocksProxyContextDialer()
 	if err != nil {
-		return backend.DownstreamErrorf("MQTT secure socks proxy dialer creation failed: %s", err)
+		return nil, backend.DownstreamErrorf("MQTT secure socks proxy dialer creation failed: %s", err)
 	}
 
-	opts.SetCustomOpenConnectionFn(newProxyConnectionFunc(proxyDialer, logger))
-	return nil
+	return proxyDialer, nil
 }
 
 // newProxyConnectionFunc creates a custom connection function for Paho MQTT
@@ -57,6 +72,47 @@ func newProxyConnectionFunc(dialer proxyDialer, logger log.Logger) paho.OpenConn
 	}
 }
 
+// newProxyAttemptConnectionFunc creates a custom connection function for the MQTT v5 client.
+// Unlike the v3 client, autopaho does not wrap custom connections in TLS, so this is done here
+// for the TLS schemes.
+func newProxyAttemptConnectionFunc(dialer proxyDialer, logger log.Logger) func(context.Context, autopaho.ClientConfig, *url.URL) (net.Conn, error) {
+	return func(ctx context.Context, cfg autopaho.ClientConfig, uri *url.URL) (net.Conn, error) {
+		network := "tcp"
+		address := buildAddress(uri)
+
+		logger.Debug("MQTT connecting via secure socks proxy",
+			"network", network,
+			"address", address,
+			"scheme", uri.Scheme)
+
+		switch uri.Scheme {
+		case "ws", "wss":
+			return nil, fmt.Errorf("websocket connections are not supported through the secure socks proxy")
+		}
+
+		conn, err := dialer.Dial(network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		switch uri.Scheme {
+		case "ssl", "tls", "tcps", "mqtts":
+			tlsConfig := cfg.TlsCfg.Clone()
+			if tlsConfig.ServerName == "" {
+				tlsConfig.ServerName = uri.Hostname()
+			}
+			tlsConn := tls.Client(conn, tlsConfig)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+			conn = tlsConn
+		}
+
+		return packets.NewThreadSafeConn(conn), nil
+	}
+}
+
 // buildAddress constructs a "host:port" address string from a URL.
 func buildAddress(uri *url.URL) string {
 	// If port is already specified, use the full host:port
@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"testing"
 
+	"github.com/eclipse/paho.golang/autopaho"
 	paho "github.com/eclipse/paho.mqtt.golang"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/stretchr/testify/assert"
@@ -441,3 +442,35 @@ func TestNewProxyConnectionFunc_WithPort(t *testing.T) {
 	assert.Equal(t, "tcp", mockDialer.callLog[0].network)
 	assert.Equal(t, "broker.example.com:9999", mockDialer.callLog[0].address)
 }
+
+// TestNewProxyAttemptConnectionFunc tests the proxy connection function used by the MQTT v5 client
+func TestNewProxyAttemptConnectionFunc(t *testing.T) {
+	logger := backend.NewLoggerWith("logger", "test")
+
+	t.Run("dials through the proxy", func(t *testing.T) {
+		mockDialer := &mockProxyDialer{}
+		connFunc := newProxyAttemptConnectionFunc(mockDialer, logger)
+
+		uri, err := url.Parse("mqtt://broker.example.com")
+		require.NoError(t, err)
+
+		_, err = connFunc(context.Background(), autopaho.ClientConfig{}, uri)
+		require.Error(t, err)
+
+		require.Len(t, mockDialer.callLog, 1)
+		assert.Equal(t, "tcp", mockDialer.callLog[0].network)
+		assert.Equal(t, "broker.example.com:1883", mockDialer.callLog[0].address)
+	})
+
+	t.Run("rejects websockets", func(t *testing.T) {
+		mockDialer := &mockProxyDialer{}
+		connFunc := newProxyAttemptConnectionFunc(mockDialer, logger)
+
+		uri, err := url.Parse("wss://broker.example.com")
+		require.NoError(t, err)
+
+		_, err = connFunc(context.Background(), autopaho.ClientConfig{}, uri)
+		require.Error(t, err)
+		assert.Empty(t, mockDialer.callLog)
+	})
+}
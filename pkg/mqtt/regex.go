@@ -0,0 +1,64 @@
+package mqtt
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// compileRegex compiles the regular expression of text payloads, which
+// needs a named capture group for each field.
+func compileRegex(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, errors.New("the regex payload format needs a regular expression")
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %w", err)
+	}
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			return re, nil
+		}
+	}
+	return nil, fmt.Errorf("the regular expression %q has no named capture groups, such as (?P<temperature>[0-9.]+)", expr)
+}
+
+// addRegex adds a row with a field for each named capture group of the regular expression,
+// with the values of its first match in the payload. The types of the values are inferred.
+// Payloads that don't match are rows without values.
+func (df *framer) addRegex(message Message, logger log.Logger) error {
+	if df.regex == nil {
+		re, err := compileRegex(df.options.Regex)
+		if err != nil {
+			return err
+		}
+		df.regex = re
+	}
+
+	match := df.regex.FindSubmatchIndex(message.Value)
+	if match == nil {
+		logger.Debug("regular expression doesn't match the payload", "value", string(message.Value))
+		df.appendMessage(message)
+		return nil
+	}
+	for i, name := range df.regex.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		df.path = append(df.path[:0], name)
+		// groups that didn't participate in the match are null
+		var v any
+		if start, end := match[2*i], match[2*i+1]; start >= 0 {
+			v = inferTextValue(string(message.Value[start:end]))
+		}
+		if err := df.addJSONValue(jsonValueType(v), v); err != nil {
+			return err
+		}
+	}
+	df.path = df.path[:0]
+	df.appendMessage(message)
+	return nil
+}
@@ -0,0 +1,40 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFrameOptions_Regex(t *testing.T) {
+	require.NoError(t, FrameOptions{PayloadFormat: PayloadFormatRegex, Regex: `T=(?P<temperature>[0-9.]+)`}.Validate())
+	require.EqualError(t, FrameOptions{PayloadFormat: PayloadFormatRegex}.Validate(), "the regex payload format needs a regular expression")
+	require.EqualError(t, FrameOptions{PayloadFormat: PayloadFormatRegex, Regex: `T=([0-9.]+)`}.Validate(),
+		`the regular expression "T=([0-9.]+)" has no named capture groups, such as (?P<temperature>[0-9.]+)`)
+	require.ErrorContains(t, FrameOptions{PayloadFormat: PayloadFormatRegex, Regex: `T=(?P<temperature>[0-9.]+`}.Validate(), "invalid regular expression")
+
+	// the regular expression is ignored for other formats
+	require.NoError(t, FrameOptions{Regex: `(`}.Validate())
+}
+
+func Test_framer_Regex(t *testing.T) {
+	received := time.Unix(1714570000, 0)
+	messages := []Message{
+		{Timestamp: received, Value: []byte("T=21.5C H=40% state=ok alarm=false")},
+		// optional groups that don't match are null
+		{Timestamp: received.Add(time.Second), Value: []byte("T=22C H=41%")},
+		// payloads that don't match are rows without values
+		{Timestamp: received.Add(2 * time.Second), Value: []byte("sensor offline")},
+	}
+
+	options := FrameOptions{
+		PayloadFormat: PayloadFormatRegex,
+		Regex:         `T=(?P<temperature>-?[0-9.]+)C H=(?P<humidity>[0-9.]+)%(?: state=(?P<state>\w+) alarm=(?P<alarm>\w+))?`,
+	}
+	frame, err := newFramer(options).toFrame(messages, log.DefaultLogger)
+	require.NoError(t, err)
+	experimental.CheckGoldenJSONFrame(t, "testdata", "regex", frame, update)
+}
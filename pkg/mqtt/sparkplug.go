@@ -0,0 +1,485 @@
+package mqtt
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// SparkplugNamespace is the first level of the topics of Sparkplug B messages:
+// spBv1.0/{group_id}/{message_type}/{edge_node_id}[/{device_id}]
+const SparkplugNamespace = "spBv1.0"
+
+// Sparkplug B message types.
+const (
+	SparkplugNBIRTH = "NBIRTH"
+	SparkplugNDEATH = "NDEATH"
+	SparkplugNDATA  = "NDATA"
+	SparkplugNCMD   = "NCMD"
+	SparkplugDBIRTH = "DBIRTH"
+	SparkplugDDEATH = "DDEATH"
+	SparkplugDDATA  = "DDATA"
+	SparkplugDCMD   = "DCMD"
+)
+
+// Sparkplug B metric data types.
+const (
+	sparkplugInt8     = 1
+	sparkplugInt16    = 2
+	sparkplugInt32    = 3
+	sparkplugInt64    = 4
+	sparkplugUInt8    = 5
+	sparkplugUInt16   = 6
+	sparkplugUInt32   = 7
+	sparkplugUInt64   = 8
+	sparkplugFloat    = 9
+	sparkplugDouble   = 10
+	sparkplugBoolean  = 11
+	sparkplugString   = 12
+	sparkplugDateTime = 13
+	sparkplugText     = 14
+	sparkplugUUID     = 15
+)
+
+// sparkplugTopic is a topic in the Sparkplug B namespace.
+type sparkplugTopic struct {
+	GroupID     string
+	MessageType string
+	EdgeNodeID  string
+	// DeviceID is empty for the messages of edge nodes.
+	DeviceID string
+}
+
+// parseSparkplugTopic parses a topic in the Sparkplug B namespace. It returns false
+// for other topics and for the STATE messages of host applications, which aren't protobuf.
+func parseSparkplugTopic(topic string) (sparkplugTopic, bool) {
+	levels := strings.Split(topic, "/")
+	if levels[0] != SparkplugNamespace || (len(levels) != 4 && len(levels) != 5) {
+		return sparkplugTopic{}, false
+	}
+	t := sparkplugTopic{GroupID: levels[1], MessageType: levels[2], EdgeNodeID: levels[3]}
+	if len(levels) == 5 {
+		t.DeviceID = levels[4]
+	}
+	switch t.MessageType {
+	case SparkplugNBIRTH, SparkplugNDEATH, SparkplugNDATA, SparkplugNCMD:
+		return t, t.DeviceID == ""
+	case SparkplugDBIRTH, SparkplugDDEATH, SparkplugDDATA, SparkplugDCMD:
+		return t, t.DeviceID != ""
+	}
+	return sparkplugTopic{}, false
+}
+
+// nodeKey returns the key of the edge node of the topic.
+func (t sparkplugTopic) nodeKey() string {
+	return t.GroupID + "/" + t.EdgeNodeID
+}
+
+// key returns the key of the edge node or device of the topic.
+func (t sparkplugTopic) key() string {
+	if t.DeviceID == "" {
+		return t.nodeKey()
+	}
+	return t.nodeKey() + "/" + t.DeviceID
+}
+
+// sparkplugPayload is a decoded Sparkplug B payload.
+type sparkplugPayload struct {
+	// Timestamp is zero if the payload has no timestamp.
+	Timestamp time.Time
+	Seq       uint64
+	Metrics   []sparkplugMetric
+}
+
+// sparkplugMetric is a metric of a Sparkplug B payload.
+type sparkplugMetric struct {
+	// Name is empty for metrics that only have an alias, until it is resolved.
+	Name     string
+	Alias    uint64
+	HasAlias bool
+	// Timestamp is zero if the metric has no timestamp.
+	Timestamp time.Time
+	DataType  uint32
+	IsNull    bool
+	// Value is the value as encoded in the payload: uint32, uint64, float32,
+	// float64, bool, string, or nil for the value types that aren't supported.
+	Value any
+}
+
+// decodeSparkplugPayload decodes the protobuf encoded Sparkplug B payload.
+func decodeSparkplugPayload(b []byte) (*sparkplugPayload, error) {
+	p := &sparkplugPayload{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			p.Timestamp = time.UnixMilli(int64(v))
+			b = b[n:]
+		case num == 2 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			metric, err := decodeSparkplugMetric(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid metric: %w", err)
+			}
+			p.Metrics = append(p.Metrics, metric)
+			b = b[n:]
+		case num == 3 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			p.Seq = v
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return p, nil
+}
+
+func decodeSparkplugMetric(b []byte) (sparkplugMetric, error) {
+	var m sparkplugMetric
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return m, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return m, protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch num {
+			case 2:
+				m.Alias, m.HasAlias = v, true
+			case 3:
+				m.Timestamp = time.UnixMilli(int64(v))
+			case 4:
+				m.DataType = uint32(v)
+			case 7:
+				m.IsNull = v != 0
+			case 10:
+				m.Value = uint32(v)
+			case 11:
+				m.Value = v
+			case 14:
+				m.Value = v != 0
+			}
+		case protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(b)
+			if n < 0 {
+				return m, protowire.ParseError(n)
+			}
+			b = b[n:]
+			if num == 12 {
+				m.Value = math.Float32frombits(v)
+			}
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			if n < 0 {
+				return m, protowire.ParseError(n)
+			}
+			b = b[n:]
+			if num == 13 {
+				m.Value = math.Float64frombits(v)
+			}
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return m, protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch num {
+			case 1:
+				m.Name = string(v)
+			case 15:
+				m.Value = string(v)
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return m, protowire.ParseError(n)
+			}
+			b = b[n:]
+		}
+	}
+	return m, nil
+}
+
+// sparkplugMetricInfo is the name and data type of a metric declared in a birth certificate.
+type sparkplugMetricInfo struct {
+	name     string
+	dataType uint32
+}
+
+// sparkplugTracker resolves the aliases of the metrics of Sparkplug B data messages
+// from the birth certificates of their edge node or device, and tracks the state of
+// the host applications, edge nodes and devices.
+//
+// It is safe for concurrent use.
+type sparkplugTracker struct {
+	mu sync.Mutex
+	// aliases are the metrics by alias of each edge node and device.
+	aliases map[string]map[uint64]sparkplugMetricInfo
+	// states are the states of the host applications, edge nodes and devices by key.
+	states map[string]*sparkplugEntity
+	// seen counts the messages of the entities, to find the least recently seen entity.
+	seen uint64
+}
+
+// decode decodes a Sparkplug B message received at the given time, updates the state of
+// its edge node or device, and resolves the names and data types of its metrics. Birth
+// certificates declare the aliases of an edge node or device, which are removed by its
+// death certificate.
+func (s *sparkplugTracker) decode(topic sparkplugTopic, payload []byte, received time.Time) (*sparkplugPayload, error) {
+	p, err := decodeSparkplugPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.aliases == nil {
+		s.aliases = make(map[string]map[uint64]sparkplugMetricInfo)
+	}
+	if !s.updateState(topic, p, received) {
+		// the death certificate of a previous session of the edge node
+		return p, nil
+	}
+
+	switch topic.MessageType {
+	case SparkplugNBIRTH, SparkplugNDEATH:
+		// the devices of the edge node are born again after the edge node
+		prefix := topic.nodeKey() + "/"
+		for key := range s.aliases {
+			if strings.HasPrefix(key, prefix) {
+				delete(s.aliases, key)
+			}
+		}
+		delete(s.aliases, topic.nodeKey())
+	case SparkplugDDEATH:
+		delete(s.aliases, topic.key())
+	}
+
+	switch topic.MessageType {
+	case SparkplugNBIRTH, SparkplugDBIRTH:
+		aliases := make(map[uint64]sparkplugMetricInfo)
+		for _, m := range p.Metrics {
+			if m.HasAlias && m.Name != "" {
+				aliases[m.Alias] = sparkplugMetricInfo{name: m.Name, dataType: m.DataType}
+			}
+		}
+		s.aliases[topic.key()] = aliases
+	case SparkplugNDATA, SparkplugNCMD, SparkplugDDATA, SparkplugDCMD:
+		aliases := s.aliases[topic.key()]
+		for i, m := range p.Metrics {
+			info, ok := aliases[m.Alias]
+			if !m.HasAlias || !ok {
+				continue
+			}
+			if m.Name == "" {
+				p.Metrics[i].Name = info.name
+			}
+			if m.DataType == 0 {
+				p.Metrics[i].DataType = info.dataType
+			}
+		}
+	}
+	return p, nil
+}
+
+// fieldName returns the name of the field of the metric. Metrics with an alias
+// that wasn't resolved are named after the alias.
+func (m sparkplugMetric) fieldName() string {
+	if m.Name == "" {
+		return fmt.Sprintf("alias %d", m.Alias)
+	}
+	return m.Name
+}
+
+// fieldValue converts the value of the metric to the type of its field. It returns
+// false for the data types that aren't supported, such as data sets and templates.
+func (m sparkplugMetric) fieldValue() (data.FieldType, any, bool) {
+	dataType := m.DataType
+	if dataType == 0 {
+		// without a data type, the type of the value is used
+		switch m.Value.(type) {
+		case uint32:
+			dataType = sparkplugUInt32
+		case uint64:
+			dataType = sparkplugUInt64
+		case float32:
+			dataType = sparkplugFloat
+		case float64:
+			dataType = sparkplugDouble
+		case bool:
+			dataType = sparkplugBoolean
+		case string:
+			dataType = sparkplugString
+		}
+	}
+
+	var fieldType data.FieldType
+	switch dataType {
+	case sparkplugInt8, sparkplugInt16, sparkplugInt32, sparkplugInt64:
+		fieldType = data.FieldTypeNullableInt64
+	case sparkplugUInt8, sparkplugUInt16, sparkplugUInt32, sparkplugUInt64:
+		fieldType = data.FieldTypeNullableUint64
+	case sparkplugFloat, sparkplugDouble:
+		fieldType = data.FieldTypeNullableFloat64
+	case sparkplugBoolean:
+		fieldType = data.FieldTypeNullableBool
+	case sparkplugString, sparkplugText, sparkplugUUID:
+		fieldType = data.FieldTypeNullableString
+	case sparkplugDateTime:
+		fieldType = data.FieldTypeNullableTime
+	default:
+		return data.FieldTypeUnknown, nil, false
+	}
+	if m.IsNull || m.Value == nil {
+		return fieldType, nil, true
+	}
+
+	switch v := m.Value.(type) {
+	case uint32:
+		// signed integers are encoded in the unsigned value with two's complement
+		switch dataType {
+		case sparkplugInt8:
+			return fieldType, ptr(int64(int8(v))), true
+		case sparkplugInt16:
+			return fieldType, ptr(int64(int16(v))), true
+		case sparkplugInt32, sparkplugInt64:
+			return fieldType, ptr(int64(int32(v))), true
+		case sparkplugUInt8, sparkplugUInt16, sparkplugUInt32, sparkplugUInt64:
+			return fieldType, ptr(uint64(v)), true
+		}
+	case uint64:
+		switch dataType {
+		case sparkplugInt8, sparkplugInt16, sparkplugInt32, sparkplugInt64:
+			return fieldType, ptr(int64(v)), true
+		case sparkplugUInt8, sparkplugUInt16, sparkplugUInt32, sparkplugUInt64:
+			return fieldType, ptr(v), true
+		case sparkplugDateTime:
+			return fieldType, ptr(time.UnixMilli(int64(v))), true
+		}
+	case float32:
+		if fieldType == data.FieldTypeNullableFloat64 {
+			return fieldType, ptr(float64(v)), true
+		}
+	case float64:
+		if fieldType == data.FieldTypeNullableFloat64 {
+			return fieldType, ptr(v), true
+		}
+	case bool:
+		if fieldType == data.FieldTypeNullableBool {
+			return fieldType, ptr(v), true
+		}
+	case string:
+		if fieldType == data.FieldTypeNullableString {
+			return fieldType, ptr(v), true
+		}
+	}
+	// the value doesn't match the data type
+	return fieldType, nil, true
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// sparkplugPayload returns the decoded Sparkplug B payload of the message, or nil if the
+// message isn't a Sparkplug B message or the payloads aren't decoded automatically. Other
+// payload formats, such as raw, and protobuf messages apply to Sparkplug B messages too.
+func (df *framer) sparkplugPayload(m Message) *sparkplugPayload {
+	if (df.options.PayloadFormat != "" && df.options.PayloadFormat != PayloadFormatAuto) || df.options.protoMessage != nil {
+		return nil
+	}
+	return m.sparkplugPayload()
+}
+
+// sparkplugPayload returns the decoded Sparkplug B payload of the message, or nil
+// if the message isn't a Sparkplug B message. Messages received by the client are
+// decoded when they are received, to resolve the aliases of their metrics.
+func (m Message) sparkplugPayload() *sparkplugPayload {
+	if m.sparkplug != nil {
+		return m.sparkplug
+	}
+	if _, ok := parseSparkplugTopic(m.Topic); !ok {
+		return nil
+	}
+	p, err := decodeSparkplugPayload(m.Value)
+	if err != nil {
+		return nil
+	}
+	return p
+}
+
+// addSparkplugMetrics adds the metrics of a Sparkplug B message to the fields, with a row
+// per metric timestamp. Metrics without a timestamp use the timestamp of the payload,
+// or the time the message was received if the payload has none.
+func (df *framer) addSparkplugMetrics(message Message, p *sparkplugPayload) {
+	defaultTime := message.Timestamp
+	if !p.Timestamp.IsZero() {
+		defaultTime = p.Timestamp
+	}
+
+	var times []time.Time
+	rows := make(map[time.Time][]sparkplugMetric)
+	for _, m := range p.Metrics {
+		t := defaultTime
+		if !m.Timestamp.IsZero() {
+			t = m.Timestamp
+		}
+		if _, ok := rows[t]; !ok {
+			times = append(times, t)
+		}
+		rows[t] = append(rows[t], m)
+	}
+	if len(times) == 0 {
+		// messages without metrics, such as death certificates, still get a row
+		times = append(times, defaultTime)
+	}
+
+	for _, t := range times {
+		for _, m := range rows[t] {
+			fieldType, v, ok := m.fieldValue()
+			if !ok {
+				continue
+			}
+			df.path = append(df.path[:0], m.fieldName())
+			if v == nil {
+				df.addNull(fieldType)
+				continue
+			}
+			df.addValue(fieldType, v)
+		}
+		df.path = df.path[:0]
+		df.appendMessageAt(message, t)
+	}
+}
@@ -0,0 +1,316 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Types of the Sparkplug B entities whose state is tracked.
+const (
+	SparkplugHost   = "host"
+	SparkplugNode   = "node"
+	SparkplugDevice = "device"
+)
+
+// SparkplugState is the state of a Sparkplug B host application, edge node or device,
+// as of the last birth, death or STATE message received by the client.
+type SparkplugState struct {
+	// Type is SparkplugHost, SparkplugNode or SparkplugDevice.
+	Type       string
+	GroupID    string
+	EdgeNodeID string
+	DeviceID   string
+	HostID     string
+	Online     bool
+	// LastBirth and LastDeath are zero if no birth or death was received.
+	LastBirth time.Time
+	LastDeath time.Time
+	// BdSeq is the birth/death sequence number of the session of an edge node.
+	BdSeq    uint64
+	HasBdSeq bool
+}
+
+// maxSparkplugStates limits the number of host applications, edge nodes and devices whose
+// state is tracked. The IDs of devices that come and go would otherwise be kept forever.
+const maxSparkplugStates = 10000
+
+// sparkplugEntity is the tracked state of a host application, edge node or device.
+type sparkplugEntity struct {
+	SparkplugState
+	// seen is the number of the last message of the entity.
+	seen uint64
+	// stateTopic is the topic of the last STATE message of a host application.
+	stateTopic string
+}
+
+// topic returns a topic of the entity, which is used to match it against topic filters.
+// Host applications have the topic of their last STATE message, which is
+// spBv1.0/STATE/{host_id}, or STATE/{host_id} before Sparkplug 3.0.
+func (e *sparkplugEntity) topic() string {
+	switch e.Type {
+	case SparkplugHost:
+		return e.stateTopic
+	case SparkplugDevice:
+		return strings.Join([]string{SparkplugNamespace, e.GroupID, SparkplugDBIRTH, e.EdgeNodeID, e.DeviceID}, "/")
+	}
+	return strings.Join([]string{SparkplugNamespace, e.GroupID, SparkplugNBIRTH, e.EdgeNodeID}, "/")
+}
+
+// parseSparkplugStateTopic parses the topic of the STATE message of a host application,
+// spBv1.0/STATE/{host_id}, or STATE/{host_id} before Sparkplug 3.0.
+func parseSparkplugStateTopic(topic string) (string, bool) {
+	levels := strings.Split(topic, "/")
+	switch {
+	case len(levels) == 3 && levels[0] == SparkplugNamespace && levels[1] == "STATE":
+		return levels[2], true
+	case len(levels) == 2 && levels[0] == "STATE":
+		return levels[1], true
+	}
+	return "", false
+}
+
+// updateHost updates the state of a host application from its STATE message received on the
+// topic. The payload is {"online":true,"timestamp":...} since Sparkplug 3.0, and "ONLINE" or
+// "OFFLINE" before.
+func (s *sparkplugTracker) updateHost(topic string, hostID string, payload []byte, received time.Time) {
+	var state struct {
+		Online    bool   `json:"online"`
+		Timestamp uint64 `json:"timestamp"`
+	}
+	switch string(payload) {
+	case "ONLINE":
+		state.Online = true
+	case "OFFLINE":
+	default:
+		if err := json.Unmarshal(payload, &state); err != nil {
+			return
+		}
+	}
+	t := received
+	if state.Timestamp > 0 {
+		t = time.UnixMilli(int64(state.Timestamp))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	host := s.state("STATE/"+hostID, SparkplugState{Type: SparkplugHost, HostID: hostID})
+	host.stateTopic = topic
+	host.Online = state.Online
+	if state.Online {
+		host.LastBirth = t
+	} else {
+		host.LastDeath = t
+	}
+}
+
+// state returns the state with the given key, which is initialized to the given state if missing.
+// When the number of states reaches maxSparkplugStates, the least recently seen state is removed
+// to make room for a new one. Must be called with the lock held.
+func (s *sparkplugTracker) state(key string, initial SparkplugState) *sparkplugEntity {
+	if s.states == nil {
+		s.states = make(map[string]*sparkplugEntity)
+	}
+	entity, ok := s.states[key]
+	if !ok {
+		if len(s.states) >= maxSparkplugStates {
+			s.evict()
+		}
+		entity = &sparkplugEntity{SparkplugState: initial}
+		s.states[key] = entity
+	}
+	s.seen++
+	entity.seen = s.seen
+	return entity
+}
+
+// evict removes the state, and the aliases, of the offline entity that was seen least recently,
+// or of the entity that was seen least recently if all are online. The devices of an edge node
+// are removed with it. Must be called with the lock held.
+func (s *sparkplugTracker) evict() {
+	var (
+		evicted string
+		oldest  *sparkplugEntity
+	)
+	for key, entity := range s.states {
+		if oldest == nil ||
+			(!entity.Online && oldest.Online) ||
+			(entity.Online == oldest.Online && entity.seen < oldest.seen) {
+			evicted, oldest = key, entity
+		}
+	}
+	delete(s.states, evicted)
+	delete(s.aliases, evicted)
+	if oldest.Type != SparkplugNode {
+		return
+	}
+	// the devices of an edge node are seen after it, so they are removed with it
+	prefix := evicted + "/"
+	for key := range s.states {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.states, key)
+		}
+	}
+	for key := range s.aliases {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.aliases, key)
+		}
+	}
+}
+
+// updateState updates the state of the edge node or device of a message. It returns false
+// for the death certificates of previous sessions of an edge node, which are ignored.
+// Must be called with the lock held.
+func (s *sparkplugTracker) updateState(topic sparkplugTopic, p *sparkplugPayload, received time.Time) bool {
+	t := received
+	if !p.Timestamp.IsZero() {
+		t = p.Timestamp
+	}
+	node := s.state(topic.nodeKey(), SparkplugState{Type: SparkplugNode, GroupID: topic.GroupID, EdgeNodeID: topic.EdgeNodeID})
+
+	switch topic.MessageType {
+	case SparkplugNBIRTH:
+		node.Online = true
+		node.LastBirth = t
+		node.BdSeq, node.HasBdSeq = p.bdSeq()
+		// the devices are offline until they are born again
+		s.setDevicesOffline(topic, time.Time{})
+	case SparkplugNDEATH:
+		if bdSeq, ok := p.bdSeq(); ok && node.HasBdSeq && bdSeq != node.BdSeq {
+			return false
+		}
+		node.Online = false
+		node.LastDeath = t
+		s.setDevicesOffline(topic, t)
+	case SparkplugNDATA:
+		// data from an edge node whose birth wasn't received
+		node.Online = true
+	case SparkplugDBIRTH, SparkplugDDEATH, SparkplugDDATA:
+		device := s.state(topic.key(), SparkplugState{
+			Type:       SparkplugDevice,
+			GroupID:    topic.GroupID,
+			EdgeNodeID: topic.EdgeNodeID,
+			DeviceID:   topic.DeviceID,
+		})
+		switch topic.MessageType {
+		case SparkplugDBIRTH:
+			device.Online = true
+			device.LastBirth = t
+		case SparkplugDDEATH:
+			device.Online = false
+			device.LastDeath = t
+		case SparkplugDDATA:
+			device.Online = true
+		}
+		if device.Online {
+			node.Online = true
+		}
+	}
+	return true
+}
+
+// setDevicesOffline sets the devices of the edge node of the topic offline. The time of
+// their death is only set if it isn't zero.
+func (s *sparkplugTracker) setDevicesOffline(topic sparkplugTopic, t time.Time) {
+	prefix := topic.nodeKey() + "/"
+	for key, state := range s.states {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		state.Online = false
+		if !t.IsZero() {
+			state.LastDeath = t
+		}
+	}
+}
+
+// bdSeq returns the value of the bdSeq metric of a birth or death certificate.
+func (p *sparkplugPayload) bdSeq() (uint64, bool) {
+	for _, m := range p.Metrics {
+		if m.Name != "bdSeq" {
+			continue
+		}
+		switch v := m.Value.(type) {
+		case uint64:
+			return v, true
+		case uint32:
+			return uint64(v), true
+		}
+	}
+	return 0, false
+}
+
+// matchingStates returns the states of the host applications, edge nodes and devices that match
+// the MQTT topic filter, sorted by host, group, edge node and device.
+func (s *sparkplugTracker) matchingStates(filter string) []SparkplugState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := make([]SparkplugState, 0, len(s.states))
+	for _, entity := range s.states {
+		if MatchTopic(filter, entity.topic()) {
+			states = append(states, entity.SparkplugState)
+		}
+	}
+	slices.SortFunc(states, func(a, b SparkplugState) int {
+		if (a.Type == SparkplugHost) != (b.Type == SparkplugHost) {
+			if a.Type == SparkplugHost {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(
+			strings.Join([]string{a.HostID, a.GroupID, a.EdgeNodeID, a.DeviceID}, "/"),
+			strings.Join([]string{b.HostID, b.GroupID, b.EdgeNodeID, b.DeviceID}, "/"),
+		)
+	})
+	return states
+}
+
+// SparkplugStateFrame converts the states of Sparkplug B entities to a table.
+func SparkplugStateFrame(states []SparkplugState) *data.Frame {
+	var (
+		types      = make([]string, len(states))
+		groups     = make([]string, len(states))
+		nodes      = make([]string, len(states))
+		devices    = make([]string, len(states))
+		hosts      = make([]string, len(states))
+		online     = make([]bool, len(states))
+		lastBirths = make([]*time.Time, len(states))
+		lastDeaths = make([]*time.Time, len(states))
+		bdSeqs     = make([]*uint64, len(states))
+	)
+	for i, s := range states {
+		types[i] = s.Type
+		groups[i] = s.GroupID
+		nodes[i] = s.EdgeNodeID
+		devices[i] = s.DeviceID
+		hosts[i] = s.HostID
+		online[i] = s.Online
+		if !s.LastBirth.IsZero() {
+			lastBirths[i] = ptr(s.LastBirth)
+		}
+		if !s.LastDeath.IsZero() {
+			lastDeaths[i] = ptr(s.LastDeath)
+		}
+		if s.HasBdSeq {
+			bdSeqs[i] = ptr(s.BdSeq)
+		}
+	}
+
+	return data.NewFrame("sparkplug",
+		data.NewField("Type", nil, types),
+		data.NewField("Group", nil, groups),
+		data.NewField("Edge node", nil, nodes),
+		data.NewField("Device", nil, devices),
+		data.NewField("Host", nil, hosts),
+		data.NewField("Online", nil, online),
+		data.NewField("Last birth", nil, lastBirths),
+		data.NewField("Last death", nil, lastDeaths),
+		data.NewField("bdSeq", nil, bdSeqs),
+	)
+}
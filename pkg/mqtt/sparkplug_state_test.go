@@ -0,0 +1,143 @@
+package mqtt
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/experimental"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSparkplugStateTopic(t *testing.T) {
+	hostID, ok := parseSparkplugStateTopic("spBv1.0/STATE/scada")
+	require.True(t, ok)
+	require.Equal(t, "scada", hostID)
+
+	hostID, ok = parseSparkplugStateTopic("STATE/scada")
+	require.True(t, ok)
+	require.Equal(t, "scada", hostID)
+
+	for _, topic := range []string{"spBv1.0/plant/NBIRTH/edge1", "spBv1.0/STATE", "STATE/scada/1"} {
+		_, ok := parseSparkplugStateTopic(topic)
+		require.False(t, ok, topic)
+	}
+}
+
+func TestSparkplugStates(t *testing.T) {
+	c := &client{conn: newFakeConn()}
+	received := time.Now()
+	bdSeq := func(v uint64) testMetric {
+		return testMetric{name: "bdSeq", dataType: sparkplugUInt64, value: v}
+	}
+	state := func(filter string, i int) SparkplugState {
+		t.Helper()
+		states := c.SparkplugStates(filter)
+		require.Greater(t, len(states), i)
+		return states[i]
+	}
+
+	c.HandleMessage("", "spBv1.0/plant/NBIRTH/edge1", encodeSparkplugPayload(1000, 0, bdSeq(1)))
+	c.HandleMessage("", "spBv1.0/plant/DBIRTH/edge1/pump1", encodeSparkplugPayload(2000, 1))
+	require.Equal(t, []SparkplugState{
+		{Type: SparkplugNode, GroupID: "plant", EdgeNodeID: "edge1", Online: true, LastBirth: time.UnixMilli(1000), BdSeq: 1, HasBdSeq: true},
+		{Type: SparkplugDevice, GroupID: "plant", EdgeNodeID: "edge1", DeviceID: "pump1", Online: true, LastBirth: time.UnixMilli(2000)},
+	}, c.SparkplugStates("spBv1.0/#"))
+
+	c.HandleMessage("", "spBv1.0/plant/DDEATH/edge1/pump1", encodeSparkplugPayload(3000, 2))
+	require.False(t, state("spBv1.0/plant/+/edge1/pump1", 0).Online)
+	require.True(t, state("spBv1.0/plant/+/edge1", 0).Online)
+
+	// the death certificate of a previous session is ignored
+	c.HandleMessage("", "spBv1.0/plant/NDEATH/edge1", encodeSparkplugPayload(0, 0, bdSeq(0)))
+	require.True(t, state("spBv1.0/plant/+/edge1", 0).Online)
+
+	c.HandleMessage("", "spBv1.0/plant/DDATA/edge1/pump1", encodeSparkplugPayload(4000, 3))
+	require.True(t, state("spBv1.0/plant/+/edge1/pump1", 0).Online)
+
+	// the death of the edge node is the death of its devices
+	c.HandleMessage("", "spBv1.0/plant/NDEATH/edge1", encodeSparkplugPayload(0, 0, bdSeq(1)))
+	node, device := state("spBv1.0/#", 0), state("spBv1.0/#", 1)
+	require.False(t, node.Online)
+	require.False(t, device.Online)
+	require.Equal(t, node.LastDeath, device.LastDeath)
+	require.WithinDuration(t, received, node.LastDeath, time.Minute)
+
+	// the devices are born after the edge node
+	c.HandleMessage("", "spBv1.0/plant/NBIRTH/edge1", encodeSparkplugPayload(5000, 0, bdSeq(2)))
+	require.True(t, state("spBv1.0/plant/+/edge1", 0).Online)
+	require.False(t, state("spBv1.0/plant/+/edge1/pump1", 0).Online)
+	require.Equal(t, uint64(2), state("spBv1.0/plant/+/edge1", 0).BdSeq)
+
+	require.Empty(t, c.SparkplugStates("spBv1.0/other/#"))
+}
+
+func TestSparkplugStates_Host(t *testing.T) {
+	c := &client{conn: newFakeConn()}
+
+	c.HandleMessage("", "spBv1.0/STATE/scada", []byte(`{"online":true,"timestamp":1714566615000}`))
+	c.HandleMessage("", "STATE/legacy", []byte("ONLINE"))
+	c.HandleMessage("", "spBv1.0/plant/NBIRTH/edge1", encodeSparkplugPayload(1000, 0))
+	require.Equal(t, []SparkplugState{
+		{Type: SparkplugHost, HostID: "legacy", Online: true, LastBirth: c.SparkplugStates("STATE/legacy")[0].LastBirth},
+		{Type: SparkplugHost, HostID: "scada", Online: true, LastBirth: time.UnixMilli(1714566615000)},
+		{Type: SparkplugNode, GroupID: "plant", EdgeNodeID: "edge1", Online: true, LastBirth: time.UnixMilli(1000)},
+	}, c.SparkplugStates("#"))
+
+	c.HandleMessage("", "spBv1.0/STATE/scada", []byte(`{"online":false,"timestamp":1714566616000}`))
+	c.HandleMessage("", "STATE/legacy", []byte("OFFLINE"))
+	// invalid payloads are ignored
+	c.HandleMessage("", "spBv1.0/STATE/scada", []byte("invalid"))
+	states := c.SparkplugStates("spBv1.0/STATE/+")
+	require.Len(t, states, 1)
+	require.False(t, states[0].Online)
+	require.Equal(t, time.UnixMilli(1714566616000), states[0].LastDeath)
+
+	// hosts before Sparkplug 3.0 match the filters of their own STATE topic
+	states = c.SparkplugStates("STATE/#")
+	require.Len(t, states, 1)
+	require.Equal(t, "legacy", states[0].HostID)
+	require.False(t, states[0].Online)
+}
+
+func TestSparkplugStateFrame(t *testing.T) {
+	frame := SparkplugStateFrame([]SparkplugState{
+		{Type: SparkplugHost, HostID: "scada", Online: true, LastBirth: time.UnixMilli(1714566615000)},
+		{Type: SparkplugNode, GroupID: "plant", EdgeNodeID: "edge1", LastBirth: time.UnixMilli(1714566616000), LastDeath: time.UnixMilli(1714566617000), BdSeq: 3, HasBdSeq: true},
+		{Type: SparkplugDevice, GroupID: "plant", EdgeNodeID: "edge1", DeviceID: "pump1", LastBirth: time.UnixMilli(1714566616000)},
+	})
+	experimental.CheckGoldenJSONFrame(t, "testdata", "sparkplug-state", frame, update)
+}
+
+func TestSparkplugStates_Limit(t *testing.T) {
+	c := &client{conn: newFakeConn()}
+
+	c.HandleMessage("", "spBv1.0/STATE/scada", []byte(`{"online":true}`))
+	for i := range maxSparkplugStates {
+		c.HandleMessage("", fmt.Sprintf("spBv1.0/STATE/host%d", i), []byte(`{"online":false}`))
+	}
+
+	// the offline host that was seen least recently is removed for the last host
+	states := c.SparkplugStates("spBv1.0/STATE/+")
+	require.Len(t, states, maxSparkplugStates)
+	require.Empty(t, c.SparkplugStates("spBv1.0/STATE/host0"))
+	require.Len(t, c.SparkplugStates("spBv1.0/STATE/scada"), 1)
+	require.Len(t, c.SparkplugStates("spBv1.0/STATE/host1"), 1)
+}
+
+func TestSparkplugStates_LimitNodeDevices(t *testing.T) {
+	c := &client{conn: newFakeConn()}
+	alias := uint64(1)
+
+	c.HandleMessage("", "spBv1.0/plant/NBIRTH/edge1", encodeSparkplugPayload(1000, 0))
+	c.HandleMessage("", "spBv1.0/plant/DBIRTH/edge1/pump1", encodeSparkplugPayload(2000, 1,
+		testMetric{name: "speed", alias: &alias, dataType: sparkplugDouble, value: 1.5}))
+	for i := range maxSparkplugStates - 1 {
+		c.HandleMessage("", fmt.Sprintf("spBv1.0/STATE/host%d", i), []byte(`{"online":true}`))
+	}
+
+	// the edge node was seen least recently, and its device is removed with it
+	require.Empty(t, c.SparkplugStates("spBv1.0/plant/#"))
+	require.Len(t, c.SparkplugStates("spBv1.0/STATE/+"), maxSparkplugStates-1)
+	require.NotContains(t, c.sparkplug.aliases, "plant/edge1/pump1")
+}
@@ -0,0 +1,270 @@
+package mqtt
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// testMetric is a metric encoded by encodeSparkplugPayload.
+type testMetric struct {
+	name      string
+	alias     *uint64
+	timestamp uint64
+	dataType  uint32
+	isNull    bool
+	value     any
+}
+
+// encodeSparkplugPayload encodes a Sparkplug B payload with the given metrics.
+func encodeSparkplugPayload(timestamp uint64, seq uint64, metrics ...testMetric) []byte {
+	var b []byte
+	if timestamp > 0 {
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, timestamp)
+	}
+	for _, m := range metrics {
+		var mb []byte
+		if m.name != "" {
+			mb = protowire.AppendTag(mb, 1, protowire.BytesType)
+			mb = protowire.AppendString(mb, m.name)
+		}
+		if m.alias != nil {
+			mb = protowire.AppendTag(mb, 2, protowire.VarintType)
+			mb = protowire.AppendVarint(mb, *m.alias)
+		}
+		if m.timestamp > 0 {
+			mb = protowire.AppendTag(mb, 3, protowire.VarintType)
+			mb = protowire.AppendVarint(mb, m.timestamp)
+		}
+		if m.dataType > 0 {
+			mb = protowire.AppendTag(mb, 4, protowire.VarintType)
+			mb = protowire.AppendVarint(mb, uint64(m.dataType))
+		}
+		if m.isNull {
+			mb = protowire.AppendTag(mb, 7, protowire.VarintType)
+			mb = protowire.AppendVarint(mb, 1)
+		}
+		switch v := m.value.(type) {
+		case uint32:
+			mb = protowire.AppendTag(mb, 10, protowire.VarintType)
+			mb = protowire.AppendVarint(mb, uint64(v))
+		case uint64:
+			mb = protowire.AppendTag(mb, 11, protowire.VarintType)
+			mb = protowire.AppendVarint(mb, v)
+		case float32:
+			mb = protowire.AppendTag(mb, 12, protowire.Fixed32Type)
+			mb = protowire.AppendFixed32(mb, math.Float32bits(v))
+		case float64:
+			mb = protowire.AppendTag(mb, 13, protowire.Fixed64Type)
+			mb = protowire.AppendFixed64(mb, math.Float64bits(v))
+		case bool:
+			mb = protowire.AppendTag(mb, 14, protowire.VarintType)
+			mb = protowire.AppendVarint(mb, protowire.EncodeBool(v))
+		case string:
+			mb = protowire.AppendTag(mb, 15, protowire.BytesType)
+			mb = protowire.AppendString(mb, v)
+		}
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, mb)
+	}
+	b = protowire.AppendTag(b, 3, protowire.VarintType)
+	b = protowire.AppendVarint(b, seq)
+	return b
+}
+
+func TestParseSparkplugTopic(t *testing.T) {
+	topic, ok := parseSparkplugTopic("spBv1.0/plant/NDATA/edge1")
+	require.True(t, ok)
+	require.Equal(t, sparkplugTopic{GroupID: "plant", MessageType: SparkplugNDATA, EdgeNodeID: "edge1"}, topic)
+
+	topic, ok = parseSparkplugTopic("spBv1.0/plant/DBIRTH/edge1/pump1")
+	require.True(t, ok)
+	require.Equal(t, "plant/edge1/pump1", topic.key())
+	require.Equal(t, "plant/edge1", topic.nodeKey())
+
+	for _, topic := range []string{
+		"spBv1.0/STATE/scada",
+		"spBv1.0/plant/NDATA/edge1/pump1",
+		"spBv1.0/plant/DDATA/edge1",
+		"spBv1.0/plant/UNKNOWN/edge1",
+		"plant/NDATA/edge1/pump1",
+	} {
+		_, ok := parseSparkplugTopic(topic)
+		require.False(t, ok, topic)
+	}
+}
+
+func TestDecodeSparkplugPayload(t *testing.T) {
+	alias := uint64(7)
+	p, err := decodeSparkplugPayload(encodeSparkplugPayload(1714566615000, 3,
+		testMetric{name: "temperature", alias: &alias, timestamp: 1714566616000, dataType: sparkplugDouble, value: 21.5},
+		testMetric{name: "running", dataType: sparkplugBoolean, value: true},
+		testMetric{name: "level", dataType: sparkplugInt16, isNull: true},
+	))
+	require.NoError(t, err)
+
+	require.Equal(t, time.UnixMilli(1714566615000), p.Timestamp)
+	require.Equal(t, uint64(3), p.Seq)
+	require.Equal(t, []sparkplugMetric{
+		{Name: "temperature", Alias: 7, HasAlias: true, Timestamp: time.UnixMilli(1714566616000), DataType: sparkplugDouble, Value: 21.5},
+		{Name: "running", DataType: sparkplugBoolean, Value: true},
+		{Name: "level", DataType: sparkplugInt16, IsNull: true},
+	}, p.Metrics)
+
+	_, err = decodeSparkplugPayload([]byte("not protobuf"))
+	require.Error(t, err)
+}
+
+func TestSparkplugMetric_fieldValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		metric   sparkplugMetric
+		expected any
+	}{
+		{name: "int8", metric: sparkplugMetric{DataType: sparkplugInt8, Value: uint32(0xff)}, expected: int64(-1)},
+		{name: "int32", metric: sparkplugMetric{DataType: sparkplugInt32, Value: uint32(0xfffffffe)}, expected: int64(-2)},
+		{name: "int64", metric: sparkplugMetric{DataType: sparkplugInt64, Value: uint64(math.MaxUint64)}, expected: int64(-1)},
+		{name: "uint16", metric: sparkplugMetric{DataType: sparkplugUInt16, Value: uint32(65535)}, expected: uint64(65535)},
+		{name: "float", metric: sparkplugMetric{DataType: sparkplugFloat, Value: float32(1.5)}, expected: 1.5},
+		{name: "datetime", metric: sparkplugMetric{DataType: sparkplugDateTime, Value: uint64(1714566615000)}, expected: time.UnixMilli(1714566615000)},
+		{name: "text", metric: sparkplugMetric{DataType: sparkplugText, Value: "on"}, expected: "on"},
+		{name: "without data type", metric: sparkplugMetric{Value: 21.5}, expected: 21.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, v, ok := tt.metric.fieldValue()
+			require.True(t, ok)
+			require.NotNil(t, v)
+			require.Equal(t, tt.expected, derefValue(v))
+		})
+	}
+
+	_, v, ok := sparkplugMetric{DataType: sparkplugInt32, IsNull: true}.fieldValue()
+	require.True(t, ok)
+	require.Nil(t, v)
+
+	// data sets aren't supported
+	_, _, ok = sparkplugMetric{DataType: 16}.fieldValue()
+	require.False(t, ok)
+}
+
+func derefValue(v any) any {
+	switch p := v.(type) {
+	case *int64:
+		return *p
+	case *uint64:
+		return *p
+	case *float64:
+		return *p
+	case *bool:
+		return *p
+	case *string:
+		return *p
+	case *time.Time:
+		return *p
+	}
+	return v
+}
+
+func TestSparkplugAliases(t *testing.T) {
+	var s sparkplugTracker
+	temperature, pressure := uint64(1), uint64(2)
+
+	decode := func(topic string, payload []byte) *sparkplugPayload {
+		t.Helper()
+		st, ok := parseSparkplugTopic(topic)
+		require.True(t, ok)
+		p, err := s.decode(st, payload, time.Now())
+		require.NoError(t, err)
+		return p
+	}
+	names := func(p *sparkplugPayload) []string {
+		var names []string
+		for _, m := range p.Metrics {
+			names = append(names, m.fieldName())
+		}
+		return names
+	}
+
+	decode("spBv1.0/plant/NBIRTH/edge1", encodeSparkplugPayload(1, 0,
+		testMetric{name: "temperature", alias: &temperature, dataType: sparkplugDouble, value: 20.0},
+	))
+	decode("spBv1.0/plant/DBIRTH/edge1/pump1", encodeSparkplugPayload(1, 1,
+		testMetric{name: "pressure", alias: &temperature, dataType: sparkplugFloat, value: float32(1)},
+	))
+
+	p := decode("spBv1.0/plant/NDATA/edge1", encodeSparkplugPayload(2, 2,
+		testMetric{alias: &temperature, value: 21.5},
+		testMetric{alias: &pressure, value: 1.0},
+	))
+	require.Equal(t, []string{"temperature", "alias 2"}, names(p))
+	require.Equal(t, uint32(sparkplugDouble), p.Metrics[0].DataType)
+
+	// the aliases are scoped to the edge node or device
+	p = decode("spBv1.0/plant/DDATA/edge1/pump1", encodeSparkplugPayload(2, 3,
+		testMetric{alias: &temperature, value: float32(1.5)},
+	))
+	require.Equal(t, []string{"pressure"}, names(p))
+
+	// the death of the edge node removes the aliases of its devices
+	decode("spBv1.0/plant/NDEATH/edge1", encodeSparkplugPayload(0, 0))
+	p = decode("spBv1.0/plant/DDATA/edge1/pump1", encodeSparkplugPayload(3, 4,
+		testMetric{alias: &temperature, value: float32(1.5)},
+	))
+	require.Equal(t, []string{"alias 1"}, names(p))
+}
+
+func Test_framer_Sparkplug(t *testing.T) {
+	client := &client{conn: newFakeConn()}
+	// "c3BCdjEuMC9wbGFudC8rL2VkZ2UxLyM" is the encoded "spBv1.0/plant/+/edge1/#"
+	topic, err := client.Subscribe("1s/c3BCdjEuMC9wbGFudC8rL2VkZ2UxLyM", 0, log.DefaultLogger)
+	require.NoError(t, err)
+
+	temperature, running, status := uint64(1), uint64(2), uint64(3)
+	client.HandleMessage(topic.Path, "spBv1.0/plant/NBIRTH/edge1", encodeSparkplugPayload(1714566615000, 0,
+		testMetric{name: "temperature", alias: &temperature, dataType: sparkplugDouble, value: 20.5},
+		testMetric{name: "running", alias: &running, dataType: sparkplugBoolean, value: true},
+		testMetric{name: "status", alias: &status, dataType: sparkplugString, value: "starting"},
+	))
+	client.HandleMessage(topic.Path, "spBv1.0/plant/NDATA/edge1", encodeSparkplugPayload(1714566616000, 1,
+		testMetric{alias: &temperature, value: 21.5},
+		testMetric{alias: &status, isNull: true},
+	))
+	// historical metrics have their own timestamps
+	client.HandleMessage(topic.Path, "spBv1.0/plant/NDATA/edge1", encodeSparkplugPayload(1714566619000, 2,
+		testMetric{alias: &temperature, timestamp: 1714566617000, value: 22.5},
+		testMetric{alias: &temperature, timestamp: 1714566618000, value: 23.5},
+		testMetric{alias: &running, value: false},
+	))
+
+	frame, err := topic.ToDataFrame(log.DefaultLogger)
+	require.NoError(t, err)
+	experimental.CheckGoldenJSONFrame(t, "testdata", "sparkplug", frame, update)
+}
+
+func Test_framer_Sparkplug_PayloadFormat(t *testing.T) {
+	client := &client{conn: newFakeConn()}
+	// "c3BCdjEuMC9wbGFudC8rL2VkZ2UxLyM" is the encoded "spBv1.0/plant/+/edge1/#"
+	topic, err := client.Subscribe("1s/c3BCdjEuMC9wbGFudC8rL2VkZ2UxLyM", 0, log.DefaultLogger)
+	require.NoError(t, err)
+	topic.PayloadFormat = PayloadFormatRaw
+
+	payload := encodeSparkplugPayload(1714566615000, 0, testMetric{name: "temperature", dataType: sparkplugDouble, value: 20.5})
+	client.HandleMessage(topic.Path, "spBv1.0/plant/NBIRTH/edge1", payload)
+
+	// the payload isn't decoded as Sparkplug B with another payload format
+	frame, err := topic.ToDataFrame(log.DefaultLogger)
+	require.NoError(t, err)
+	require.Len(t, frame.Fields, 3)
+	require.Equal(t, "Topic", frame.Fields[1].Name)
+	require.Equal(t, string(payload), *frame.Fields[2].At(0).(*string))
+
+	// the state is still tracked
+	require.Len(t, client.SparkplugStates("spBv1.0/#"), 1)
+}
@@ -0,0 +1,209 @@
+package mqtt
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// topicFrame is the frame of a single topic received on a subscription with wildcards.
+type topicFrame struct {
+	labels data.Labels
+	frame  *data.Frame
+}
+
+// splitByTopic returns the decoded topic filter, and whether the messages
+// of the topic should be split into one frame per topic.
+func (t *Topic) splitByTopic(logger log.Logger) (string, bool) {
+	if !t.SplitByTopic {
+		return "", false
+	}
+	filter, err := decodeTopic(t.Path, logger)
+	if err != nil || !hasWildcards(filter) {
+		return "", false
+	}
+	return filter, true
+}
+
+// toTopicFrames converts the messages to a frame per topic, sorted by topic.
+// Every topic received since the subscription started gets a frame, even if it
+// has no messages, so the set of frames only grows.
+func (t *Topic) toTopicFrames(filter string, messages []Message, logger log.Logger) ([]topicFrame, error) {
+	if t.topicFramers == nil {
+		t.topicFramers = make(map[string]*framer)
+	}
+
+	topicMessages := make(map[string][]Message)
+	for _, message := range messages {
+		if _, ok := t.topicFramers[message.Topic]; !ok {
+			t.topicFramers[message.Topic] = newFramer(t.FrameOptions)
+		}
+		topicMessages[message.Topic] = append(topicMessages[message.Topic], message)
+	}
+
+	topics := make([]string, 0, len(t.topicFramers))
+	for topic := range t.topicFramers {
+		topics = append(topics, topic)
+	}
+	slices.Sort(topics)
+
+	frames := make([]topicFrame, 0, len(topics))
+	for _, topic := range topics {
+		frame, err := t.topicFramers[topic].toFrame(topicMessages[topic], logger)
+		if err != nil {
+			return nil, err
+		}
+
+		labels := topicLabels(filter, topic, t.WildcardLabels)
+		frame.Name = topic
+		for _, field := range frame.Fields[1:] {
+			field.Labels = mergeLabels(field.Labels, labels)
+		}
+		frames = append(frames, topicFrame{labels: labels, frame: frame})
+	}
+
+	return frames, nil
+}
+
+// topicLabels returns the labels for the levels of the topic that are matched
+// by the wildcards of the topic filter. A multi-level wildcard matches the
+// remaining levels of the topic, joined by "/".
+func topicLabels(filter string, topic string, names []string) data.Labels {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	labels := data.Labels{}
+	wildcard := 0
+	for i, level := range filterLevels {
+		if level != "+" && level != "#" {
+			continue
+		}
+
+		name := fmt.Sprintf("wildcard%d", wildcard+1)
+		if wildcard < len(names) && names[wildcard] != "" {
+			name = names[wildcard]
+		}
+		wildcard++
+
+		switch {
+		case i >= len(topicLevels):
+			// "a/#" also matches the parent level "a"
+			labels[name] = ""
+		case level == "#":
+			labels[name] = strings.Join(topicLevels[i:], "/")
+		default:
+			labels[name] = topicLevels[i]
+		}
+	}
+
+	return labels
+}
+
+// mergeLabels returns the labels of a field, such as the tags of line protocol payloads,
+// with the labels of its topic added. The labels of the topic replace the labels of the
+// field with the same name.
+func mergeLabels(fieldLabels data.Labels, topicLabels data.Labels) data.Labels {
+	labels := make(data.Labels, len(fieldLabels)+len(topicLabels))
+	maps.Copy(labels, fieldLabels)
+	maps.Copy(labels, topicLabels)
+	return labels
+}
+
+// labelsGroup are the fields of a topic frame with the same labels.
+type labelsGroup struct {
+	labels string
+	// fields are the indexes of the fields in the topic frame,
+	// and columns their indexes in the combined frame.
+	fields  []int
+	columns []int
+}
+
+// toLabelsColumnFrame combines the frames of each topic into a single frame,
+// with the labels of each row in the first field.
+//
+// The fields of a topic can have different labels, such as the fields of line protocol
+// payloads with different tags. The values of each label set are written to their own row,
+// so the fields are combined by name and type and the labels of each row stay in its first field.
+func toLabelsColumnFrame(frames []topicFrame) *data.Frame {
+	labelsField := data.NewFieldFromFieldType(data.FieldTypeString, 0)
+	labelsField.Name = "labels"
+	timeField := data.NewFieldFromFieldType(data.FieldTypeTime, 0)
+	timeField.Name = "Time"
+
+	fields := []*data.Field{labelsField, timeField}
+	fieldMap := make(map[string]int)
+
+	for _, tf := range frames {
+		var groups []*labelsGroup
+		groupMap := make(map[string]*labelsGroup)
+
+		// the fields are matched by name and type, as the same field
+		// can have a different type in the payload of another topic.
+		for i, field := range tf.frame.Fields[1:] {
+			key := field.Name + "/" + field.Type().ItemTypeString()
+			idx, ok := fieldMap[key]
+			if !ok {
+				f := data.NewFieldFromFieldType(field.Type(), labelsField.Len())
+				f.Name = field.Name
+				fields = append(fields, f)
+				idx = len(fields) - 1
+				fieldMap[key] = idx
+			}
+
+			labels := field.Labels.String()
+			g, ok := groupMap[labels]
+			if !ok {
+				g = &labelsGroup{labels: labels}
+				groupMap[labels] = g
+				groups = append(groups, g)
+			}
+			g.fields = append(g.fields, i+1)
+			g.columns = append(g.columns, idx)
+		}
+		if len(groups) == 0 {
+			groups = append(groups, &labelsGroup{labels: tf.labels.String()})
+		}
+
+		for row := 0; row < tf.frame.Rows(); row++ {
+			for _, g := range groups {
+				// rows are only written for the label sets with values,
+				// unless all the fields of the topic have the same labels.
+				if len(groups) > 1 && !g.hasValues(tf.frame, row) {
+					continue
+				}
+				labelsField.Append(g.labels)
+				timeField.Append(tf.frame.Fields[0].At(row))
+				for i, field := range g.fields {
+					fields[g.columns[i]].Append(tf.frame.Fields[field].At(row))
+				}
+				for _, f := range fields {
+					if f.Len() < labelsField.Len() {
+						f.Extend(labelsField.Len() - f.Len())
+					}
+				}
+			}
+		}
+	}
+
+	frame := data.NewFrame("mqtt", fields...)
+	for _, tf := range frames {
+		if tf.frame.Meta != nil {
+			frame.AppendNotices(tf.frame.Meta.Notices...)
+		}
+	}
+	return frame
+}
+
+// hasValues reports whether any field of the group has a value in the row of the frame.
+func (g *labelsGroup) hasValues(frame *data.Frame, row int) bool {
+	for _, field := range g.fields {
+		if v, ok := frame.Fields[field].ConcreteAt(row); ok && v != nil {
+			return true
+		}
+	}
+	return false
+}
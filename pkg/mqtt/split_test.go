@@ -0,0 +1,157 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/experimental"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopicLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   string
+		topic    string
+		names    []string
+		expected data.Labels
+	}{
+		{
+			name:     "single level wildcard",
+			filter:   "plant/+/power",
+			topic:    "plant/abc/power",
+			expected: data.Labels{"wildcard1": "abc"},
+		},
+		{
+			name:     "named wildcards",
+			filter:   "plant/+/+/power",
+			topic:    "plant/line1/abc/power",
+			names:    []string{"line", "device"},
+			expected: data.Labels{"line": "line1", "device": "abc"},
+		},
+		{
+			name:     "partially named wildcards",
+			filter:   "plant/+/+/power",
+			topic:    "plant/line1/abc/power",
+			names:    []string{"", "device"},
+			expected: data.Labels{"wildcard1": "line1", "device": "abc"},
+		},
+		{
+			name:     "multi level wildcard",
+			filter:   "plant/+/#",
+			topic:    "plant/abc/power/phase1",
+			names:    []string{"device", "metric"},
+			expected: data.Labels{"device": "abc", "metric": "power/phase1"},
+		},
+		{
+			name:     "multi level wildcard matching the parent level",
+			filter:   "plant/#",
+			topic:    "plant",
+			expected: data.Labels{"wildcard1": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, topicLabels(tt.filter, tt.topic, tt.names))
+		})
+	}
+}
+
+func TestTopic_MessagesToDataFrames_SplitByTopic(t *testing.T) {
+	timestamp := time.Unix(0, 0)
+	messages := []Message{
+		{Timestamp: timestamp, Topic: "plant/b/power", Value: toJSON(map[string]any{"value": 1.5})},
+		{Timestamp: timestamp.Add(time.Minute), Topic: "plant/a/power", Value: toJSON(map[string]any{"value": 2})},
+		{Timestamp: timestamp.Add(2 * time.Minute), Topic: "plant/b/power", Value: toJSON(map[string]any{"value": 3.5})},
+	}
+
+	// "cGxhbnQvKy9wb3dlcg" is the encoded "plant/+/power"
+	topic := &Topic{
+		Path:         "cGxhbnQvKy9wb3dlcg",
+		Interval:     time.Second,
+		FrameOptions: FrameOptions{SplitByTopic: true, WildcardLabels: []string{"device"}},
+	}
+
+	t.Run("one frame per topic", func(t *testing.T) {
+		frames, err := topic.MessagesToDataFrames(messages, log.DefaultLogger)
+		require.NoError(t, err)
+		require.Len(t, frames, 2)
+
+		require.Equal(t, "plant/a/power", frames[0].Name)
+		require.Equal(t, 1, frames[0].Rows())
+		require.Equal(t, data.Labels{"device": "a"}, frames[0].Fields[1].Labels)
+
+		require.Equal(t, "plant/b/power", frames[1].Name)
+		require.Equal(t, 2, frames[1].Rows())
+		require.Equal(t, data.Labels{"device": "b"}, frames[1].Fields[1].Labels)
+	})
+
+	t.Run("topics without messages keep their frame", func(t *testing.T) {
+		frames, err := topic.MessagesToDataFrames(nil, log.DefaultLogger)
+		require.NoError(t, err)
+		require.Len(t, frames, 2)
+		require.Equal(t, 0, frames[0].Rows())
+		require.Equal(t, 0, frames[1].Rows())
+	})
+
+	t.Run("subscription without wildcards", func(t *testing.T) {
+		// "cGxhbnQvYS9wb3dlcg" is the encoded "plant/a/power"
+		topic := &Topic{
+			Path:         "cGxhbnQvYS9wb3dlcg",
+			Interval:     time.Second,
+			FrameOptions: FrameOptions{SplitByTopic: true},
+		}
+		frames, err := topic.MessagesToDataFrames(messages[1:2], log.DefaultLogger)
+		require.NoError(t, err)
+		require.Len(t, frames, 1)
+		require.Equal(t, "mqtt", frames[0].Name)
+	})
+}
+
+func TestTopic_ToDataFrame_SplitByTopic(t *testing.T) {
+	timestamp := time.Unix(0, 0)
+	// "cGxhbnQvKy9wb3dlcg" is the encoded "plant/+/power"
+	topic := withMessages(&Topic{
+		Path:         "cGxhbnQvKy9wb3dlcg",
+		Interval:     time.Second,
+		FrameOptions: FrameOptions{SplitByTopic: true, WildcardLabels: []string{"device"}},
+	},
+		Message{Timestamp: timestamp, Topic: "plant/b/power", Value: toJSON(map[string]any{"value": 1.5})},
+		Message{Timestamp: timestamp.Add(time.Minute), Topic: "plant/a/power", Value: toJSON(map[string]any{"value": 2, "state": "on"})},
+		Message{Timestamp: timestamp.Add(2 * time.Minute), Topic: "plant/b/power", Value: toJSON(map[string]any{"value": 3.5})},
+	)
+
+	frame, err := topic.ToDataFrame(log.DefaultLogger)
+	require.NoError(t, err)
+	experimental.CheckGoldenJSONFrame(t, "testdata", "split-by-topic", frame, update)
+}
+
+func TestTopic_SplitByTopic_LineProtocol(t *testing.T) {
+	timestamp := time.Unix(0, 0)
+	messages := []Message{
+		{Timestamp: timestamp, Topic: "plant/a/metrics", Value: []byte("cpu,host=x usage=12.5\ncpu,host=y usage=40")},
+		{Timestamp: timestamp.Add(time.Minute), Topic: "plant/b/metrics", Value: []byte("cpu,host=x usage=13.5,cores=4i")},
+	}
+
+	// "cGxhbnQvKy9tZXRyaWNz" is the encoded "plant/+/metrics"
+	options := FrameOptions{SplitByTopic: true, WildcardLabels: []string{"line"}, PayloadFormat: PayloadFormatInflux}
+
+	t.Run("frames keep the tags", func(t *testing.T) {
+		topic := &Topic{Path: "cGxhbnQvKy9tZXRyaWNz", Interval: time.Second, FrameOptions: options}
+		frames, err := topic.MessagesToDataFrames(messages, log.DefaultLogger)
+		require.NoError(t, err)
+		require.Len(t, frames, 2)
+		require.Equal(t, data.Labels{"line": "a", "host": "x"}, frames[0].Fields[1].Labels)
+		require.Equal(t, data.Labels{"line": "a", "host": "y"}, frames[0].Fields[2].Labels)
+	})
+
+	t.Run("labels column", func(t *testing.T) {
+		topic := withMessages(&Topic{Path: "cGxhbnQvKy9tZXRyaWNz", Interval: time.Second, FrameOptions: options}, messages...)
+		frame, err := topic.ToDataFrame(log.DefaultLogger)
+		require.NoError(t, err)
+		experimental.CheckGoldenJSONFrame(t, "testdata", "split-by-topic-line-protocol", frame, update)
+	})
+}
@@ -0,0 +1,122 @@
+package mqtt
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Formats of the payload field the time of the messages is read from.
+// Any other format is a Go time layout, such as "2006-01-02 15:04:05".
+const (
+	TimeFormatRFC3339           = "rfc3339"
+	TimeFormatEpochSeconds      = "epoch_s"
+	TimeFormatEpochMilliseconds = "epoch_ms"
+	TimeFormatEpochMicroseconds = "epoch_us"
+	TimeFormatEpochNanoseconds  = "epoch_ns"
+)
+
+// receivedFieldName is the name of the field with the time the messages were
+// received, when the time of the messages is read from their payload.
+const receivedFieldName = "Received"
+
+// parseTime parses a value of a payload field in the given format.
+// Epoch timestamps can be numbers, such as the integers of binary payloads, or numeric strings.
+func parseTime(v any, format string) (time.Time, error) {
+	switch format {
+	case "", TimeFormatRFC3339:
+		s, ok := v.(string)
+		if !ok {
+			return time.Time{}, fmt.Errorf("expected an RFC 3339 string, got %v", v)
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	case TimeFormatEpochSeconds, TimeFormatEpochMilliseconds, TimeFormatEpochMicroseconds, TimeFormatEpochNanoseconds:
+		var epoch float64
+		switch value := v.(type) {
+		case float64:
+			epoch = value
+		case int64:
+			if format == TimeFormatEpochNanoseconds {
+				return time.Unix(0, value), nil
+			}
+			epoch = float64(value)
+		case uint64:
+			epoch = float64(value)
+		case string:
+			// integer strings keep the precision of nanosecond timestamps,
+			// which numbers lose above 2^53
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil && format == TimeFormatEpochNanoseconds {
+				return time.Unix(0, n), nil
+			}
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			epoch = f
+		default:
+			return time.Time{}, fmt.Errorf("expected an epoch timestamp, got %v", v)
+		}
+		return epochTime(epoch, format), nil
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("expected a string in the layout %q, got %v", format, v)
+	}
+	return time.Parse(format, s)
+}
+
+// epochTime converts an epoch timestamp in the unit of the format to a time.
+func epochTime(epoch float64, format string) time.Time {
+	switch format {
+	case TimeFormatEpochMilliseconds:
+		epoch /= 1e3
+	case TimeFormatEpochMicroseconds:
+		epoch /= 1e6
+	case TimeFormatEpochNanoseconds:
+		return time.Unix(0, int64(epoch))
+	}
+	sec, frac := math.Modf(epoch)
+	return time.Unix(int64(sec), int64(math.Round(frac*1e9)))
+}
+
+// addReceivedField adds a field with the time each message was received.
+func (df *framer) addReceivedField() {
+	df.receivedField = data.NewFieldFromFieldType(data.FieldTypeTime, 0)
+	df.receivedField.Name = receivedFieldName
+	df.fields = append(df.fields, df.receivedField)
+}
+
+// messageTime returns the time of the message in the current row. This is the time in
+// the time field of the payload, or the time the message was received if the payload
+// has no valid time.
+func (df *framer) messageTime(message Message) time.Time {
+	if df.options.TimeField == "" {
+		return message.Timestamp
+	}
+	idx, ok := df.fieldMap[df.options.TimeField]
+	if !ok {
+		return message.Timestamp
+	}
+	// the field only has a value in the current row if it was added for this message
+	row := df.fields[0].Len()
+	field := df.fields[idx]
+	if field.Len() <= row {
+		return message.Timestamp
+	}
+	v, ok := field.ConcreteAt(row)
+	if !ok {
+		return message.Timestamp
+	}
+	if t, ok := v.(time.Time); ok {
+		return t
+	}
+	t, err := parseTime(v, df.options.TimeFormat)
+	if err != nil {
+		return message.Timestamp
+	}
+	return t
+}
@@ -0,0 +1,102 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTime(t *testing.T) {
+	expected := time.Date(2024, 5, 1, 12, 30, 15, 250000000, time.UTC)
+
+	tests := []struct {
+		name   string
+		value  any
+		format string
+	}{
+		{name: "default", value: "2024-05-01T12:30:15.25Z"},
+		{name: "rfc3339 with offset", value: "2024-05-01T14:30:15.25+02:00", format: TimeFormatRFC3339},
+		{name: "epoch seconds", value: 1714566615.25, format: TimeFormatEpochSeconds},
+		{name: "epoch seconds string", value: "1714566615.25", format: TimeFormatEpochSeconds},
+		{name: "epoch milliseconds", value: 1714566615250.0, format: TimeFormatEpochMilliseconds},
+		{name: "epoch microseconds", value: 1714566615250000.0, format: TimeFormatEpochMicroseconds},
+		{name: "epoch nanoseconds", value: "1714566615250000000", format: TimeFormatEpochNanoseconds},
+		{name: "epoch nanoseconds integer", value: int64(1714566615250000000), format: TimeFormatEpochNanoseconds},
+		{name: "epoch milliseconds unsigned integer", value: uint64(1714566615250), format: TimeFormatEpochMilliseconds},
+		{name: "layout", value: "2024-05-01 12:30:15.250", format: "2006-01-02 15:04:05.000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := parseTime(tt.value, tt.format)
+			require.NoError(t, err)
+			require.True(t, expected.Equal(actual), "expected %s, got %s", expected, actual)
+		})
+	}
+
+	_, err := parseTime(1714566615.0, TimeFormatRFC3339)
+	require.EqualError(t, err, "expected an RFC 3339 string, got 1.714566615e+09")
+	_, err = parseTime("yesterday", TimeFormatEpochSeconds)
+	require.Error(t, err)
+	_, err = parseTime(true, "2006-01-02")
+	require.EqualError(t, err, `expected a string in the layout "2006-01-02", got true`)
+}
+
+func Test_framer_TimeField(t *testing.T) {
+	received := time.Unix(1714570000, 0)
+	messages := []Message{
+		{Timestamp: received, Value: []byte(`{"meta":{"ts":1714566615000},"value":1}`)},
+		// no time in the payload
+		{Timestamp: received.Add(time.Second), Value: []byte(`{"value":2}`)},
+		// invalid time in the payload
+		{Timestamp: received.Add(2 * time.Second), Value: []byte(`{"meta":{"ts":"now"},"value":3}`)},
+		{Timestamp: received.Add(3 * time.Second), Value: []byte(`{"meta":{"ts":1714566616000},"value":4}`)},
+	}
+
+	f := newFramer(FrameOptions{TimeField: "meta.ts", TimeFormat: TimeFormatEpochMilliseconds})
+	frame, err := f.toFrame(messages, log.DefaultLogger)
+	require.NoError(t, err)
+
+	require.Equal(t, "Time", frame.Fields[0].Name)
+	require.Equal(t, []time.Time{
+		time.Unix(1714566615, 0),
+		received.Add(time.Second),
+		received.Add(2 * time.Second),
+		time.Unix(1714566616, 0),
+	}, timeValues(t, frame.Fields[0].At))
+
+	require.Equal(t, "Received", frame.Fields[1].Name)
+	require.Equal(t, []time.Time{
+		received,
+		received.Add(time.Second),
+		received.Add(2 * time.Second),
+		received.Add(3 * time.Second),
+	}, timeValues(t, frame.Fields[1].At))
+}
+
+func Test_framer_TimeField_JSONPaths(t *testing.T) {
+	received := time.Unix(1714570000, 0)
+	f := newFramer(FrameOptions{
+		JSONPaths:  []JSONPath{{Path: "$.ts", Alias: "timestamp"}, {Path: "$.value", Alias: "value"}},
+		TimeField:  "timestamp",
+		TimeFormat: "2006-01-02 15:04:05",
+	})
+	frame, err := f.toFrame([]Message{
+		{Timestamp: received, Value: []byte(`{"ts":"2024-05-01 12:30:15","value":1}`)},
+	}, log.DefaultLogger)
+	require.NoError(t, err)
+
+	require.Equal(t, time.Date(2024, 5, 1, 12, 30, 15, 0, time.UTC), frame.Fields[0].At(0))
+	require.Equal(t, received, frame.Fields[1].At(0))
+}
+
+// timeValues returns the 4 values of a time field.
+func timeValues(t *testing.T, at func(int) any) []time.Time {
+	t.Helper()
+	values := make([]time.Time, 4)
+	for i := range values {
+		values[i] = at(i).(time.Time)
+	}
+	return values
+}
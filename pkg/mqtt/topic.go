@@ -2,27 +2,127 @@ package mqtt
 
 import (
 	"encoding/base64"
+	"fmt"
 	"path"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 type Message struct {
 	Timestamp time.Time
-	Value     []byte
+	// Topic is the topic the message was published to, which may differ
+	// from the subscribed topic when it contains wildcards.
+	Topic string
+	Value []byte
+	// sparkplug is the decoded payload of Sparkplug B messages, with the
+	// aliases of the metrics resolved when the message was received.
+	sparkplug *sparkplugPayload
 }
 
 // Topic represents a MQTT topic.
 type Topic struct {
 	Path         string `json:"topic"`
 	StreamingKey string `json:"streamingKey,omitempty"`
-	Interval     time.Duration
-	Messages     []Message
-	framer       *framer
+	// QoS is the MQTT quality of service level (0, 1 or 2) requested for the subscription.
+	QoS byte `json:"qos,omitempty"`
+	FrameOptions
+	Interval time.Duration
+	// messages buffers the messages received since the last frame.
+	messages *messageBuffer
+	framer   *framer
+	// topicFramers are the framers of each topic received when splitting by topic.
+	topicFramers map[string]*framer
+}
+
+// FrameOptions are the query options that control how the messages
+// of a topic are converted to data frames.
+type FrameOptions struct {
+	// SplitByTopic converts the messages of a subscription with wildcards
+	// to one frame per topic instead of a single frame.
+	SplitByTopic bool `json:"splitByTopic,omitempty"`
+	// WildcardLabels are the label names for the topic levels matched by the
+	// wildcards when splitting by topic. Unnamed levels are labeled "wildcard<n>".
+	WildcardLabels []string `json:"wildcardLabels,omitempty"`
+	// JSONSeparator joins the keys of nested JSON objects to field names,
+	// so {"env":{"temp":21}} is converted to the field "env.temp". Defaults to ".".
+	JSONSeparator string `json:"jsonSeparator,omitempty"`
+	// JSONMaxDepth is the number of levels of nested JSON objects converted to
+	// fields. Deeper objects are converted to JSON fields. Zero converts all levels.
+	JSONMaxDepth int `json:"jsonMaxDepth,omitempty"`
+	// JSONPaths extract only the selected values of JSON payloads to fields,
+	// instead of converting every key to a field.
+	JSONPaths []JSONPath `json:"jsonPaths,omitempty"`
+	// JSONPathLanguage is the language of the path expressions,
+	// "jsonpath" (default) or "jmespath".
+	JSONPathLanguage string `json:"jsonPathLanguage,omitempty"`
+	// TimeField is the payload field with the time of the messages, such as "ts"
+	// or "meta.ts". Messages without a valid time use the time they were received,
+	// which is kept in the "Received" field.
+	TimeField string `json:"timeField,omitempty"`
+	// TimeFormat is the format of the time field: "rfc3339" (default), "epoch_s",
+	// "epoch_ms", "epoch_us", "epoch_ns" or a Go time layout.
+	TimeFormat string `json:"timeFormat,omitempty"`
+	// ExplodeArrays converts each element of JSON array payloads to its own row,
+	// so batches of records such as [{"ts":...,"v":...}, ...] are split into rows.
+	ExplodeArrays bool `json:"explodeArrays,omitempty"`
+	// PayloadFormat is the format of the payloads: "auto" (default), "json",
+	// "cbor", "msgpack", "influx", "csv", "binary", "regex" or "raw".
+	PayloadFormat string `json:"payloadFormat,omitempty"`
+	// CSVDelimiter separates the values of delimited text payloads. Defaults to ",".
+	CSVDelimiter string `json:"csvDelimiter,omitempty"`
+	// CSVHeader is true if the first line of delimited text payloads is a header row,
+	// which names the columns.
+	CSVHeader bool `json:"csvHeader,omitempty"`
+	// CSVColumns name the columns of delimited text payloads, in order, and set their types.
+	CSVColumns []CSVColumn `json:"csvColumns,omitempty"`
+	// BinaryFields are the values at fixed positions of binary payloads.
+	BinaryFields []BinaryField `json:"binaryFields,omitempty"`
+	// Regex is the regular expression of text payloads. Each named capture group,
+	// such as (?P<temperature>[0-9.]+), is converted to a field.
+	Regex string `json:"regex,omitempty"`
+	// FieldTypes pin the types of fields, instead of inferring them from their first value.
+	FieldTypes []FieldTypeOverride `json:"fieldTypes,omitempty"`
+	// TypeConversion is the policy for the values that don't have the type of their
+	// field: "drop" (default) or "convert".
+	TypeConversion string `json:"typeConversion,omitempty"`
+	// ProtoMessage is the full name of the protobuf message type of the payloads,
+	// such as "acme.sensors.Reading", which is looked up in the protobuf
+	// descriptors of the datasource with ResolveProtoMessage.
+	ProtoMessage string `json:"protoMessage,omitempty"`
+	// protoMessage is the resolved descriptor of ProtoMessage.
+	protoMessage protoreflect.MessageDescriptor
+}
+
+// Validate returns an error if the options are invalid.
+func (o FrameOptions) Validate() error {
+	if err := o.validatePayloadFormat(); err != nil {
+		return err
+	}
+	if err := o.validateFieldTypes(); err != nil {
+		return err
+	}
+	switch o.PayloadFormat {
+	case PayloadFormatCSV:
+		if err := o.validateCSV(); err != nil {
+			return err
+		}
+	case PayloadFormatBinary:
+		if err := o.validateBinaryFields(); err != nil {
+			return err
+		}
+	case PayloadFormatRegex:
+		if _, err := compileRegex(o.Regex); err != nil {
+			return err
+		}
+	}
+	_, err := compileJSONPaths(o.JSONPathLanguage, o.JSONPaths)
+	return err
 }
 
 // Key returns the key for the topic.
@@ -32,74 +132,268 @@ func (t *Topic) Key() string {
 	return path.Join(t.Interval.String(), t.Path, t.StreamingKey)
 }
 
-// ToDataFrame converts the topic to a data frame.
+// AddMessage adds a message to the buffer of the topic. When the buffer is full,
+// the oldest message is dropped.
+//
+// It is safe to call concurrently with ToDataFrame for topics created by
+// Client.Subscribe or stored in a TopicMap. Other topics allocate their
+// buffer on the first message.
+func (t *Topic) AddMessage(message Message) {
+	if t.messages == nil {
+		t.messages = newMessageBuffer(BufferLimits{})
+	}
+	t.messages.add(message)
+}
+
+// Messages returns the messages buffered since the last frame, oldest first.
+func (t *Topic) Messages() []Message {
+	if t.messages == nil {
+		return nil
+	}
+	return t.messages.snapshot()
+}
+
+// DiscardMessages removes the buffered messages from the topic,
+// for streams that don't convert the messages to frames.
+func (t *Topic) DiscardMessages() {
+	t.drain()
+}
+
+// waitForMessage returns a channel that is closed when a message is added to the topic.
+func (t *Topic) waitForMessage() <-chan struct{} {
+	if t.messages == nil {
+		t.messages = newMessageBuffer(BufferLimits{})
+	}
+	return t.messages.waitForMessage()
+}
+
+// drain removes the buffered messages from the topic and returns them,
+// with the number of messages that were dropped because the buffer was full.
+func (t *Topic) drain() ([]Message, int) {
+	if t.messages == nil {
+		return nil, 0
+	}
+	return t.messages.drain()
+}
+
+// ToDataFrame converts the messages buffered since the last frame to a data frame.
+// The messages are removed from the buffer at once, so messages received while
+// the frame is built are kept for the next frame.
+//
+// When splitting by topic, the frames of each topic are combined into a single frame
+// with the labels of each row in the first field. Grafana Live splits these frames into
+// a series per label set, which keeps the schema of the stream stable as topics come and go.
 func (t *Topic) ToDataFrame(logger log.Logger) (*data.Frame, error) {
+	messages, dropped := t.drain()
+
+	frame, err := t.MessagesToDataFrame(messages, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	addDroppedNotice(frame, dropped)
+	return frame, nil
+}
+
+// MessagesToDataFrame converts the given messages to a data frame,
+// the same way ToDataFrame converts the buffered messages.
+func (t *Topic) MessagesToDataFrame(messages []Message, logger log.Logger) (*data.Frame, error) {
+	if filter, split := t.splitByTopic(logger); split {
+		frames, err := t.toTopicFrames(filter, messages, logger)
+		if err != nil {
+			return nil, err
+		}
+		return toLabelsColumnFrame(frames), nil
+	}
+	return t.toFrame(messages, logger)
+}
+
+// MessagesToDataFrames converts the given messages to data frames. This is a single frame,
+// unless the messages are split by topic, in which case each topic received on a
+// subscription with wildcards is converted to its own frame.
+func (t *Topic) MessagesToDataFrames(messages []Message, logger log.Logger) (data.Frames, error) {
+	if filter, split := t.splitByTopic(logger); split {
+		topicFrames, err := t.toTopicFrames(filter, messages, logger)
+		if err != nil {
+			return nil, err
+		}
+		frames := make(data.Frames, 0, len(topicFrames))
+		for _, tf := range topicFrames {
+			frames = append(frames, tf.frame)
+		}
+		return frames, nil
+	}
+
+	frame, err := t.toFrame(messages, logger)
+	if err != nil {
+		return nil, err
+	}
+	return data.Frames{frame}, nil
+}
+
+// addDroppedNotice adds a notice to the frame when messages were dropped
+// because they arrived faster than they were converted to frames.
+func addDroppedNotice(frame *data.Frame, dropped int) {
+	if dropped == 0 {
+		return
+	}
+	frame.AppendNotices(data.Notice{
+		Severity: data.NoticeSeverityWarning,
+		Text: fmt.Sprintf(plural(dropped,
+			"%d message was dropped because the message buffer of the topic was full",
+			"%d messages were dropped because the message buffer of the topic was full"), dropped),
+	})
+}
+
+func (t *Topic) toFrame(messages []Message, logger log.Logger) (*data.Frame, error) {
 	if t.framer == nil {
-		t.framer = newFramer()
+		t.framer = newFramer(t.FrameOptions)
+		// With wildcards the messages can come from different topics,
+		// so the topic of each message is added to the frame.
+		if topic, err := decodeTopic(t.Path, logger); err == nil && hasWildcards(topic) {
+			t.framer.addTopicField()
+		}
 	}
-	return t.framer.toFrame(t.Messages, logger)
+	return t.framer.toFrame(messages, logger)
 }
 
-// TopicMap is a thread-safe map of topics
+// TopicMap is a thread-safe map of topics by key, indexed by their path
+// so a message can be added to the topics subscribed to its path without
+// scanning all topics.
 type TopicMap struct {
-	sync.Map
+	mu     sync.RWMutex
+	topics map[string]*Topic
+	// subscribers are the topics by path. The slices are replaced instead of
+	// modified, so they can be used after the lock is released.
+	subscribers map[string][]*Topic
 }
 
 // Load returns the topic for the given topic key.
 func (tm *TopicMap) Load(key string) (*Topic, bool) {
-	t, ok := tm.Map.Load(key)
-	if !ok {
-		return nil, false
-	}
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
 
-	topic, ok := t.(*Topic)
+	topic, ok := tm.topics[key]
 	return topic, ok
 }
 
-// AddMessage adds a message to the topic for the given path.
+// Len returns the number of topics in the map.
+func (tm *TopicMap) Len() int {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+
+	return len(tm.topics)
+}
+
+// AddMessage adds a message to the topics subscribed to the given path.
 func (tm *TopicMap) AddMessage(path string, message Message) {
-	tm.Range(func(key, t any) bool {
-		topic, ok := t.(*Topic)
-		if !ok {
-			return false
-		}
-		if topic.Path == path {
-			topic.Messages = append(topic.Messages, message)
-			tm.Store(topic)
-		}
-		return true
-	})
+	tm.mu.RLock()
+	subscribers := tm.subscribers[path]
+	tm.mu.RUnlock()
+
+	for _, topic := range subscribers {
+		topic.AddMessage(message)
+	}
 }
 
-// HasSubscription returns true if the topic map has a subscription for the given path.
-func (tm *TopicMap) HasSubscription(path string) bool {
-	found := false
+// subscriberCount returns the number of topics subscribed to the given path.
+func (tm *TopicMap) subscriberCount(path string) int {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
 
-	tm.Range(func(key, t any) bool {
-		topic, ok := t.(*Topic)
-		if !ok {
-			return true // this shouldn't happen, but continue iterating
-		}
+	return len(tm.subscribers[path])
+}
 
-		if topic.Path == path {
-			found = true
-			return false // topic found, stop iterating
-		}
+// HasSubscription reports whether the topic map has a subscription for the given path,
+// and returns the highest QoS requested by the topics with that path.
+func (tm *TopicMap) HasSubscription(path string) (byte, bool) {
+	tm.mu.RLock()
+	subscribers := tm.subscribers[path]
+	tm.mu.RUnlock()
 
-		return true // continue iterating
-	})
+	var qos byte
+	for _, topic := range subscribers {
+		qos = max(qos, topic.QoS)
+	}
 
-	return found
+	return qos, len(subscribers) > 0
 }
 
-// Store stores the topic in the map.
+// Store stores the topic in the map, replacing the topic with the same key.
 func (tm *TopicMap) Store(t *Topic) {
-	tm.Map.Store(t.Key(), t)
+	if t.messages == nil {
+		t.messages = newMessageBuffer(BufferLimits{})
+	}
+	key := t.Key()
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	if tm.topics == nil {
+		tm.topics = make(map[string]*Topic)
+		tm.subscribers = make(map[string][]*Topic)
+	}
+
+	tm.deleteLocked(key)
+	tm.topics[key] = t
+	tm.subscribers[t.Path] = append(slices.Clip(tm.subscribers[t.Path]), t)
 }
 
 // Delete deletes the topic for the given key.
 func (tm *TopicMap) Delete(key string) {
-	tm.Map.Delete(key)
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	tm.deleteLocked(key)
+}
+
+func (tm *TopicMap) deleteLocked(key string) {
+	topic, ok := tm.topics[key]
+	if !ok {
+		return
+	}
+	delete(tm.topics, key)
+
+	subscribers := slices.DeleteFunc(slices.Clone(tm.subscribers[topic.Path]), func(t *Topic) bool {
+		return t == topic
+	})
+	if len(subscribers) == 0 {
+		delete(tm.subscribers, topic.Path)
+		return
+	}
+	tm.subscribers[topic.Path] = subscribers
+}
+
+// MatchTopic reports whether the topic name matches the MQTT topic filter.
+// A "+" level in the filter matches any single level and a trailing "#" matches
+// any remaining levels, including none. As in the MQTT specification, wildcards
+// at the first level don't match topics starting with "$", such as "$SYS/broker".
+func MatchTopic(filter string, topic string) bool {
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) || (level != "+" && level != topicLevels[i]) {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+// hasWildcards reports whether the MQTT topic filter contains wildcards.
+func hasWildcards(topic string) bool {
+	return strings.ContainsAny(topic, "+#")
+}
+
+// DecodeTopic decodes the MQTT topic from the topic path of a query or channel.
+func DecodeTopic(topicPath string) (string, error) {
+	return decodeTopic(topicPath, log.DefaultLogger)
 }
 
 // decodeTopic decodes an MQTT topic name from base64 URL encoding.
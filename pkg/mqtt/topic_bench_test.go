@@ -0,0 +1,59 @@
+package mqtt
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// BenchmarkTopicMap_AddMessage measures the fan-out of a message to the topics
+// subscribed to its path, with the given number of subscriptions to other paths.
+func BenchmarkTopicMap_AddMessage(b *testing.B) {
+	for _, subscriptions := range []int{1, 10, 100, 1000} {
+		b.Run(fmt.Sprintf("subscriptions=%d", subscriptions), func(b *testing.B) {
+			tm := &TopicMap{}
+			for i := range subscriptions {
+				tm.Store(&Topic{
+					Path:         fmt.Sprintf("sensor/%d", i),
+					Interval:     time.Second,
+					StreamingKey: "ds/hash/org",
+				})
+			}
+			message := Message{Timestamp: time.Now(), Value: []byte("21.5")}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				tm.AddMessage("sensor/0", message)
+			}
+		})
+	}
+}
+
+// BenchmarkTopicMap_AddMessage_SharedPath measures the fan-out of a message
+// to many topics subscribed to the same path.
+func BenchmarkTopicMap_AddMessage_SharedPath(b *testing.B) {
+	for _, subscribers := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("subscribers=%d", subscribers), func(b *testing.B) {
+			tm := &TopicMap{}
+			for i := range 1000 {
+				path := fmt.Sprintf("sensor/%d", i)
+				if i < subscribers {
+					path = "sensor/shared"
+				}
+				tm.Store(&Topic{
+					Path:         path,
+					Interval:     time.Second,
+					StreamingKey: fmt.Sprintf("ds/hash%d/org", i),
+				})
+			}
+			message := Message{Timestamp: time.Now(), Value: []byte("21.5")}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				tm.AddMessage("sensor/shared", message)
+			}
+		})
+	}
+}
@@ -3,6 +3,9 @@ package mqtt
 import (
 	"testing"
 	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/stretchr/testify/require"
 )
 
 func TestTopic_Key(t *testing.T) {
@@ -146,14 +149,12 @@ func TestTopicMap_AddMessage_WithStreamingKey(t *testing.T) {
 		Path:         "sensor/temp",
 		Interval:     1 * time.Second,
 		StreamingKey: "user1/hash123/org456",
-		Messages:     []Message{},
 	}
 
 	topic2 := &Topic{
 		Path:         "sensor/temp", // Same MQTT path
 		Interval:     1 * time.Second,
 		StreamingKey: "user2/hash456/org456", // Different streaming key
-		Messages:     []Message{},
 	}
 
 	tm.Store(topic1)
@@ -171,10 +172,129 @@ func TestTopicMap_AddMessage_WithStreamingKey(t *testing.T) {
 	updatedTopic1, _ := tm.Load(topic1.Key())
 	updatedTopic2, _ := tm.Load(topic2.Key())
 
-	if len(updatedTopic1.Messages) != 1 {
-		t.Errorf("Expected 1 message in topic1, got %d", len(updatedTopic1.Messages))
+	if len(updatedTopic1.Messages()) != 1 {
+		t.Errorf("Expected 1 message in topic1, got %d", len(updatedTopic1.Messages()))
+	}
+	if len(updatedTopic2.Messages()) != 1 {
+		t.Errorf("Expected 1 message in topic2, got %d", len(updatedTopic2.Messages()))
+	}
+}
+
+func TestTopicMap_HasSubscription(t *testing.T) {
+	tm := &TopicMap{}
+
+	_, found := tm.HasSubscription("sensor/temp")
+	require.False(t, found)
+
+	tm.Store(&Topic{Path: "sensor/temp", Interval: time.Second, StreamingKey: "user1/hash123/org456", QoS: 1})
+	tm.Store(&Topic{Path: "sensor/temp", Interval: time.Second, StreamingKey: "user2/hash456/org456", QoS: 2})
+	tm.Store(&Topic{Path: "sensor/humidity", Interval: time.Second, StreamingKey: "user1/hash123/org456"})
+
+	qos, found := tm.HasSubscription("sensor/temp")
+	require.True(t, found)
+	require.Equal(t, byte(2), qos)
+
+	qos, found = tm.HasSubscription("sensor/humidity")
+	require.True(t, found)
+	require.Equal(t, byte(0), qos)
+}
+
+func TestTopic_ToDataFrame_TopicField(t *testing.T) {
+	messages := []Message{
+		{Timestamp: time.Unix(0, 0), Topic: "sensors/a/temperature", Value: []byte("21.5")},
 	}
-	if len(updatedTopic2.Messages) != 1 {
-		t.Errorf("Expected 1 message in topic2, got %d", len(updatedTopic2.Messages))
+
+	t.Run("subscription with wildcards", func(t *testing.T) {
+		// "c2Vuc29ycy8rL3RlbXBlcmF0dXJl" is the encoded "sensors/+/temperature"
+		topic := withMessages(&Topic{Path: "c2Vuc29ycy8rL3RlbXBlcmF0dXJl", Interval: time.Second}, messages...)
+		frame, err := topic.ToDataFrame(log.DefaultLogger)
+		require.NoError(t, err)
+
+		field, idx := frame.FieldByName("Topic")
+		require.NotEqual(t, -1, idx)
+		require.Equal(t, "sensors/a/temperature", field.At(0))
+	})
+
+	t.Run("subscription without wildcards", func(t *testing.T) {
+		// "c2Vuc29ycy9hL3RlbXBlcmF0dXJl" is the encoded "sensors/a/temperature"
+		topic := withMessages(&Topic{Path: "c2Vuc29ycy9hL3RlbXBlcmF0dXJl", Interval: time.Second}, messages...)
+		frame, err := topic.ToDataFrame(log.DefaultLogger)
+		require.NoError(t, err)
+
+		_, idx := frame.FieldByName("Topic")
+		require.Equal(t, -1, idx)
+	})
+}
+
+// withMessages adds the messages to the topic and returns it.
+func withMessages(topic *Topic, messages ...Message) *Topic {
+	for _, message := range messages {
+		topic.AddMessage(message)
+	}
+	return topic
+}
+
+func TestTopicMap_Delete(t *testing.T) {
+	tm := &TopicMap{}
+
+	topic1 := &Topic{Path: "sensor/temp", Interval: time.Second, StreamingKey: "user1/hash123/org456"}
+	topic2 := &Topic{Path: "sensor/temp", Interval: time.Second, StreamingKey: "user2/hash456/org456"}
+	tm.Store(topic1)
+	tm.Store(topic2)
+	require.Equal(t, 2, tm.Len())
+
+	tm.Delete(topic1.Key())
+	require.Equal(t, 1, tm.Len())
+
+	// the deleted topic no longer receives messages
+	tm.AddMessage("sensor/temp", Message{Value: []byte("1")})
+	require.Empty(t, topic1.Messages())
+	require.Len(t, topic2.Messages(), 1)
+
+	tm.Delete(topic2.Key())
+	_, found := tm.HasSubscription("sensor/temp")
+	require.False(t, found)
+}
+
+func TestTopicMap_Store_Replace(t *testing.T) {
+	tm := &TopicMap{}
+
+	topic1 := &Topic{Path: "sensor/temp", Interval: time.Second, StreamingKey: "user1/hash123/org456"}
+	topic2 := &Topic{Path: "sensor/temp", Interval: time.Second, StreamingKey: "user1/hash123/org456"}
+	tm.Store(topic1)
+	tm.Store(topic2)
+	require.Equal(t, 1, tm.Len())
+
+	// only the stored topic receives messages
+	tm.AddMessage("sensor/temp", Message{Value: []byte("1")})
+	require.Empty(t, topic1.Messages())
+	require.Len(t, topic2.Messages(), 1)
+}
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		match  bool
+	}{
+		{filter: "sensor/temp", topic: "sensor/temp", match: true},
+		{filter: "sensor/temp", topic: "sensor/humidity", match: false},
+		{filter: "sensor/+", topic: "sensor/temp", match: true},
+		{filter: "sensor/+", topic: "sensor/temp/c", match: false},
+		{filter: "sensor/+/c", topic: "sensor/temp/c", match: true},
+		{filter: "sensor/#", topic: "sensor/temp/c", match: true},
+		{filter: "sensor/#", topic: "sensor", match: true},
+		{filter: "sensor/#", topic: "actuator/valve", match: false},
+		{filter: "#", topic: "sensor/temp", match: true},
+		{filter: "#", topic: "$SYS/broker/uptime", match: false},
+		{filter: "+/broker/uptime", topic: "$SYS/broker/uptime", match: false},
+		{filter: "$SYS/#", topic: "$SYS/broker/uptime", match: true},
+		{filter: "sensor/temp/c", topic: "sensor/temp", match: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter+" "+tt.topic, func(t *testing.T) {
+			require.Equal(t, tt.match, MatchTopic(tt.filter, tt.topic))
+		})
 	}
 }
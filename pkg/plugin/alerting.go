@@ -0,0 +1,113 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/grafana/mqtt-datasource/pkg/mqtt"
+)
+
+const (
+	defaultWaitTimeout = 5 * time.Second
+	maxWaitTimeout     = time.Minute
+)
+
+// latestValue returns the latest value of the topic as a numeric frame, which can be used
+// by server-side expressions and alert rules. If no message was received on the topic yet,
+// it waits for a message up to the timeout of the query. When splitting by topic, the latest
+// value of each topic is returned, labeled with the topic levels matched by the wildcards.
+func (ds *MQTTDatasource) latestValue(ctx context.Context, qm queryModel, logger log.Logger) backend.DataResponse {
+	timeout := defaultWaitTimeout
+	if qm.WaitTimeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(qm.WaitTimeout)
+		if err != nil || timeout <= 0 || timeout > maxWaitTimeout {
+			return backend.ErrorResponseWithErrorSource(backend.DownstreamErrorf("invalid wait timeout %q: must be a duration up to %s", qm.WaitTimeout, maxWaitTimeout))
+		}
+	}
+
+	messages, err := ds.Client.WaitForMessages(ctx, qm.Path, qm.QoS, timeout, logger)
+	if err != nil {
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	if len(messages) == 0 {
+		frame := data.NewFrame("mqtt")
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("No message was received within %s", timeout),
+		})
+		return backend.DataResponse{Frames: data.Frames{frame}}
+	}
+
+	topic := &mqtt.Topic{Path: qm.Path, FrameOptions: qm.FrameOptions}
+	frames, err := topic.MessagesToDataFrames(latestMessages(messages, qm.SplitByTopic), logger)
+	if err != nil {
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	var response backend.DataResponse
+	for _, frame := range frames {
+		if numeric := toNumericFrame(frame); numeric != nil {
+			response.Frames = append(response.Frames, numeric)
+		}
+	}
+	if len(response.Frames) == 0 {
+		return backend.ErrorResponseWithErrorSource(backend.DownstreamErrorf("the latest message has no numeric values"))
+	}
+	return response
+}
+
+// latestMessages returns the latest message, or the latest message of each topic.
+func latestMessages(messages []mqtt.Message, perTopic bool) []mqtt.Message {
+	if !perTopic {
+		return messages[len(messages)-1:]
+	}
+
+	latest := make(map[string]int)
+	for i, message := range messages {
+		latest[message.Topic] = i
+	}
+
+	result := make([]mqtt.Message, 0, len(latest))
+	for i, message := range messages {
+		if latest[message.Topic] == i {
+			result = append(result, message)
+		}
+	}
+	return result
+}
+
+// toNumericFrame converts the numeric fields of a frame with the latest values to a
+// numeric wide frame. It returns nil if the frame has no numeric fields.
+func toNumericFrame(frame *data.Frame) *data.Frame {
+	if frame.Rows() == 0 {
+		return nil
+	}
+
+	numeric := data.NewFrame(frame.Name)
+	for _, field := range frame.Fields {
+		if !field.Type().Numeric() {
+			continue
+		}
+		f := data.NewFieldFromFieldType(field.Type(), 1)
+		f.Name = field.Name
+		f.Labels = field.Labels
+		f.Set(0, field.At(field.Len()-1))
+		numeric.Fields = append(numeric.Fields, f)
+	}
+	if len(numeric.Fields) == 0 {
+		return nil
+	}
+
+	numeric.SetMeta(&data.FrameMeta{
+		Type:        data.FrameTypeNumericWide,
+		TypeVersion: data.FrameTypeVersion{0, 1},
+	})
+	return numeric
+}
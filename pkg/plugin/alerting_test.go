@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/mqtt-datasource/pkg/mqtt"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuery_WaitForValue(t *testing.T) {
+	now := time.Now()
+	ds := &MQTTDatasource{
+		Client: &mockMQTTClient{
+			history: map[string][]mqtt.Message{
+				"sensor/temperature": {
+					{Timestamp: now.Add(-time.Minute), Topic: "sensor/temperature", Value: []byte(`{"value":20.5,"unit":"C"}`)},
+					{Timestamp: now, Topic: "sensor/temperature", Value: []byte(`{"value":21.5,"unit":"C"}`)},
+				},
+				// "cGxhbnQvKy9wb3dlcg" is the encoded "plant/+/power"
+				"cGxhbnQvKy9wb3dlcg": {
+					{Timestamp: now.Add(-time.Minute), Topic: "plant/a/power", Value: []byte("1")},
+					{Timestamp: now.Add(-time.Minute), Topic: "plant/b/power", Value: []byte("2")},
+					{Timestamp: now, Topic: "plant/a/power", Value: []byte("3")},
+				},
+				"sensor/status": {
+					{Timestamp: now, Topic: "sensor/status", Value: []byte(`"online"`)},
+				},
+			},
+		},
+		channelPrefix: "ds/test-uid",
+	}
+
+	query := func(json string) backend.DataResponse {
+		return ds.query(context.Background(), backend.DataQuery{
+			JSON:     []byte(json),
+			Interval: time.Second,
+		}, log.DefaultLogger)
+	}
+
+	t.Run("latest value", func(t *testing.T) {
+		resp := query(`{"topic":"sensor/temperature","waitForValue":true}`)
+		require.NoError(t, resp.Error)
+		require.Len(t, resp.Frames, 1)
+
+		frame := resp.Frames[0]
+		require.Equal(t, data.FrameTypeNumericWide, frame.Meta.Type)
+		require.Empty(t, frame.Meta.Channel)
+		require.Len(t, frame.Fields, 1)
+		require.Equal(t, "value", frame.Fields[0].Name)
+		require.Equal(t, 21.5, *frame.Fields[0].At(0).(*float64))
+	})
+
+	t.Run("latest value of each topic", func(t *testing.T) {
+		resp := query(`{"topic":"cGxhbnQvKy9wb3dlcg","waitForValue":true,"splitByTopic":true,"wildcardLabels":["device"]}`)
+		require.NoError(t, resp.Error)
+		require.Len(t, resp.Frames, 2)
+
+		require.Equal(t, data.Labels{"device": "a"}, resp.Frames[0].Fields[0].Labels)
+		require.Equal(t, 3.0, *resp.Frames[0].Fields[0].At(0).(*float64))
+		require.Equal(t, data.Labels{"device": "b"}, resp.Frames[1].Fields[0].Labels)
+		require.Equal(t, 2.0, *resp.Frames[1].Fields[0].At(0).(*float64))
+	})
+
+	t.Run("no message received", func(t *testing.T) {
+		resp := query(`{"topic":"sensor/humidity","waitForValue":true,"waitTimeout":"1s"}`)
+		require.NoError(t, resp.Error)
+		require.Len(t, resp.Frames, 1)
+		require.Equal(t, 0, resp.Frames[0].Rows())
+		require.Equal(t, "No message was received within 1s", resp.Frames[0].Meta.Notices[0].Text)
+	})
+
+	t.Run("no numeric values", func(t *testing.T) {
+		resp := query(`{"topic":"sensor/status","waitForValue":true}`)
+		require.EqualError(t, resp.Error, "the latest message has no numeric values")
+	})
+
+	t.Run("invalid timeout", func(t *testing.T) {
+		resp := query(`{"topic":"sensor/temperature","waitForValue":true,"waitTimeout":"1h"}`)
+		require.EqualError(t, resp.Error, `invalid wait timeout "1h": must be a duration up to 1m0s`)
+	})
+}
@@ -28,17 +28,32 @@ func NewMQTTInstance(ctx context.Context, s backend.DataSourceInstanceSettings)
 		return nil, err
 	}
 
+	protos, err := mqtt.NewProtoRegistry(settings.ProtoDescriptors)
+	if err != nil {
+		return nil, backend.DownstreamError(err)
+	}
+
 	client, err := mqtt.NewClient(ctx, *settings, s)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewMQTTDatasource(client, s.UID), nil
+	ds := NewMQTTDatasource(client, s.UID)
+	ds.publish = settings.PublishOptions
+	ds.acl = settings.TopicACL
+	ds.protos = protos
+	return ds, nil
 }
 
 type MQTTDatasource struct {
 	Client        mqtt.Client
 	channelPrefix string
+	// publish controls publishing to topics through PublishStream.
+	publish mqtt.PublishOptions
+	// acl restricts the topics that can be queried, streamed and published to.
+	acl mqtt.TopicACL
+	// protos are the protobuf message types that queries can decode payloads with.
+	protos *mqtt.ProtoRegistry
 }
 
 // NewMQTTDatasource creates a new datasource instance.
@@ -57,6 +72,23 @@ func (ds *MQTTDatasource) Dispose() {
 	ds.Client.Dispose()
 }
 
+// checkTopic returns an error with the reason if the encoded topic of a query
+// or channel is not permitted by the topic ACL of the datasource.
+func (ds *MQTTDatasource) checkTopic(topicPath string) error {
+	if ds.acl.IsEmpty() {
+		return nil
+	}
+
+	topic, err := mqtt.DecodeTopic(topicPath)
+	if err != nil {
+		return backend.DownstreamErrorf("error decoding MQTT topic name %s: %s", topicPath, err)
+	}
+	if err := ds.acl.Check(topic); err != nil {
+		return backend.DownstreamErrorf("permission denied: %w", err)
+	}
+	return nil
+}
+
 func getDatasourceSettings(s backend.DataSourceInstanceSettings) (*mqtt.Options, error) {
 	settings := &mqtt.Options{}
 
@@ -3,6 +3,7 @@ package plugin_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
@@ -41,6 +42,14 @@ func TestCheckHealthHandler(t *testing.T) {
 	})
 }
 
+func TestNewMQTTInstance_InvalidProtoDescriptors(t *testing.T) {
+	_, err := plugin.NewMQTTInstance(context.Background(), backend.DataSourceInstanceSettings{
+		JSONData: []byte(`{"uri":"tcp://localhost:1883","protoDescriptors":"not base64"}`),
+	})
+	require.ErrorContains(t, err, "invalid protobuf descriptors")
+	require.True(t, backend.IsDownstreamError(err))
+}
+
 type fakeMQTTClient struct {
 	connected bool
 }
@@ -53,6 +62,20 @@ func (c *fakeMQTTClient) IsConnected() bool {
 	return c.connected
 }
 
-func (c *fakeMQTTClient) Subscribe(_ string, _ log.Logger) (*mqtt.Topic, error) { return nil, nil }
-func (c *fakeMQTTClient) Unsubscribe(_ string, _ log.Logger) error              { return nil }
-func (c *fakeMQTTClient) Dispose()                                              {}
+func (c *fakeMQTTClient) Subscribe(_ string, _ byte, _ log.Logger) (*mqtt.Topic, error) {
+	return nil, nil
+}
+func (c *fakeMQTTClient) Unsubscribe(_ string, _ log.Logger) error { return nil }
+func (c *fakeMQTTClient) History(_ string, _ byte, _ log.Logger) ([]mqtt.Message, error) {
+	return nil, nil
+}
+func (c *fakeMQTTClient) Publish(_ string, _ []byte, _ byte, _ bool, _ log.Logger) error {
+	return nil
+}
+func (c *fakeMQTTClient) WaitForMessages(_ context.Context, _ string, _ byte, _ time.Duration, _ log.Logger) ([]mqtt.Message, error) {
+	return nil, nil
+}
+func (c *fakeMQTTClient) SparkplugStates(_ string) []mqtt.SparkplugState {
+	return nil
+}
+func (c *fakeMQTTClient) Dispose() {}
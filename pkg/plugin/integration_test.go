@@ -1,6 +1,7 @@
 package plugin
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 	"time"
@@ -8,6 +9,7 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/mqtt-datasource/pkg/mqtt"
+	"github.com/stretchr/testify/require"
 )
 
 // Integration tests to verify end-to-end streaming key functionality
@@ -52,13 +54,14 @@ func TestStreamingKeyIntegration_TopicUniqueness(t *testing.T) {
 
 	// Create datasource instance
 	ds := &MQTTDatasource{
+		Client:        &mockMQTTClient{},
 		channelPrefix: "ds/test-uid",
 	}
 
 	// Process queries
-	resp1 := ds.query(query1)
-	resp2 := ds.query(query2)
-	resp3 := ds.query(query3)
+	resp1 := ds.query(context.Background(), query1, log.DefaultLogger)
+	resp2 := ds.query(context.Background(), query2, log.DefaultLogger)
+	resp3 := ds.query(context.Background(), query3, log.DefaultLogger)
 
 	// Verify no errors
 	if resp1.Error != nil {
@@ -119,15 +122,15 @@ func TestStreamingKeyIntegration_ClientSubscription(t *testing.T) {
 	topicKey3 := "1s/dGVzdC90b3BpYw/user1/hash123/org789"
 
 	// Subscribe to all three
-	topic1, err := client.Subscribe(topicKey1, log.DefaultLogger)
+	topic1, err := client.Subscribe(topicKey1, 0, log.DefaultLogger)
 	if err != nil {
 		t.Fatalf("Subscribe failed: %v", err)
 	}
-	topic2, err := client.Subscribe(topicKey2, log.DefaultLogger)
+	topic2, err := client.Subscribe(topicKey2, 0, log.DefaultLogger)
 	if err != nil {
 		t.Fatalf("Subscribe failed: %v", err)
 	}
-	topic3, err := client.Subscribe(topicKey3, log.DefaultLogger)
+	topic3, err := client.Subscribe(topicKey3, 0, log.DefaultLogger)
 	if err != nil {
 		t.Fatalf("Subscribe failed: %v", err)
 	}
@@ -173,11 +176,11 @@ func TestStreamingKeyIntegration_MessageIsolation(t *testing.T) {
 	topicKey1 := "1s/dGVzdC90b3BpYw/user1/hash123/org456"
 	topicKey2 := "1s/dGVzdC90b3BpYw/user2/hash456/org456"
 
-	topic1, err := client.Subscribe(topicKey1, log.DefaultLogger)
+	topic1, err := client.Subscribe(topicKey1, 0, log.DefaultLogger)
 	if err != nil {
 		t.Fatalf("Subscribe failed: %v", err)
 	}
-	topic2, err := client.Subscribe(topicKey2, log.DefaultLogger)
+	topic2, err := client.Subscribe(topicKey2, 0, log.DefaultLogger)
 	if err != nil {
 		t.Fatalf("Subscribe failed: %v", err)
 	}
@@ -188,30 +191,30 @@ func TestStreamingKeyIntegration_MessageIsolation(t *testing.T) {
 
 	// Manually add messages to test isolation
 	// In real implementation, messages would be routed based on MQTT topic matching
-	topic1.Messages = append(topic1.Messages, mqtt.Message{
+	topic1.AddMessage(mqtt.Message{
 		Timestamp: time.Now(),
 		Value:     []byte("message for user1"),
 	})
 
-	topic2.Messages = append(topic2.Messages, mqtt.Message{
+	topic2.AddMessage(mqtt.Message{
 		Timestamp: time.Now(),
 		Value:     []byte("message for user2"),
 	})
 
 	// Verify topics maintain separate message stores
-	if len(topic1.Messages) != 1 {
-		t.Errorf("Expected 1 message in topic1, got %d", len(topic1.Messages))
+	if len(topic1.Messages()) != 1 {
+		t.Errorf("Expected 1 message in topic1, got %d", len(topic1.Messages()))
 	}
-	if len(topic2.Messages) != 1 {
-		t.Errorf("Expected 1 message in topic2, got %d", len(topic2.Messages))
+	if len(topic2.Messages()) != 1 {
+		t.Errorf("Expected 1 message in topic2, got %d", len(topic2.Messages()))
 	}
 
 	// Verify message content
-	if string(topic1.Messages[0].Value) != "message for user1" {
-		t.Errorf("Expected 'message for user1', got '%s'", string(topic1.Messages[0].Value))
+	if string(topic1.Messages()[0].Value) != "message for user1" {
+		t.Errorf("Expected 'message for user1', got '%s'", string(topic1.Messages()[0].Value))
 	}
-	if string(topic2.Messages[0].Value) != "message for user2" {
-		t.Errorf("Expected 'message for user2', got '%s'", string(topic2.Messages[0].Value))
+	if string(topic2.Messages()[0].Value) != "message for user2" {
+		t.Errorf("Expected 'message for user2', got '%s'", string(topic2.Messages()[0].Value))
 	}
 
 	// Verify topics are still separate instances
@@ -224,6 +227,13 @@ func TestStreamingKeyIntegration_MessageIsolation(t *testing.T) {
 type mockMQTTClient struct {
 	topics        map[string]*mqtt.Topic
 	subscriptions map[string]bool
+	history       map[string][]mqtt.Message
+	published     []mqtt.Message
+	// sparkplugStates are returned for any filter, which is recorded in sparkplugFilter.
+	sparkplugStates []mqtt.SparkplugState
+	sparkplugFilter string
+	// subscribedQoS is the QoS of the last subscription.
+	subscribedQoS byte
 }
 
 func (m *mockMQTTClient) GetTopic(reqPath string) (*mqtt.Topic, bool) {
@@ -235,7 +245,9 @@ func (m *mockMQTTClient) IsConnected() bool {
 	return true
 }
 
-func (m *mockMQTTClient) Subscribe(reqPath string, logger log.Logger) (*mqtt.Topic, error) {
+func (m *mockMQTTClient) Subscribe(reqPath string, qos byte, logger log.Logger) (*mqtt.Topic, error) {
+	m.subscribedQoS = qos
+
 	// Check if already exists
 	if topic, exists := m.topics[reqPath]; exists {
 		return topic, nil
@@ -246,7 +258,6 @@ func (m *mockMQTTClient) Subscribe(reqPath string, logger log.Logger) (*mqtt.Top
 	topic := &mqtt.Topic{
 		Path:     "dGVzdC90b3BpYw", // This would be the full path with streaming key
 		Interval: 1 * time.Second,
-		Messages: []mqtt.Message{},
 	}
 
 	// Store with reqPath as key
@@ -263,6 +274,24 @@ func (m *mockMQTTClient) Unsubscribe(reqPath string, logger log.Logger) error {
 	return nil
 }
 
+func (m *mockMQTTClient) History(topicPath string, _ byte, _ log.Logger) ([]mqtt.Message, error) {
+	return m.history[topicPath], nil
+}
+
+func (m *mockMQTTClient) WaitForMessages(_ context.Context, topicPath string, _ byte, _ time.Duration, _ log.Logger) ([]mqtt.Message, error) {
+	return m.history[topicPath], nil
+}
+
+func (m *mockMQTTClient) Publish(topic string, payload []byte, _ byte, _ bool, _ log.Logger) error {
+	m.published = append(m.published, mqtt.Message{Topic: topic, Value: payload})
+	return nil
+}
+
+func (m *mockMQTTClient) SparkplugStates(filter string) []mqtt.SparkplugState {
+	m.sparkplugFilter = filter
+	return m.sparkplugStates
+}
+
 func (m *mockMQTTClient) Dispose() {
 	m.topics = make(map[string]*mqtt.Topic)
 	m.subscriptions = make(map[string]bool)
@@ -277,9 +306,148 @@ func (m *mockMQTTClient) HandleMessage(topicPath string, payload []byte) {
 	// Find topics that match this path and add message
 	for key, topic := range m.topics {
 		if topic.Path == topicPath {
-			topic.Messages = append(topic.Messages, message)
+			topic.AddMessage(message)
 			// Update the stored topic
 			m.topics[key] = topic
 		}
 	}
 }
+
+func TestQuery_QoS(t *testing.T) {
+	ds := &MQTTDatasource{
+		Client:        &mockMQTTClient{},
+		channelPrefix: "ds/test-uid",
+	}
+
+	t.Run("invalid QoS", func(t *testing.T) {
+		resp := ds.query(context.Background(), backend.DataQuery{
+			JSON:     []byte(`{"topic":"sensor/temperature","qos":3}`),
+			Interval: time.Second,
+		}, log.DefaultLogger)
+		require.EqualError(t, resp.Error, "invalid QoS level 3: must be 0, 1 or 2")
+	})
+}
+
+func TestQuery_InvalidJSONPath(t *testing.T) {
+	ds := &MQTTDatasource{
+		Client:        &mockMQTTClient{},
+		channelPrefix: "ds/test-uid",
+	}
+
+	resp := ds.query(context.Background(), backend.DataQuery{
+		JSON:     []byte(`{"topic":"sensor/temperature","jsonPaths":[{"path":"$.value","type":"date"}]}`),
+		Interval: time.Second,
+	}, log.DefaultLogger)
+	require.EqualError(t, resp.Error, `invalid type "date" for path "$.value": must be number, string or boolean`)
+	require.Equal(t, backend.ErrorSourceDownstream, resp.ErrorSource)
+}
+
+func TestQuery_UnknownProtoMessage(t *testing.T) {
+	protos, err := mqtt.NewProtoRegistry("")
+	require.NoError(t, err)
+	ds := &MQTTDatasource{
+		Client:        &mockMQTTClient{},
+		channelPrefix: "ds/test-uid",
+		protos:        protos,
+	}
+
+	resp := ds.query(context.Background(), backend.DataQuery{
+		JSON:     []byte(`{"topic":"sensor/temperature","protoMessage":"acme.sensors.Reading"}`),
+		Interval: time.Second,
+	}, log.DefaultLogger)
+	require.EqualError(t, resp.Error, `protobuf message "acme.sensors.Reading": no protobuf descriptors are configured`)
+	require.Equal(t, backend.ErrorSourceDownstream, resp.ErrorSource)
+}
+
+func TestQuery_History(t *testing.T) {
+	now := time.Now()
+	ds := &MQTTDatasource{
+		Client: &mockMQTTClient{
+			history: map[string][]mqtt.Message{
+				"sensor/temperature": {
+					{Timestamp: now.Add(-2 * time.Hour), Value: []byte("19.5")},
+					{Timestamp: now.Add(-30 * time.Minute), Value: []byte("20.5")},
+					{Timestamp: now.Add(-time.Minute), Value: []byte("21.5")},
+				},
+			},
+		},
+		channelPrefix: "ds/test-uid",
+	}
+
+	resp := ds.query(context.Background(), backend.DataQuery{
+		JSON:      []byte(`{"topic":"sensor/temperature","streamingKey":"user1/hash123/org456"}`),
+		Interval:  time.Second,
+		TimeRange: backend.TimeRange{From: now.Add(-time.Hour), To: now},
+	}, log.DefaultLogger)
+	require.NoError(t, resp.Error)
+	require.Len(t, resp.Frames, 1)
+
+	frame := resp.Frames[0]
+	require.Equal(t, "ds/test-uid/1s/sensor/temperature/user1/hash123/org456", frame.Meta.Channel)
+	require.Equal(t, 2, frame.Rows())
+	require.Equal(t, 20.5, *frame.Fields[1].At(0).(*float64))
+	require.Equal(t, 21.5, *frame.Fields[1].At(1).(*float64))
+}
+
+func TestQuery_SplitByTopic(t *testing.T) {
+	now := time.Now()
+	ds := &MQTTDatasource{
+		Client: &mockMQTTClient{
+			history: map[string][]mqtt.Message{
+				"cGxhbnQvKy9wb3dlcg": {
+					{Timestamp: now.Add(-3 * time.Minute), Topic: "plant/b/power", Value: []byte(`{"value":1.5}`)},
+					{Timestamp: now.Add(-2 * time.Minute), Topic: "plant/a/power", Value: []byte(`{"value":2}`)},
+					{Timestamp: now.Add(-time.Minute), Topic: "plant/b/power", Value: []byte(`{"value":3.5}`)},
+				},
+			},
+		},
+		channelPrefix: "ds/test-uid",
+	}
+
+	// "cGxhbnQvKy9wb3dlcg" is the encoded "plant/+/power"
+	resp := ds.query(context.Background(), backend.DataQuery{
+		JSON:      []byte(`{"topic":"cGxhbnQvKy9wb3dlcg","splitByTopic":true,"wildcardLabels":["device"]}`),
+		Interval:  time.Second,
+		TimeRange: backend.TimeRange{From: now.Add(-time.Hour), To: now},
+	}, log.DefaultLogger)
+	require.NoError(t, resp.Error)
+
+	// the topics are combined into a frame with the labels of each row in the first
+	// field, like the frames of the stream of the query
+	require.Len(t, resp.Frames, 1)
+	frame := resp.Frames[0]
+	require.Equal(t, "labels", frame.Fields[0].Name)
+	require.Equal(t, 3, frame.Rows())
+	require.Equal(t, []string{"device=a", "device=b", "device=b"}, []string{
+		frame.Fields[0].At(0).(string), frame.Fields[0].At(1).(string), frame.Fields[0].At(2).(string),
+	})
+	require.Equal(t, 2.0, *frame.Fields[2].At(0).(*float64))
+}
+
+func TestQuery_SparkplugState(t *testing.T) {
+	client := &mockMQTTClient{
+		sparkplugStates: []mqtt.SparkplugState{
+			{Type: mqtt.SparkplugNode, GroupID: "plant", EdgeNodeID: "edge1", Online: true},
+		},
+	}
+	ds := &MQTTDatasource{
+		Client:        client,
+		channelPrefix: "ds/test-uid",
+	}
+
+	// the topic defaults to all the Sparkplug B topics
+	resp := ds.query(context.Background(), backend.DataQuery{
+		JSON:     []byte(`{"queryType":"sparkplugState","streamingKey":"user1/hash123/org456"}`),
+		Interval: time.Second,
+	}, log.DefaultLogger)
+	require.NoError(t, resp.Error)
+	require.Len(t, resp.Frames, 1)
+	require.Equal(t, "spBv1.0/#", client.sparkplugFilter)
+
+	frame := resp.Frames[0]
+	require.Equal(t, "sparkplug", frame.Name)
+	require.Equal(t, "ds/test-uid/1s/c3BCdjEuMC8j/user1/hash123/org456", frame.Meta.Channel)
+	require.Equal(t, 1, frame.Rows())
+	require.Equal(t, "edge1", frame.Fields[2].At(0))
+	require.Equal(t, true, frame.Fields[5].At(0))
+}
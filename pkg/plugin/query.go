@@ -2,47 +2,130 @@ package plugin
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"path"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 
 	"github.com/grafana/mqtt-datasource/pkg/mqtt"
 )
 
-func (ds *MQTTDatasource) QueryData(_ context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+func (ds *MQTTDatasource) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
 	response := backend.NewQueryDataResponse()
+	logger := log.DefaultLogger.FromContext(ctx)
 
 	for _, q := range req.Queries {
-		res := ds.query(q)
+		res := ds.query(ctx, q, logger)
 		response.Responses[q.RefID] = res
 	}
 
 	return response, nil
 }
 
-func (ds *MQTTDatasource) query(query backend.DataQuery) backend.DataResponse {
+// Query types of the query editor. Queries without a query type return the messages of the topic.
+const (
+	// queryTypeSparkplugState returns the state of the Sparkplug B host applications,
+	// edge nodes and devices that match the topic.
+	queryTypeSparkplugState = "sparkplugState"
+)
+
+// queryModel is the query sent by the query editor.
+type queryModel struct {
+	mqtt.Topic
+	QueryType string `json:"queryType,omitempty"`
+	// WaitForValue returns the latest value of the topic as a numeric frame, instead
+	// of the messages within the time range, waiting for a message if there is none.
+	WaitForValue bool `json:"waitForValue,omitempty"`
+	// WaitTimeout is how long to wait for a message, as a duration string.
+	WaitTimeout string `json:"waitTimeout,omitempty"`
+}
+
+func (ds *MQTTDatasource) query(ctx context.Context, query backend.DataQuery, logger log.Logger) backend.DataResponse {
 	var (
-		t        mqtt.Topic
+		qm       queryModel
 		response backend.DataResponse
 	)
 
-	if err := json.Unmarshal(query.JSON, &t); err != nil {
+	if err := json.Unmarshal(query.JSON, &qm); err != nil {
 		return backend.ErrorResponseWithErrorSource(backend.DownstreamErrorf("failed to unmarshal query: %w", err))
 	}
+	if qm.QueryType == queryTypeSparkplugState && qm.Path == "" {
+		qm.Path = base64.RawURLEncoding.EncodeToString([]byte(mqtt.SparkplugNamespace + "/#"))
+	}
 
-	if t.Path == "" {
+	if qm.Path == "" {
 		return backend.ErrorResponseWithErrorSource(backend.DownstreamErrorf("topic path is required"))
 	}
 
+	if err := ds.resolveQuery(&qm); err != nil {
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+	t := qm.Topic
+
+	if err := ds.checkTopic(t.Path); err != nil {
+		response = backend.ErrorResponseWithErrorSource(err)
+		response.Status = backend.StatusForbidden
+		return response
+	}
+
+	if qm.WaitForValue {
+		return ds.latestValue(ctx, qm, logger)
+	}
+
 	t.Interval = query.Interval
 
-	frame := data.NewFrame("")
-	frame.SetMeta(&data.FrameMeta{
-		Channel: path.Join(ds.channelPrefix, t.Key()),
-	})
+	if qm.QueryType == queryTypeSparkplugState {
+		return ds.sparkplugState(t, logger)
+	}
+
+	frame, err := ds.historyFrame(t, query.TimeRange, logger)
+	if err != nil {
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+	// the notices of the frame, such as the number of dropped values, are kept
+	if frame.Meta == nil {
+		frame.SetMeta(&data.FrameMeta{})
+	}
+	frame.Meta.Channel = path.Join(ds.channelPrefix, t.Key())
 
 	response.Frames = append(response.Frames, frame)
 	return response
 }
+
+// historyFrame converts the messages received on the topic within the time range to a frame.
+// The frame has the same schema as the frames sent by RunStream, so live updates are appended to it.
+func (ds *MQTTDatasource) historyFrame(t mqtt.Topic, timeRange backend.TimeRange, logger log.Logger) (*data.Frame, error) {
+	messages, err := ds.Client.History(t.Path, t.QoS, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	inRange := make([]mqtt.Message, 0, len(messages))
+	for _, message := range messages {
+		if message.Timestamp.Before(timeRange.From) || message.Timestamp.After(timeRange.To) {
+			continue
+		}
+		inRange = append(inRange, message)
+	}
+
+	topic := &mqtt.Topic{Path: t.Path, FrameOptions: t.FrameOptions}
+	return topic.MessagesToDataFrame(inRange, logger)
+}
+
+// resolveQuery returns an error if the QoS or the frame options of the query are invalid,
+// and resolves the protobuf message type of the query.
+func (ds *MQTTDatasource) resolveQuery(qm *queryModel) error {
+	if qm.QoS > 2 {
+		return backend.DownstreamErrorf("invalid QoS level %d: must be 0, 1 or 2", qm.QoS)
+	}
+	if err := qm.FrameOptions.Validate(); err != nil {
+		return backend.DownstreamError(err)
+	}
+	if err := qm.ResolveProtoMessage(ds.protos); err != nil {
+		return backend.DownstreamError(err)
+	}
+	return nil
+}
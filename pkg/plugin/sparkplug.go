@@ -0,0 +1,67 @@
+package plugin
+
+import (
+	"context"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/grafana/mqtt-datasource/pkg/mqtt"
+)
+
+// sparkplugState returns a table with the state of the Sparkplug B host applications,
+// edge nodes and devices that match the topic of the query. The table is updated
+// through the channel of the query when the states change.
+func (ds *MQTTDatasource) sparkplugState(t mqtt.Topic, logger log.Logger) backend.DataResponse {
+	filter, err := mqtt.DecodeTopic(t.Path)
+	if err != nil {
+		return backend.ErrorResponseWithErrorSource(backend.DownstreamErrorf("error decoding MQTT topic name %s: %w", t.Path, err))
+	}
+
+	// the states are tracked from the messages of the subscribed topics, so the topic is
+	// subscribed to for the history retention, if any, and else while it is streamed
+	if _, err := ds.Client.History(t.Path, t.QoS, logger); err != nil {
+		return backend.ErrorResponseWithErrorSource(err)
+	}
+
+	frame := mqtt.SparkplugStateFrame(ds.Client.SparkplugStates(filter))
+	frame.SetMeta(&data.FrameMeta{
+		Channel: path.Join(ds.channelPrefix, t.Key()),
+	})
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+// runSparkplugStateStream sends the state of the Sparkplug B entities that match the
+// topic every interval, if it changed since it was last sent.
+func (ds *MQTTDatasource) runSparkplugStateStream(ctx context.Context, topicKey string, filter string, interval time.Duration, sender *backend.StreamSender, logger log.Logger) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var sent []mqtt.SparkplugState
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Debug("stopped streaming Sparkplug state (context canceled)", "topicKey", topicKey)
+			return nil
+		case <-ticker.C:
+			// the messages are only used to track the states
+			if topic, ok := ds.Client.GetTopic(topicKey); ok {
+				topic.DiscardMessages()
+			}
+
+			states := ds.Client.SparkplugStates(filter)
+			if sent != nil && slices.Equal(states, sent) {
+				break
+			}
+			if err := sender.SendFrame(mqtt.SparkplugStateFrame(states), data.IncludeAll); err != nil {
+				logger.Error("failed to send data frame", "topicKey", topicKey, "error", backend.DownstreamError(err))
+				break
+			}
+			sent = states
+		}
+	}
+}
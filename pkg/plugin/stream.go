@@ -2,6 +2,7 @@ package plugin
 
 import (
 	"context"
+	"encoding/json"
 	"strings"
 	"time"
 
@@ -29,16 +30,34 @@ func (ds *MQTTDatasource) RunStream(ctx context.Context, req *backend.RunStreamR
 		return backend.DownstreamErrorf("invalid interval: %s", chunks[0])
 	}
 
-	_, err = ds.Client.Subscribe(topicKey, logger)
+	// the topic that is subscribed to is checked again, as the stream may outlive a change of the lists
+	if err := ds.checkTopic(chunks[1]); err != nil {
+		return err
+	}
+
+	query, err := ds.streamQuery(req.Data)
+	if err != nil {
+		return err
+	}
+	topic, err := ds.Client.Subscribe(topicKey, query.QoS, logger)
 	if err != nil {
 		return err
 	}
+	topic.FrameOptions = query.FrameOptions
 	defer func() {
 		if unsubErr := ds.Client.Unsubscribe(topicKey, logger); unsubErr != nil {
 			logger.Error("Failed to unsubscribe from MQTT topic", "topicKey", topicKey, "error", unsubErr)
 		}
 	}()
 
+	if query.QueryType == queryTypeSparkplugState {
+		filter, err := mqtt.DecodeTopic(topic.Path)
+		if err != nil {
+			return backend.DownstreamErrorf("error decoding MQTT topic name %s: %s", topic.Path, err)
+		}
+		return ds.runSparkplugStateStream(ctx, topicKey, filter, interval, sender, logger)
+	}
+
 	ticker := time.NewTicker(interval)
 
 	for {
@@ -58,7 +77,6 @@ func (ds *MQTTDatasource) RunStream(ctx context.Context, req *backend.RunStreamR
 				logger.Error("failed to convert topic to data frame", "path", req.Path, "error", backend.DownstreamError(err))
 				break
 			}
-			topic.Messages = []mqtt.Message{}
 			if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
 				logger.Error("failed to send data frame", "path", req.Path, "error", backend.DownstreamError(err))
 			}
@@ -67,11 +85,14 @@ func (ds *MQTTDatasource) RunStream(ctx context.Context, req *backend.RunStreamR
 	}
 }
 
+// streamPathSegments is the number of segments of the channel paths of streams.
+const streamPathSegments = 5
+
 func (ds *MQTTDatasource) SubscribeStream(ctx context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
 	// Extract orgId from the streaming key embedded in the channel path
 	// Channel: {interval}/{topic}/{datasourceUid}/{hash}/{orgId}
 	pathParts := strings.Split(req.Path, "/")
-	if len(pathParts) < 5 {
+	if len(pathParts) != streamPathSegments {
 		return &backend.SubscribeStreamResponse{
 			Status: backend.SubscribeStreamStatusNotFound,
 		}, backend.DownstreamErrorf("invalid channel path format")
@@ -85,13 +106,86 @@ func (ds *MQTTDatasource) SubscribeStream(ctx context.Context, req *backend.Subs
 		}, backend.DownstreamErrorf("invalid orgId supplied in request")
 	}
 
+	// The encoded topic follows the interval, and is the topic RunStream subscribes to
+	if err := ds.checkTopic(pathParts[1]); err != nil {
+		return &backend.SubscribeStreamResponse{
+			Status: backend.SubscribeStreamStatusPermissionDenied,
+		}, err
+	}
+
+	if _, err := ds.streamQuery(req.Data); err != nil {
+		return &backend.SubscribeStreamResponse{
+			Status: backend.SubscribeStreamStatusNotFound,
+		}, err
+	}
+
 	return &backend.SubscribeStreamResponse{
 		Status: backend.SubscribeStreamStatusOK,
 	}, nil
 }
 
-func (ds *MQTTDatasource) PublishStream(_ context.Context, _ *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+// streamQuery returns the query of a stream from the data of the subscription, which
+// holds the QoS, the query type and the frame options of the query that created the
+// channel. Grafana passes the data to the instance that runs the stream, so the options
+// don't depend on the instance that ran the query. Streams without data use the defaults.
+func (ds *MQTTDatasource) streamQuery(data json.RawMessage) (queryModel, error) {
+	var qm queryModel
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &qm); err != nil {
+			return qm, backend.DownstreamErrorf("failed to unmarshal stream options: %w", err)
+		}
+	}
+	if err := ds.resolveQuery(&qm); err != nil {
+		return qm, err
+	}
+	return qm, nil
+}
+
+// publishPathPrefix is the prefix of the channel paths that publish to MQTT topics.
+// The rest of the path is the topic, so "publish/plant/line1/setpoint" publishes
+// to the "plant/line1/setpoint" topic.
+const publishPathPrefix = "publish/"
+
+func (ds *MQTTDatasource) PublishStream(ctx context.Context, req *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	logger := log.DefaultLogger.FromContext(ctx)
+
+	topic, ok := strings.CutPrefix(req.Path, publishPathPrefix)
+	if !ok {
+		return &backend.PublishStreamResponse{
+			Status: backend.PublishStreamStatusNotFound,
+		}, nil
+	}
+
+	if !ds.publish.CanPublish(topic) {
+		logger.Warn("Publishing to MQTT topic denied", "topic", topic)
+		return &backend.PublishStreamResponse{
+			Status: backend.PublishStreamStatusPermissionDenied,
+		}, nil
+	}
+
+	if err := ds.acl.Check(topic); err != nil {
+		logger.Warn("Publishing to MQTT topic denied", "topic", topic, "reason", err)
+		return &backend.PublishStreamResponse{
+			Status: backend.PublishStreamStatusPermissionDenied,
+		}, backend.DownstreamErrorf("permission denied: %w", err)
+	}
+
+	if err := ds.Client.Publish(topic, publishPayload(req.Data), ds.publish.PublishQoS, ds.publish.PublishRetain, logger); err != nil {
+		return nil, err
+	}
+
 	return &backend.PublishStreamResponse{
-		Status: backend.PublishStreamStatusPermissionDenied,
+		Status: backend.PublishStreamStatusOK,
 	}, nil
 }
+
+// publishPayload returns the payload of the MQTT message for the data published to the channel.
+// JSON strings are published as plain text, so a command such as "on" can be sent as is,
+// and any other JSON value is published as JSON.
+func publishPayload(data json.RawMessage) []byte {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		return []byte(text)
+	}
+	return data
+}
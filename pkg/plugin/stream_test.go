@@ -3,8 +3,12 @@ package plugin
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/mqtt-datasource/pkg/mqtt"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMQTTDatasource_SubscribeStream_Security(t *testing.T) {
@@ -19,14 +23,14 @@ func TestMQTTDatasource_SubscribeStream_Security(t *testing.T) {
 	}{
 		{
 			name:           "valid namespace matches",
-			requestPath:    "ds/uid123/1s/sensor/temp/datasource-uid/hash123/stacks-456",
+			requestPath:    "1s/c2Vuc29yL3RlbXA/datasource-uid/hash123/stacks-456",
 			userNamespace:  "stacks-456",
 			expectedStatus: backend.SubscribeStreamStatusOK,
 			expectError:    false,
 		},
 		{
 			name:           "invalid namespace mismatch",
-			requestPath:    "ds/uid123/1s/sensor/temp/datasource-uid/hash123/stacks-456",
+			requestPath:    "1s/c2Vuc29yL3RlbXA/datasource-uid/hash123/stacks-456",
 			userNamespace:  "stacks-789",
 			expectedStatus: backend.SubscribeStreamStatusPermissionDenied,
 			expectError:    true,
@@ -40,7 +44,7 @@ func TestMQTTDatasource_SubscribeStream_Security(t *testing.T) {
 		},
 		{
 			name:           "different user same namespace - should work",
-			requestPath:    "ds/uid123/1s/sensor/temp/datasource-uid/different-hash/stacks-456",
+			requestPath:    "1s/c2Vuc29yL3RlbXA/datasource-uid/different-hash/stacks-456",
 			userNamespace:  "stacks-456",
 			expectedStatus: backend.SubscribeStreamStatusOK,
 			expectError:    false,
@@ -88,17 +92,17 @@ func TestMQTTDatasource_SubscribeStream_PathParsing(t *testing.T) {
 	}{
 		{
 			name:              "simple streaming key",
-			requestPath:       "ds/uid123/1s/sensor/temp/datasource-uid/hash123/stacks-456",
+			requestPath:       "1s/c2Vuc29yL3RlbXA/datasource-uid/hash123/stacks-456",
 			expectedNamespace: "stacks-456",
 		},
 		{
 			name:              "complex topic path",
-			requestPath:       "ds/uid123/5s/building/floor1/room2/sensor/temp/datasource-uid/hash456/stacks-789",
+			requestPath:       "5s/YnVpbGRpbmcvZmxvb3IxL3Jvb20yL3NlbnNvci90ZW1w/datasource-uid/hash456/stacks-789",
 			expectedNamespace: "stacks-789",
 		},
 		{
 			name:              "streaming key with multiple segments",
-			requestPath:       "ds/uid123/10s/simple/topic/my-datasource/complex-hash-value/stacks-123",
+			requestPath:       "10s/c2ltcGxlL3RvcGlj/my-datasource/complex-hash-value/stacks-123",
 			expectedNamespace: "stacks-123",
 		},
 	}
@@ -152,10 +156,10 @@ func TestMQTTDatasource_SubscribeStream_EdgeCases(t *testing.T) {
 			expectedStatus: backend.SubscribeStreamStatusNotFound,
 		},
 		{
-			name:           "very long path",
-			requestPath:    "ds/uid123/1s/very/long/topic/path/with/many/segments/datasource-uid/hash123/stacks-456",
+			name:           "path with extra segments",
+			requestPath:    "1s/very/long/topic/path/with/many/segments/datasource-uid/hash123/stacks-456",
 			userNamespace:  "stacks-456",
-			expectedStatus: backend.SubscribeStreamStatusOK,
+			expectedStatus: backend.SubscribeStreamStatusNotFound,
 		},
 	}
 
@@ -184,7 +188,7 @@ func TestMQTTDatasource_SubscribeStream_MultiTenantSecurity(t *testing.T) {
 	ds := &MQTTDatasource{}
 
 	// Same topic, same streaming key structure, but different orgs
-	basePath := "ds/uid123/1s/sensor/temp/datasource-uid/hash123/"
+	basePath := "1s/c2Vuc29yL3RlbXA/datasource-uid/hash123/"
 
 	// User from namespace stacks-456 tries to access their own data - should work
 	pCtx456 := backend.PluginContext{Namespace: "stacks-456"}
@@ -222,3 +226,192 @@ func TestMQTTDatasource_SubscribeStream_MultiTenantSecurity(t *testing.T) {
 		t.Errorf("Expected OK status for valid org access, got: %v", resp789Own.Status)
 	}
 }
+
+func TestMQTTDatasource_StreamOptions(t *testing.T) {
+	ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{Namespace: "stacks-456"})
+	path := "1s/c2Vuc29yL3RlbXA/datasource-uid/hash123/stacks-456"
+
+	t.Run("options of the subscription", func(t *testing.T) {
+		ds := &MQTTDatasource{}
+		query, err := ds.streamQuery([]byte(`{"queryType":"sparkplugState","qos":2,"splitByTopic":true,"payloadFormat":"json"}`))
+		require.NoError(t, err)
+		require.Equal(t, queryTypeSparkplugState, query.QueryType)
+		require.Equal(t, byte(2), query.QoS)
+		require.True(t, query.SplitByTopic)
+		require.Equal(t, mqtt.PayloadFormatJSON, query.PayloadFormat)
+	})
+
+	t.Run("subscriptions without data use the defaults", func(t *testing.T) {
+		ds := &MQTTDatasource{}
+		query, err := ds.streamQuery(nil)
+		require.NoError(t, err)
+		require.Equal(t, queryModel{}, query)
+	})
+
+	t.Run("invalid options are rejected", func(t *testing.T) {
+		ds := &MQTTDatasource{}
+		resp, err := ds.SubscribeStream(ctx, &backend.SubscribeStreamRequest{Path: path, Data: []byte(`{"qos":3}`)})
+		require.EqualError(t, err, "invalid QoS level 3: must be 0, 1 or 2")
+		require.Equal(t, backend.SubscribeStreamStatusNotFound, resp.Status)
+
+		resp, err = ds.SubscribeStream(ctx, &backend.SubscribeStreamRequest{Path: path, Data: []byte(`{"payloadFormat":"xml"}`)})
+		require.Error(t, err)
+		require.Equal(t, backend.SubscribeStreamStatusNotFound, resp.Status)
+	})
+
+	t.Run("stream uses the options", func(t *testing.T) {
+		topicKey := "1s/dGVzdC90b3BpYw/datasource-uid/hash123/stacks-456"
+		topic := &mqtt.Topic{Path: "dGVzdC90b3BpYw", Interval: time.Second}
+		client := &mockMQTTClient{
+			topics:        map[string]*mqtt.Topic{topicKey: topic},
+			subscriptions: make(map[string]bool),
+		}
+		ds := NewMQTTDatasource(client, "uid123")
+
+		// a stream on another instance than the query still gets the options
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		err := ds.RunStream(ctx, &backend.RunStreamRequest{
+			Path: topicKey,
+			Data: []byte(`{"qos":1,"jsonSeparator":"_"}`),
+		}, nil)
+		require.NoError(t, err)
+		require.Equal(t, byte(1), client.subscribedQoS)
+		require.Equal(t, "_", topic.JSONSeparator)
+	})
+}
+
+func TestMQTTDatasource_PublishStream(t *testing.T) {
+	client := &mockMQTTClient{}
+	ds := &MQTTDatasource{
+		Client: client,
+		publish: mqtt.PublishOptions{
+			PublishEnabled:       true,
+			PublishAllowedTopics: []string{"plant/+/setpoint"},
+		},
+	}
+
+	publish := func(path string, data string) backend.PublishStreamStatus {
+		t.Helper()
+		resp, err := ds.PublishStream(context.Background(), &backend.PublishStreamRequest{
+			Path: path,
+			Data: []byte(data),
+		})
+		require.NoError(t, err)
+		return resp.Status
+	}
+
+	t.Run("allowed topic", func(t *testing.T) {
+		require.Equal(t, backend.PublishStreamStatusOK, publish("publish/plant/line1/setpoint", `21.5`))
+		require.Equal(t, backend.PublishStreamStatusOK, publish("publish/plant/line2/setpoint", `"on"`))
+		require.Equal(t, backend.PublishStreamStatusOK, publish("publish/plant/line3/setpoint", `{"value":20}`))
+
+		require.Len(t, client.published, 3)
+		require.Equal(t, "plant/line1/setpoint", client.published[0].Topic)
+		require.Equal(t, "21.5", string(client.published[0].Value))
+		require.Equal(t, "on", string(client.published[1].Value))
+		require.Equal(t, `{"value":20}`, string(client.published[2].Value))
+	})
+
+	t.Run("topic not allowed", func(t *testing.T) {
+		require.Equal(t, backend.PublishStreamStatusPermissionDenied, publish("publish/plant/line1/temperature", `21.5`))
+	})
+
+	t.Run("not a publish channel", func(t *testing.T) {
+		require.Equal(t, backend.PublishStreamStatusNotFound, publish("1s/plant/line1/setpoint", `21.5`))
+	})
+
+	t.Run("publishing disabled", func(t *testing.T) {
+		ds := &MQTTDatasource{Client: client}
+		resp, err := ds.PublishStream(context.Background(), &backend.PublishStreamRequest{
+			Path: "publish/plant/line1/setpoint",
+			Data: []byte(`21.5`),
+		})
+		require.NoError(t, err)
+		require.Equal(t, backend.PublishStreamStatusPermissionDenied, resp.Status)
+	})
+}
+
+func TestMQTTDatasource_TopicACL(t *testing.T) {
+	ds := &MQTTDatasource{
+		Client:        &mockMQTTClient{},
+		channelPrefix: "ds/test-uid",
+		publish: mqtt.PublishOptions{
+			PublishEnabled:       true,
+			PublishAllowedTopics: []string{"#"},
+		},
+		acl: mqtt.TopicACL{
+			AllowedTopics: []string{"sensors/#", "plant/+/setpoint"},
+			DeniedTopics:  []string{"$SYS/#", "plant/secret/#"},
+		},
+	}
+	ctx := backend.WithPluginContext(context.Background(), backend.PluginContext{})
+
+	subscribe := func(topicPath string) (*backend.SubscribeStreamResponse, error) {
+		return ds.SubscribeStream(ctx, &backend.SubscribeStreamRequest{
+			Path: "1s/" + topicPath + "/datasource-uid/hash123/",
+		})
+	}
+
+	t.Run("subscribe to allowed topic", func(t *testing.T) {
+		// "c2Vuc29ycy9hL3RlbXBlcmF0dXJl" is the encoded "sensors/a/temperature"
+		resp, err := subscribe("c2Vuc29ycy9hL3RlbXBlcmF0dXJl")
+		require.NoError(t, err)
+		require.Equal(t, backend.SubscribeStreamStatusOK, resp.Status)
+	})
+
+	t.Run("subscribe to denied topic", func(t *testing.T) {
+		// "JFNZUy9icm9rZXIvdXB0aW1l" is the encoded "$SYS/broker/uptime"
+		resp, err := subscribe("JFNZUy9icm9rZXIvdXB0aW1l")
+		require.EqualError(t, err, `permission denied: topic "$SYS/broker/uptime" is denied by the pattern "$SYS/#"`)
+		require.Equal(t, backend.SubscribeStreamStatusPermissionDenied, resp.Status)
+	})
+
+	t.Run("subscribe to denied topic followed by allowed topic", func(t *testing.T) {
+		// the denied topic is the one that is subscribed to, so the allowed topic in
+		// the position of the streaming key must not pass the check
+		resp, err := ds.SubscribeStream(ctx, &backend.SubscribeStreamRequest{
+			Path: "1s/JFNZUy9icm9rZXIvdXB0aW1l/c2Vuc29ycy9hL3RlbXBlcmF0dXJl/datasource-uid/hash123/",
+		})
+		require.EqualError(t, err, "invalid channel path format")
+		require.Equal(t, backend.SubscribeStreamStatusNotFound, resp.Status)
+
+		resp, err = ds.SubscribeStream(ctx, &backend.SubscribeStreamRequest{
+			Path: "1s/JFNZUy9icm9rZXIvdXB0aW1l/c2Vuc29ycy9hL3RlbXBlcmF0dXJl/hash123/",
+		})
+		require.EqualError(t, err, `permission denied: topic "$SYS/broker/uptime" is denied by the pattern "$SYS/#"`)
+		require.Equal(t, backend.SubscribeStreamStatusPermissionDenied, resp.Status)
+	})
+
+	t.Run("run stream of denied topic", func(t *testing.T) {
+		err := ds.RunStream(ctx, &backend.RunStreamRequest{
+			Path: "1s/JFNZUy9icm9rZXIvdXB0aW1l/c2Vuc29ycy9hL3RlbXBlcmF0dXJl/hash123/",
+		}, nil)
+		require.EqualError(t, err, `permission denied: topic "$SYS/broker/uptime" is denied by the pattern "$SYS/#"`)
+	})
+
+	t.Run("subscribe to topic that is not allowed", func(t *testing.T) {
+		// "ZGV2aWNlcy8j" is the encoded "devices/#"
+		resp, err := subscribe("ZGV2aWNlcy8j")
+		require.EqualError(t, err, `permission denied: topic "devices/#" is not allowed by any of the allowed topic patterns`)
+		require.Equal(t, backend.SubscribeStreamStatusPermissionDenied, resp.Status)
+	})
+
+	t.Run("query denied topic", func(t *testing.T) {
+		resp := ds.query(ctx, backend.DataQuery{
+			JSON:     []byte(`{"topic":"JFNZUy9icm9rZXIvdXB0aW1l"}`),
+			Interval: time.Second,
+		}, log.DefaultLogger)
+		require.EqualError(t, resp.Error, `permission denied: topic "$SYS/broker/uptime" is denied by the pattern "$SYS/#"`)
+		require.Equal(t, backend.StatusForbidden, resp.Status)
+	})
+
+	t.Run("publish to denied topic", func(t *testing.T) {
+		resp, err := ds.PublishStream(ctx, &backend.PublishStreamRequest{
+			Path: "publish/plant/secret/setpoint",
+			Data: []byte(`21.5`),
+		})
+		require.EqualError(t, err, `permission denied: topic "plant/secret/setpoint" is denied by the pattern "plant/secret/#"`)
+		require.Equal(t, backend.PublishStreamStatusPermissionDenied, resp.Status)
+	})
+}
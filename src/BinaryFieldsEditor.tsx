@@ -0,0 +1,125 @@
+import React from 'react';
+import { Button, IconButton, InlineField, InlineFieldRow, Input, RadioButtonGroup, Select } from '@grafana/ui';
+import { SelectableValue } from '@grafana/data';
+import { BinaryField } from './types';
+
+const typeOptions: Array<SelectableValue<string>> = [
+  'int8',
+  'uint8',
+  'int16',
+  'uint16',
+  'int32',
+  'uint32',
+  'int64',
+  'uint64',
+  'float32',
+  'float64',
+  'bool',
+].map((type) => ({ label: type, value: type }));
+
+type Endianness = NonNullable<BinaryField['endianness']>;
+
+const endiannessOptions: Array<SelectableValue<Endianness>> = [
+  { label: 'Big', value: 'big' },
+  { label: 'Little', value: 'little' },
+];
+
+interface Props {
+  fields: BinaryField[];
+  onChange: (fields: BinaryField[]) => void;
+  onRunQuery: () => void;
+}
+
+export const BinaryFieldsEditor = ({ fields, onChange, onRunQuery }: Props) => {
+  const updateField = (index: number, update: Partial<BinaryField>) => {
+    onChange(fields.map((f, i) => (i === index ? { ...f, ...update } : f)));
+  };
+
+  const removeField = (index: number) => {
+    onChange(fields.filter((_, i) => i !== index));
+    onRunQuery();
+  };
+
+  return (
+    <>
+      <InlineFieldRow>
+        <InlineField
+          label="Binary fields"
+          labelWidth={16}
+          tooltip="Values at fixed positions of the payloads, each converted to a field"
+        >
+          <Button
+            variant="secondary"
+            icon="plus"
+            aria-label="Add field"
+            onClick={() => onChange([...fields, { name: '', type: 'uint8' }])}
+          >
+            Add field
+          </Button>
+        </InlineField>
+      </InlineFieldRow>
+      {fields.map((f, index) => (
+        <InlineFieldRow key={index}>
+          <InlineField label="Name" labelWidth={16}>
+            <Input
+              placeholder="Field name"
+              width={20}
+              value={f.name}
+              onBlur={onRunQuery}
+              onChange={(e) => updateField(index, { name: e.currentTarget.value })}
+            />
+          </InlineField>
+          <InlineField label="Offset" labelWidth={8} tooltip="Position of the first byte of the value">
+            <Input
+              type="number"
+              min={0}
+              placeholder="0"
+              width={8}
+              value={f.offset ?? ''}
+              onBlur={onRunQuery}
+              onChange={(e) => {
+                const offset = parseInt(e.currentTarget.value, 10);
+                updateField(index, { offset: isNaN(offset) ? undefined : offset });
+              }}
+            />
+          </InlineField>
+          <InlineField label="Type" labelWidth={8}>
+            <Select
+              width={12}
+              options={typeOptions}
+              value={f.type}
+              onChange={(v) => {
+                updateField(index, { type: v.value! });
+                onRunQuery();
+              }}
+            />
+          </InlineField>
+          <InlineField label="Byte order" labelWidth={12}>
+            <RadioButtonGroup
+              options={endiannessOptions}
+              value={f.endianness ?? 'big'}
+              onChange={(endianness) => {
+                updateField(index, { endianness: endianness === 'big' ? undefined : endianness });
+                onRunQuery();
+              }}
+            />
+          </InlineField>
+          <InlineField label="Scale" labelWidth={8} tooltip="Factor the value is multiplied by, such as 0.1 for tenths">
+            <Input
+              type="number"
+              placeholder="1"
+              width={10}
+              value={f.scale ?? ''}
+              onBlur={onRunQuery}
+              onChange={(e) => {
+                const scale = parseFloat(e.currentTarget.value);
+                updateField(index, { scale: isNaN(scale) ? undefined : scale });
+              }}
+            />
+          </InlineField>
+          <IconButton name="trash-alt" aria-label="Remove field" onClick={() => removeField(index)} />
+        </InlineFieldRow>
+      ))}
+    </>
+  );
+};
@@ -0,0 +1,74 @@
+import React from 'react';
+import { Button, IconButton, InlineField, InlineFieldRow, Input, Select } from '@grafana/ui';
+import { SelectableValue } from '@grafana/data';
+import { CSVColumn } from './types';
+
+const typeOptions: Array<SelectableValue<string>> = [
+  { label: 'Auto', value: '' },
+  { label: 'Number', value: 'number' },
+  { label: 'String', value: 'string' },
+  { label: 'Boolean', value: 'boolean' },
+];
+
+interface Props {
+  columns: CSVColumn[];
+  onChange: (columns: CSVColumn[]) => void;
+  onRunQuery: () => void;
+}
+
+export const CSVColumnsEditor = ({ columns, onChange, onRunQuery }: Props) => {
+  const updateColumn = (index: number, update: Partial<CSVColumn>) => {
+    onChange(columns.map((c, i) => (i === index ? { ...c, ...update } : c)));
+  };
+
+  const removeColumn = (index: number) => {
+    onChange(columns.filter((_, i) => i !== index));
+    onRunQuery();
+  };
+
+  return (
+    <>
+      <InlineFieldRow>
+        <InlineField
+          label="Columns"
+          labelWidth={16}
+          tooltip="Names and types of the columns, in order. Columns without a name are named by the header row, or 'Column <n>'. Columns without a type are inferred."
+        >
+          <Button
+            variant="secondary"
+            icon="plus"
+            aria-label="Add column"
+            onClick={() => onChange([...columns, {}])}
+          >
+            Add column
+          </Button>
+        </InlineField>
+      </InlineFieldRow>
+      {columns.map((c, index) => (
+        <InlineFieldRow key={index}>
+          <InlineField label={`Column ${index + 1}`} labelWidth={16}>
+            <Input
+              placeholder="Field name"
+              width={24}
+              value={c.name ?? ''}
+              onBlur={onRunQuery}
+              onChange={(e) => updateColumn(index, { name: e.currentTarget.value || undefined })}
+            />
+          </InlineField>
+          <InlineField label="Type" labelWidth={8}>
+            <Select
+              width={14}
+              options={typeOptions}
+              value={c.type ?? ''}
+              onChange={(v) => {
+                updateColumn(index, { type: v.value || undefined });
+                onRunQuery();
+              }}
+            />
+          </InlineField>
+          <IconButton name="trash-alt" aria-label="Remove column" onClick={() => removeColumn(index)} />
+        </InlineFieldRow>
+      ))}
+    </>
+  );
+};
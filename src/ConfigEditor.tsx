@@ -2,18 +2,50 @@ import React, { SyntheticEvent } from 'react';
 
 import {
   DataSourcePluginOptionsEditorProps,
+  SelectableValue,
   onUpdateDatasourceJsonDataOption,
   onUpdateDatasourceSecureJsonDataOption,
   updateDatasourcePluginJsonDataOption,
   updateDatasourcePluginResetOption,
 } from '@grafana/data';
 import { ConfigSection, DataSourceDescription } from '@grafana/plugin-ui';
-import { Field, Input, SecretInput, SecureSocksProxySettings, Switch } from '@grafana/ui';
+import {
+  Field,
+  FileUpload,
+  Input,
+  RadioButtonGroup,
+  SecretInput,
+  Select,
+  SecureSocksProxySettings,
+  Switch,
+  TagsInput,
+  TextArea,
+} from '@grafana/ui';
 import { Divider } from './Divider';
 import { TLSSecretsConfig } from './TLSConfig';
-import { MqttDataSourceOptions, MqttSecureJsonData } from './types';
+import { Compression, MqttDataSourceOptions, MqttSecureJsonData } from './types';
 import { config } from '@grafana/runtime';
 
+const protocolVersionOptions: Array<SelectableValue<number>> = [
+  { label: '3.1.1', value: 4 },
+  { label: '5', value: 5 },
+];
+
+const compressionOptions: Array<SelectableValue<Compression | ''>> = [
+  { label: 'None', value: '' },
+  { label: 'Auto', value: 'auto', description: 'Detects gzip, zlib, zstd and framed snappy payloads' },
+  { label: 'gzip', value: 'gzip' },
+  { label: 'zlib', value: 'zlib' },
+  { label: 'zstd', value: 'zstd' },
+  { label: 'snappy', value: 'snappy' },
+];
+
+const qosOptions: Array<SelectableValue<number>> = [
+  { label: '0', value: 0, description: 'At most once' },
+  { label: '1', value: 1, description: 'At least once' },
+  { label: '2', value: 2, description: 'Exactly once' },
+];
+
 export const ConfigEditor = (props: DataSourcePluginOptionsEditorProps<MqttDataSourceOptions, MqttSecureJsonData>) => {
   const { options, onOptionsChange } = props;
   const jsonData = options.jsonData;
@@ -28,6 +60,24 @@ export const ConfigEditor = (props: DataSourcePluginOptionsEditorProps<MqttDataS
     };
   };
 
+  const onBufferLimitChanged = (
+    property: 'maxBufferedMessages' | 'maxBufferedBytes' | 'maxDecompressedBytes',
+    value: string
+  ) => {
+    updateDatasourcePluginJsonDataOption(props, property, value === '' ? undefined : Number(value));
+  };
+
+  const onProtoDescriptorsUpload = async (event: React.FormEvent<HTMLInputElement>) => {
+    const file = event.currentTarget.files?.[0];
+    if (!file) {
+      return;
+    }
+    const bytes = new Uint8Array(await file.arrayBuffer());
+    let binary = '';
+    bytes.forEach((b) => (binary += String.fromCharCode(b)));
+    updateDatasourcePluginJsonDataOption(props, 'protoDescriptors', btoa(binary));
+  };
+
   const WIDTH_LONG = 40;
 
   return (
@@ -51,6 +101,14 @@ export const ConfigEditor = (props: DataSourcePluginOptionsEditorProps<MqttDataS
             placeholder="TCP (tcp://), TLS (tls://), or WebSocket (ws://)"
           />
         </Field>
+
+        <Field label="Protocol version" description="The MQTT protocol version used to connect to the broker.">
+          <RadioButtonGroup
+            options={protocolVersionOptions}
+            value={jsonData.protocolVersion || 4}
+            onChange={(value) => updateDatasourcePluginJsonDataOption(props, 'protocolVersion', value)}
+          />
+        </Field>
       </ConfigSection>
 
       <Field label="Client ID" description="If not set, a random client ID is used.">
@@ -65,6 +123,183 @@ export const ConfigEditor = (props: DataSourcePluginOptionsEditorProps<MqttDataS
 
       <Divider />
 
+      <ConfigSection
+        title="Message buffer"
+        description="Limits of the messages buffered per query between two updates. When a limit is exceeded, the oldest messages are dropped."
+        isCollapsible
+        isInitiallyOpen={false}
+      >
+        <Field label="Max messages" description="The maximum number of buffered messages. Defaults to 10000.">
+          <Input
+            width={WIDTH_LONG}
+            name="Max messages"
+            type="number"
+            min={1}
+            value={jsonData.maxBufferedMessages ?? ''}
+            placeholder="10000"
+            onChange={(e) => onBufferLimitChanged('maxBufferedMessages', e.currentTarget.value)}
+          />
+        </Field>
+
+        <Field label="Max bytes" description="The maximum size of the buffered messages in bytes. Defaults to 16777216 (16 MiB).">
+          <Input
+            width={WIDTH_LONG}
+            name="Max bytes"
+            type="number"
+            min={1}
+            value={jsonData.maxBufferedBytes ?? ''}
+            placeholder="16777216"
+            onChange={(e) => onBufferLimitChanged('maxBufferedBytes', e.currentTarget.value)}
+          />
+        </Field>
+
+        <Field
+          label="History retention"
+          description='How long received messages are kept to answer queries, such as "30m" or "1h". Defaults to "1h". Set to "0" to disable the history.'
+        >
+          <Input
+            width={WIDTH_LONG}
+            name="History retention"
+            type="text"
+            value={jsonData.historyRetention || ''}
+            placeholder="1h"
+            onChange={onUpdateDatasourceJsonDataOption(props, 'historyRetention')}
+          />
+        </Field>
+      </ConfigSection>
+
+      <Divider />
+
+      <ConfigSection
+        title="Topic access"
+        description='Restrict the topics that can be queried, streamed and published to. Wildcards are supported, for example "plant/+/temperature".'
+        isCollapsible
+        isInitiallyOpen={Boolean(jsonData.allowedTopics?.length || jsonData.deniedTopics?.length)}
+      >
+        <Field label="Allowed topics" description="Topics that can be used. All topics can be used if empty.">
+          <TagsInput
+            width={WIDTH_LONG}
+            tags={jsonData.allowedTopics || []}
+            placeholder="Add topic and press Enter"
+            onChange={(topics) => updateDatasourcePluginJsonDataOption(props, 'allowedTopics', topics)}
+          />
+        </Field>
+
+        <Field label="Denied topics" description="Topics that can't be used, even if they are allowed.">
+          <TagsInput
+            width={WIDTH_LONG}
+            tags={jsonData.deniedTopics || []}
+            placeholder="Add topic and press Enter"
+            onChange={(topics) => updateDatasourcePluginJsonDataOption(props, 'deniedTopics', topics)}
+          />
+        </Field>
+      </ConfigSection>
+
+      <Divider />
+
+      <ConfigSection
+        title="Publishing"
+        description="Allow publishing messages to MQTT topics through Grafana Live, for example to send setpoints and commands from dashboards."
+        isCollapsible
+        isInitiallyOpen={jsonData.publishEnabled ?? false}
+      >
+        <Field label="Enable publishing">
+          <Switch onChange={onSwitchChanged('publishEnabled')} value={jsonData.publishEnabled || false} />
+        </Field>
+
+        {jsonData.publishEnabled && (
+          <>
+            <Field
+              label="Allowed topics"
+              description='Topics that can be published to. Wildcards are supported, for example "plant/+/setpoint". No topics can be published to if empty.'
+            >
+              <TagsInput
+                width={WIDTH_LONG}
+                tags={jsonData.publishAllowedTopics || []}
+                placeholder="Add topic and press Enter"
+                onChange={(topics) => updateDatasourcePluginJsonDataOption(props, 'publishAllowedTopics', topics)}
+              />
+            </Field>
+
+            <Field label="QoS" description="The quality of service level of the published messages.">
+              <RadioButtonGroup
+                options={qosOptions}
+                value={jsonData.publishQoS ?? 0}
+                onChange={(value) => updateDatasourcePluginJsonDataOption(props, 'publishQoS', value)}
+              />
+            </Field>
+
+            <Field label="Retain" description="When enabled, the broker retains the last published message of each topic.">
+              <Switch onChange={onSwitchChanged('publishRetain')} value={jsonData.publishRetain || false} />
+            </Field>
+          </>
+        )}
+      </ConfigSection>
+
+      <Divider />
+
+      <ConfigSection
+        title="Protobuf"
+        description="Message types that queries can decode protobuf payloads with."
+        isCollapsible
+        isInitiallyOpen={Boolean(jsonData.protoDescriptors)}
+      >
+        <Field
+          label="Descriptor set"
+          description='Base64 encoded FileDescriptorSet, as generated by "protoc --include_imports --descriptor_set_out=descriptors.pb". Upload the file or paste its base64 encoding.'
+        >
+          <TextArea
+            rows={4}
+            value={jsonData.protoDescriptors || ''}
+            placeholder="CpUBCh9nb29nbGUvcHJvdG9idWYvdGltZXN0YW1wLnByb3Rv..."
+            onChange={(e) =>
+              updateDatasourcePluginJsonDataOption(props, 'protoDescriptors', e.currentTarget.value || undefined)
+            }
+          />
+        </Field>
+        <FileUpload accept=".pb,.desc,.protoset,.bin" size="sm" onFileUpload={onProtoDescriptorsUpload}>
+          Upload descriptor set
+        </FileUpload>
+      </ConfigSection>
+
+      <Divider />
+
+      <ConfigSection
+        title="Compression"
+        description="Decompress the payloads of received messages before they are decoded."
+        isCollapsible
+        isInitiallyOpen={Boolean(jsonData.compression)}
+      >
+        <Field
+          label="Compression"
+          description="The compression of the payloads. Auto detects compressed payloads by their header, and keeps other payloads as they are."
+        >
+          <Select
+            width={WIDTH_LONG}
+            options={compressionOptions}
+            value={jsonData.compression ?? ''}
+            onChange={(v) => updateDatasourcePluginJsonDataOption(props, 'compression', v.value || undefined)}
+          />
+        </Field>
+
+        <Field
+          label="Max decompressed bytes"
+          description="The maximum size of decompressed payloads in bytes. Larger messages are dropped. Defaults to 16777216 (16 MiB)."
+        >
+          <Input
+            width={WIDTH_LONG}
+            name="Max decompressed bytes"
+            type="number"
+            min={1}
+            value={jsonData.maxDecompressedBytes ?? ''}
+            placeholder="16777216"
+            onChange={(e) => onBufferLimitChanged('maxDecompressedBytes', e.currentTarget.value)}
+          />
+        </Field>
+      </ConfigSection>
+
+      <Divider />
+
       <ConfigSection title="Authentication">
         <Field label="Username">
           <Input
@@ -0,0 +1,119 @@
+import React from 'react';
+import { Button, IconButton, InlineField, InlineFieldRow, Input, RadioButtonGroup, Select } from '@grafana/ui';
+import { SelectableValue } from '@grafana/data';
+import { FieldTypeOverride, MqttQuery } from './types';
+
+type TypeConversion = NonNullable<MqttQuery['typeConversion']>;
+
+const typeOptions: Array<SelectableValue<string>> = [
+  { label: 'Number', value: 'number' },
+  { label: 'String', value: 'string' },
+  { label: 'Boolean', value: 'boolean' },
+  { label: 'Time', value: 'time' },
+];
+
+const timeFormatOptions: Array<SelectableValue<string>> = [
+  { label: 'Auto', value: '', description: 'RFC 3339 strings and epoch milliseconds' },
+  { label: 'RFC 3339', value: 'rfc3339' },
+  { label: 'Epoch seconds', value: 'epoch_s' },
+  { label: 'Epoch milliseconds', value: 'epoch_ms' },
+  { label: 'Epoch microseconds', value: 'epoch_us' },
+  { label: 'Epoch nanoseconds', value: 'epoch_ns' },
+];
+
+const typeConversionOptions: Array<SelectableValue<TypeConversion>> = [
+  { label: 'Drop', value: 'drop', description: 'Drop the values' },
+  { label: 'Convert', value: 'convert', description: 'Convert the values to the type of their field' },
+];
+
+interface Props {
+  fieldTypes: FieldTypeOverride[];
+  typeConversion?: TypeConversion;
+  onChange: (fieldTypes: FieldTypeOverride[], typeConversion?: TypeConversion) => void;
+  onRunQuery: () => void;
+}
+
+export const FieldTypesEditor = ({ fieldTypes, typeConversion, onChange, onRunQuery }: Props) => {
+  const updateFieldType = (index: number, update: Partial<FieldTypeOverride>) => {
+    onChange(
+      fieldTypes.map((t, i) => (i === index ? { ...t, ...update } : t)),
+      typeConversion
+    );
+  };
+
+  const removeFieldType = (index: number) => {
+    onChange(
+      fieldTypes.filter((_, i) => i !== index),
+      typeConversion
+    );
+    onRunQuery();
+  };
+
+  return (
+    <>
+      <InlineFieldRow>
+        <InlineField
+          label="Type mismatches"
+          labelWidth={16}
+          tooltip="What to do with values that don't have the type of their field, such as the string &quot;21.5&quot; in a numeric field. Fields have the type of their first value, unless their type is set."
+        >
+          <RadioButtonGroup
+            options={typeConversionOptions}
+            value={typeConversion || 'drop'}
+            onChange={(value) => {
+              onChange(fieldTypes, value === 'drop' ? undefined : value);
+              onRunQuery();
+            }}
+          />
+        </InlineField>
+        <Button
+          variant="secondary"
+          icon="plus"
+          aria-label="Add field type"
+          onClick={() => onChange([...fieldTypes, { field: '', type: 'number' }], typeConversion)}
+        >
+          Add field type
+        </Button>
+      </InlineFieldRow>
+      {fieldTypes.map((t, index) => (
+        <InlineFieldRow key={index}>
+          <InlineField label="Field" labelWidth={16} tooltip="Name of the field, such as 'temperature' or 'env.temp'">
+            <Input
+              placeholder="Field name"
+              width={24}
+              value={t.field}
+              onBlur={onRunQuery}
+              onChange={(e) => updateFieldType(index, { field: e.currentTarget.value })}
+            />
+          </InlineField>
+          <InlineField label="Type" labelWidth={8}>
+            <Select
+              width={14}
+              options={typeOptions}
+              value={t.type}
+              onChange={(v) => {
+                updateFieldType(index, { type: v.value!, format: undefined });
+                onRunQuery();
+              }}
+            />
+          </InlineField>
+          {t.type === 'time' && (
+            <InlineField label="Format" labelWidth={8}>
+              <Select
+                width={24}
+                options={timeFormatOptions}
+                value={t.format ?? ''}
+                allowCustomValue
+                onChange={(v) => {
+                  updateFieldType(index, { format: v.value || undefined });
+                  onRunQuery();
+                }}
+              />
+            </InlineField>
+          )}
+          <IconButton name="trash-alt" aria-label="Remove field type" onClick={() => removeFieldType(index)} />
+        </InlineFieldRow>
+      ))}
+    </>
+  );
+};
@@ -0,0 +1,104 @@
+import React from 'react';
+import { Button, IconButton, InlineField, InlineFieldRow, Input, RadioButtonGroup, Select } from '@grafana/ui';
+import { SelectableValue } from '@grafana/data';
+import { JSONPath, MqttQuery } from './types';
+
+type JSONPathLanguage = NonNullable<MqttQuery['jsonPathLanguage']>;
+
+const languageOptions: Array<SelectableValue<JSONPathLanguage>> = [
+  { label: 'JSONPath', value: 'jsonpath' },
+  { label: 'JMESPath', value: 'jmespath' },
+];
+
+const typeOptions: Array<SelectableValue<string>> = [
+  { label: 'Auto', value: '' },
+  { label: 'Number', value: 'number' },
+  { label: 'String', value: 'string' },
+  { label: 'Boolean', value: 'boolean' },
+];
+
+interface Props {
+  paths: JSONPath[];
+  language?: JSONPathLanguage;
+  onChange: (paths: JSONPath[], language?: JSONPathLanguage) => void;
+  onRunQuery: () => void;
+}
+
+export const JSONPathsEditor = ({ paths, language, onChange, onRunQuery }: Props) => {
+  const updatePath = (index: number, update: Partial<JSONPath>) => {
+    onChange(
+      paths.map((p, i) => (i === index ? { ...p, ...update } : p)),
+      language
+    );
+  };
+
+  const removePath = (index: number) => {
+    onChange(
+      paths.filter((_, i) => i !== index),
+      language
+    );
+    onRunQuery();
+  };
+
+  return (
+    <>
+      <InlineFieldRow>
+        <InlineField
+          label="Fields"
+          labelWidth={16}
+          tooltip="Extract only the values selected by path expressions to fields, instead of every key of the JSON payload"
+        >
+          <RadioButtonGroup
+            options={languageOptions}
+            value={language || 'jsonpath'}
+            onChange={(value) => {
+              onChange(paths, value);
+              onRunQuery();
+            }}
+          />
+        </InlineField>
+        <Button
+          variant="secondary"
+          icon="plus"
+          aria-label="Add field"
+          onClick={() => onChange([...paths, { path: '' }], language)}
+        >
+          Add field
+        </Button>
+      </InlineFieldRow>
+      {paths.map((p, index) => (
+        <InlineFieldRow key={index}>
+          <InlineField label="Path" labelWidth={16} grow>
+            <Input
+              placeholder={language === 'jmespath' ? 'e.g. "env.temp"' : 'e.g. "$.env.temp"'}
+              value={p.path}
+              onBlur={onRunQuery}
+              onChange={(e) => updatePath(index, { path: e.currentTarget.value })}
+            />
+          </InlineField>
+          <InlineField label="Alias" labelWidth={8}>
+            <Input
+              placeholder="Field name"
+              width={20}
+              value={p.alias ?? ''}
+              onBlur={onRunQuery}
+              onChange={(e) => updatePath(index, { alias: e.currentTarget.value || undefined })}
+            />
+          </InlineField>
+          <InlineField label="Type" labelWidth={8}>
+            <Select
+              width={14}
+              options={typeOptions}
+              value={p.type ?? ''}
+              onChange={(v) => {
+                updatePath(index, { type: v.value || undefined });
+                onRunQuery();
+              }}
+            />
+          </InlineField>
+          <IconButton name="trash-alt" aria-label="Remove field" onClick={() => removePath(index)} />
+        </InlineFieldRow>
+      ))}
+    </>
+  );
+};
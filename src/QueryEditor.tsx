@@ -1,28 +1,371 @@
 import React from 'react';
-import { Input, InlineFieldRow, InlineField } from '@grafana/ui';
-import { QueryEditorProps } from '@grafana/data';
+import { Input, InlineFieldRow, InlineField, InlineSwitch, RadioButtonGroup, Select } from '@grafana/ui';
+import { QueryEditorProps, SelectableValue } from '@grafana/data';
 import { DataSource } from './datasource';
-import { MqttDataSourceOptions, MqttQuery } from './types';
+import { BinaryFieldsEditor } from './BinaryFieldsEditor';
+import { CSVColumnsEditor } from './CSVColumnsEditor';
+import { FieldTypesEditor } from './FieldTypesEditor';
+import { JSONPathsEditor } from './JSONPathsEditor';
+import { MqttDataSourceOptions, MqttQuery, PayloadFormat, QueryType } from './types';
 
 type Props = QueryEditorProps<DataSource, MqttQuery, MqttDataSourceOptions>;
 
+const qosOptions: Array<SelectableValue<number>> = [
+  { label: '0', value: 0, description: 'At most once' },
+  { label: '1', value: 1, description: 'At least once' },
+  { label: '2', value: 2, description: 'Exactly once' },
+];
+
+const queryTypeOptions: Array<SelectableValue<QueryType>> = [
+  { label: 'Messages', value: QueryType.Messages, description: 'Messages received on the topic' },
+  {
+    label: 'Sparkplug state',
+    value: QueryType.SparkplugState,
+    description: 'Online state of the Sparkplug B host applications, edge nodes and devices matching the topic',
+  },
+];
+
+const payloadFormatOptions: Array<SelectableValue<PayloadFormat>> = [
+  { label: 'Auto', value: 'auto', description: 'JSON. Other payloads, including binary payloads, are strings.' },
+  { label: 'JSON', value: 'json' },
+  { label: 'CBOR', value: 'cbor' },
+  { label: 'MessagePack', value: 'msgpack' },
+  { label: 'InfluxDB line protocol', value: 'influx', description: 'A row per line, with the tags as labels' },
+  { label: 'Delimited text', value: 'csv', description: 'A row per line of CSV or other delimited text' },
+  { label: 'Binary', value: 'binary', description: 'Values at fixed positions of binary payloads' },
+  { label: 'Regex', value: 'regex', description: 'Named capture groups of a regular expression matching text payloads' },
+  { label: 'Raw', value: 'raw', description: 'The payload as a string, without decoding it' },
+];
+
+const precisionOptions: Array<SelectableValue<string>> = [
+  { label: 'Nanoseconds', value: 'epoch_ns' },
+  { label: 'Microseconds', value: 'epoch_us' },
+  { label: 'Milliseconds', value: 'epoch_ms' },
+  { label: 'Seconds', value: 'epoch_s' },
+];
+
+const timeFormatOptions: Array<SelectableValue<string>> = [
+  { label: 'RFC 3339', value: 'rfc3339', description: 'e.g. "2024-05-01T12:30:15Z"' },
+  { label: 'Epoch seconds', value: 'epoch_s' },
+  { label: 'Epoch milliseconds', value: 'epoch_ms' },
+  { label: 'Epoch microseconds', value: 'epoch_us' },
+  { label: 'Epoch nanoseconds', value: 'epoch_ns' },
+];
+
 export const QueryEditor = (props: Props) => {
   const { query, onChange, onRunQuery } = props;
+  const isSparkplugState = query.queryType === QueryType.SparkplugState;
 
   return (
     <>
+      <InlineFieldRow>
+        <InlineField label="Query type" labelWidth={12}>
+          <RadioButtonGroup
+            options={queryTypeOptions}
+            value={query.queryType ?? QueryType.Messages}
+            onChange={(queryType) => {
+              onChange({ ...query, queryType: queryType === QueryType.Messages ? undefined : queryType });
+              onRunQuery();
+            }}
+          />
+        </InlineField>
+      </InlineFieldRow>
       <InlineFieldRow>
         <InlineField label="Topic" labelWidth={8} grow>
           <Input
             name="topic"
-            required
-            placeholder='e.g. "home/bedroom/temperature"'
+            required={!isSparkplugState}
+            placeholder={isSparkplugState ? 'spBv1.0/#' : 'e.g. "home/bedroom/temperature"'}
             value={query.topic}
             onBlur={onRunQuery}
             onChange={(e) => onChange({...query, topic: e.currentTarget.value })}
           />
         </InlineField>
+        <InlineField label="QoS" labelWidth={8} tooltip="MQTT quality of service level of the subscription">
+          <RadioButtonGroup
+            options={qosOptions}
+            value={query.qos ?? 0}
+            onChange={(qos) => {
+              onChange({ ...query, qos });
+              onRunQuery();
+            }}
+          />
+        </InlineField>
       </InlineFieldRow>
+      {!isSparkplugState && (
+        <>
+          <InlineFieldRow>
+            <InlineField
+              label="Split by topic"
+              labelWidth={16}
+              tooltip="Return a series per topic matched by the wildcards, labeled with the matched topic levels"
+            >
+              <InlineSwitch
+                value={query.splitByTopic ?? false}
+                onChange={(e) => {
+                  onChange({ ...query, splitByTopic: e.currentTarget.checked });
+                  onRunQuery();
+                }}
+              />
+            </InlineField>
+            {query.splitByTopic && (
+              <InlineField
+                label="Wildcard labels"
+                labelWidth={16}
+                grow
+                tooltip="Comma-separated label names for the topic levels matched by each wildcard, in order"
+              >
+                <Input
+                  name="wildcardLabels"
+                  placeholder='e.g. "device"'
+                  value={query.wildcardLabels?.join(', ') ?? ''}
+                  onBlur={onRunQuery}
+                  onChange={(e) => {
+                    const wildcardLabels = e.currentTarget.value
+                      .split(',')
+                      .map((label) => label.trim());
+                    onChange({ ...query, wildcardLabels });
+                  }}
+                />
+              </InlineField>
+            )}
+          </InlineFieldRow>
+          <InlineFieldRow>
+            <InlineField label="Payload format" labelWidth={16} tooltip="Format of the message payloads">
+              <Select
+                width={20}
+                options={payloadFormatOptions}
+                value={query.payloadFormat ?? 'auto'}
+                onChange={(v) => {
+                  const auto = v.value === 'auto';
+                  // protobuf messages are only decoded with the auto format
+                  onChange({
+                    ...query,
+                    payloadFormat: auto ? undefined : v.value,
+                    protoMessage: auto ? query.protoMessage : undefined,
+                  });
+                  onRunQuery();
+                }}
+              />
+            </InlineField>
+            {query.payloadFormat === 'influx' && (
+              <InlineField label="Precision" labelWidth={12} tooltip="Precision of the timestamps of the lines">
+                <Select
+                  width={20}
+                  options={precisionOptions}
+                  value={query.timeFormat ?? 'epoch_ns'}
+                  onChange={(v) => {
+                    onChange({ ...query, timeFormat: v.value });
+                    onRunQuery();
+                  }}
+                />
+              </InlineField>
+            )}
+            {(query.payloadFormat ?? 'auto') === 'auto' && (
+              <InlineField
+                label="Protobuf message"
+                labelWidth={16}
+                grow
+                tooltip="Full name of the protobuf message type of the payloads, from the descriptor set of the data source. Leave empty for other payloads."
+              >
+                <Input
+                  name="protoMessage"
+                  placeholder='e.g. "acme.sensors.Reading"'
+                  value={query.protoMessage ?? ''}
+                  onBlur={onRunQuery}
+                  onChange={(e) => onChange({ ...query, protoMessage: e.currentTarget.value || undefined })}
+                />
+              </InlineField>
+            )}
+          </InlineFieldRow>
+          {query.payloadFormat === 'csv' && (
+            <>
+              <InlineFieldRow>
+                <InlineField
+                  label="Delimiter"
+                  labelWidth={16}
+                  tooltip="Character between the values of a line. Enter '\t' for tabs. Defaults to ','."
+                >
+                  <Input
+                    name="csvDelimiter"
+                    placeholder=","
+                    width={8}
+                    value={query.csvDelimiter ?? ''}
+                    onBlur={onRunQuery}
+                    onChange={(e) => onChange({ ...query, csvDelimiter: e.currentTarget.value || undefined })}
+                  />
+                </InlineField>
+                <InlineField label="Header row" labelWidth={12} tooltip="The first line of the payloads names the columns">
+                  <InlineSwitch
+                    value={query.csvHeader ?? false}
+                    onChange={(e) => {
+                      onChange({ ...query, csvHeader: e.currentTarget.checked });
+                      onRunQuery();
+                    }}
+                  />
+                </InlineField>
+              </InlineFieldRow>
+              <CSVColumnsEditor
+                columns={query.csvColumns ?? []}
+                onChange={(csvColumns) => onChange({ ...query, csvColumns: csvColumns.length ? csvColumns : undefined })}
+                onRunQuery={onRunQuery}
+              />
+            </>
+          )}
+          {query.payloadFormat === 'regex' && (
+            <InlineFieldRow>
+              <InlineField
+                label="Regex"
+                labelWidth={16}
+                grow
+                tooltip="Regular expression matching the payloads. Each named capture group, such as (?P<temperature>[0-9.]+), is converted to a field."
+              >
+                <Input
+                  name="regex"
+                  placeholder='e.g. "T=(?P<temperature>[0-9.]+)C H=(?P<humidity>[0-9.]+)%"'
+                  value={query.regex ?? ''}
+                  onBlur={onRunQuery}
+                  onChange={(e) => onChange({ ...query, regex: e.currentTarget.value || undefined })}
+                />
+              </InlineField>
+            </InlineFieldRow>
+          )}
+          {query.payloadFormat === 'binary' && (
+            <BinaryFieldsEditor
+              fields={query.binaryFields ?? []}
+              onChange={(binaryFields) =>
+                onChange({ ...query, binaryFields: binaryFields.length ? binaryFields : undefined })
+              }
+              onRunQuery={onRunQuery}
+            />
+          )}
+          <InlineFieldRow>
+            <InlineField
+              label="JSON separator"
+              labelWidth={16}
+              tooltip="Separator between the keys of nested JSON objects in field names. Defaults to '.'."
+            >
+              <Input
+                name="jsonSeparator"
+                placeholder="."
+                width={8}
+                value={query.jsonSeparator ?? ''}
+                onBlur={onRunQuery}
+                onChange={(e) => onChange({ ...query, jsonSeparator: e.currentTarget.value || undefined })}
+              />
+            </InlineField>
+            <InlineField
+              label="Max depth"
+              labelWidth={12}
+              tooltip="Number of levels of nested JSON objects converted to fields. Deeper objects are kept as JSON. 0 converts all levels."
+            >
+              <Input
+                name="jsonMaxDepth"
+                type="number"
+                min={0}
+                placeholder="0"
+                width={8}
+                value={query.jsonMaxDepth ?? ''}
+                onBlur={onRunQuery}
+                onChange={(e) => {
+                  const jsonMaxDepth = parseInt(e.currentTarget.value, 10);
+                  onChange({ ...query, jsonMaxDepth: isNaN(jsonMaxDepth) ? undefined : jsonMaxDepth });
+                }}
+              />
+            </InlineField>
+            <InlineField
+              label="Explode arrays"
+              labelWidth={16}
+              tooltip="Convert each element of JSON array payloads to its own row, for batches of records such as [{&quot;ts&quot;: ..., &quot;v&quot;: ...}]"
+            >
+              <InlineSwitch
+                value={query.explodeArrays ?? false}
+                onChange={(e) => {
+                  onChange({ ...query, explodeArrays: e.currentTarget.checked });
+                  onRunQuery();
+                }}
+              />
+            </InlineField>
+          </InlineFieldRow>
+          <JSONPathsEditor
+            paths={query.jsonPaths ?? []}
+            language={query.jsonPathLanguage}
+            onChange={(jsonPaths, jsonPathLanguage) =>
+              onChange({ ...query, jsonPaths: jsonPaths.length ? jsonPaths : undefined, jsonPathLanguage })
+            }
+            onRunQuery={onRunQuery}
+          />
+          <FieldTypesEditor
+            fieldTypes={query.fieldTypes ?? []}
+            typeConversion={query.typeConversion}
+            onChange={(fieldTypes, typeConversion) =>
+              onChange({ ...query, fieldTypes: fieldTypes.length ? fieldTypes : undefined, typeConversion })
+            }
+            onRunQuery={onRunQuery}
+          />
+          <InlineFieldRow>
+            <InlineField
+              label="Time field"
+              labelWidth={16}
+              tooltip="Payload field with the time of the messages, such as 'ts' or 'meta.ts'. Messages without a valid time use the time they were received, which is kept in the 'Received' field."
+            >
+              <Input
+                name="timeField"
+                placeholder="Received time"
+                width={24}
+                value={query.timeField ?? ''}
+                onBlur={onRunQuery}
+                onChange={(e) => onChange({ ...query, timeField: e.currentTarget.value || undefined })}
+              />
+            </InlineField>
+            {query.timeField && (
+              <InlineField
+                label="Format"
+                labelWidth={12}
+                tooltip="Format of the time field. Enter a Go time layout, such as '2006-01-02 15:04:05', for other formats."
+              >
+                <Select
+                  width={28}
+                  options={timeFormatOptions}
+                  value={query.timeFormat ?? 'rfc3339'}
+                  allowCustomValue
+                  onChange={(v) => {
+                    onChange({ ...query, timeFormat: v.value });
+                    onRunQuery();
+                  }}
+                />
+              </InlineField>
+            )}
+          </InlineFieldRow>
+          <InlineFieldRow>
+            <InlineField
+              label="Wait for value"
+              labelWidth={16}
+              tooltip="Return the latest value of the topic as a number, waiting for a message if none was received yet. Use this mode for alert rules."
+            >
+              <InlineSwitch
+                value={query.waitForValue ?? false}
+                onChange={(e) => {
+                  onChange({ ...query, waitForValue: e.currentTarget.checked });
+                  onRunQuery();
+                }}
+              />
+            </InlineField>
+            {query.waitForValue && (
+              <InlineField label="Timeout" labelWidth={16} tooltip="How long to wait for a message, up to 1m. Defaults to 5s.">
+                <Input
+                  name="waitTimeout"
+                  placeholder="5s"
+                  width={12}
+                  value={query.waitTimeout ?? ''}
+                  onBlur={onRunQuery}
+                  onChange={(e) => onChange({ ...query, waitTimeout: e.currentTarget.value })}
+                />
+              </InlineField>
+            )}
+          </InlineFieldRow>
+        </>
+      )}
     </>
   );
 };
@@ -3,15 +3,33 @@ import {
   DataQueryResponse,
   DataSourceInstanceSettings,
   ScopedVars,
+  StreamingFrameAction,
 } from '@grafana/data';
-import { DataSourceWithBackend, getTemplateSrv } from '@grafana/runtime';
-import { MqttDataSourceOptions, MqttQuery } from './types';
+import { DataSourceWithBackend, getTemplateSrv, standardStreamOptionsProvider } from '@grafana/runtime';
+import { MqttDataSourceOptions, MqttQuery, QueryType } from './types';
 import { Observable, from, switchMap } from 'rxjs';
-import { getLiveStreamKey } from './streaming';
+import { getLiveStreamKey, getStreamOptions } from './streaming';
 
 export class DataSource extends DataSourceWithBackend<MqttQuery, MqttDataSourceOptions> {
   constructor(instanceSettings: DataSourceInstanceSettings<MqttDataSourceOptions>) {
     super(instanceSettings);
+
+    this.streamOptionsProvider = (request, frame, perhapsLive) => {
+      const options = standardStreamOptionsProvider(request, frame, perhapsLive);
+      const target = request.targets.find((target) => target.refId === frame.refId);
+      if (!target) {
+        return options;
+      }
+      // The options of the query are sent with the subscription, so the stream is decoded
+      // with them by whichever Grafana instance runs it.
+      const addr = { ...options.addr, data: getStreamOptions(target) };
+      // The Sparkplug state is a table of the current state, so live updates replace
+      // the table instead of being appended to it.
+      if (target.queryType === QueryType.SparkplugState) {
+        return { ...options, addr, action: StreamingFrameAction.Replace };
+      }
+      return { ...options, addr };
+    };
   }
 
   query(request: DataQueryRequest<MqttQuery>): Observable<DataQueryResponse> {
@@ -20,7 +38,7 @@ export class DataSource extends DataSourceWithBackend<MqttQuery, MqttDataSourceO
       Promise.all(
         request.targets.map(async (target) => ({
           ...target,
-          streamingKey: await getLiveStreamKey(this.uid, target.topic),
+          streamingKey: await getLiveStreamKey(this.uid, target.topic, getStreamOptions(target)),
         }))
       )
     ).pipe(
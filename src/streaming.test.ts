@@ -1,5 +1,5 @@
 import { config } from '@grafana/runtime';
-import { getLiveStreamKey } from './streaming';
+import { getLiveStreamKey, getStreamOptions } from './streaming';
 
 // Mock the @grafana/runtime module
 jest.mock('@grafana/runtime', () => ({
@@ -217,3 +217,13 @@ describe('getLiveStreamKey', () => {
     });
   });
 });
+
+describe('getStreamOptions', () => {
+  it('should only include the options of the stream', () => {
+    const options = getStreamOptions({ refId: 'A', topic: 'sensor/temperature', qos: 1, splitByTopic: true });
+
+    expect(options).toMatchObject({ qos: 1, splitByTopic: true });
+    expect(options).not.toHaveProperty('refId');
+    expect(options).not.toHaveProperty('topic');
+  });
+});
@@ -1,12 +1,42 @@
 import { config } from '@grafana/runtime';
+import { MqttQuery } from './types';
+
+/**
+ * The options of the query that the stream of its channel needs. They are part of the streaming key,
+ * and are sent with the subscription to the channel, so the backend decodes the stream with them.
+ */
+export function getStreamOptions(query: MqttQuery) {
+  return {
+    queryType: query.queryType,
+    qos: query.qos,
+    splitByTopic: query.splitByTopic,
+    wildcardLabels: query.wildcardLabels,
+    jsonSeparator: query.jsonSeparator,
+    jsonMaxDepth: query.jsonMaxDepth,
+    jsonPaths: query.jsonPaths,
+    jsonPathLanguage: query.jsonPathLanguage,
+    timeField: query.timeField,
+    timeFormat: query.timeFormat,
+    explodeArrays: query.explodeArrays,
+    payloadFormat: query.payloadFormat,
+    protoMessage: query.protoMessage,
+    csvDelimiter: query.csvDelimiter,
+    csvHeader: query.csvHeader,
+    csvColumns: query.csvColumns,
+    binaryFields: query.binaryFields,
+    regex: query.regex,
+    fieldTypes: query.fieldTypes,
+    typeConversion: query.typeConversion,
+  };
+}
 
 /**
  * Calculate a unique key for the query.  The key is used to pick a channel and should
  * be unique for each distinct query execution plan.  This key is not secure and is only picked to avoid
  * possible collisions
  */
-export async function getLiveStreamKey(datasourceUid: string, topic?: string): Promise<string> {
-  const str = JSON.stringify({ topic });
+export async function getLiveStreamKey(datasourceUid: string, topic?: string, options?: object): Promise<string> {
+  const str = JSON.stringify({ topic, ...options });
 
   const namespace = config.bootData.settings.namespace;
   const msgUint8 = new TextEncoder().encode(str); // encode as (utf-8) Uint8Array
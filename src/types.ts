@@ -1,16 +1,85 @@
 import { DataSourceJsonData } from '@grafana/data';
 import { DataQuery } from '@grafana/schema';
 
+export enum QueryType {
+  Messages = 'messages',
+  SparkplugState = 'sparkplugState',
+}
+
+export type PayloadFormat = 'auto' | 'json' | 'cbor' | 'msgpack' | 'influx' | 'csv' | 'binary' | 'regex' | 'raw';
+
 export interface MqttQuery extends DataQuery {
+  queryType?: QueryType;
   topic?: string;
+  qos?: number;
+  splitByTopic?: boolean;
+  wildcardLabels?: string[];
+  jsonSeparator?: string;
+  jsonMaxDepth?: number;
+  jsonPaths?: JSONPath[];
+  jsonPathLanguage?: 'jsonpath' | 'jmespath';
+  timeField?: string;
+  timeFormat?: string;
+  explodeArrays?: boolean;
+  payloadFormat?: PayloadFormat;
+  protoMessage?: string;
+  csvDelimiter?: string;
+  csvHeader?: boolean;
+  csvColumns?: CSVColumn[];
+  binaryFields?: BinaryField[];
+  regex?: string;
+  fieldTypes?: FieldTypeOverride[];
+  typeConversion?: 'drop' | 'convert';
+  waitForValue?: boolean;
+  waitTimeout?: string;
   stream?: boolean;
   streamingKey?: string;
 }
 
+export interface JSONPath {
+  path: string;
+  alias?: string;
+  type?: string;
+}
+
+export interface CSVColumn {
+  name?: string;
+  type?: string;
+}
+
+export interface BinaryField {
+  name: string;
+  offset?: number;
+  type: string;
+  endianness?: 'big' | 'little';
+  scale?: number;
+}
+
+export type Compression = 'auto' | 'gzip' | 'zlib' | 'zstd' | 'snappy';
+
+export interface FieldTypeOverride {
+  field: string;
+  type: string;
+  format?: string;
+}
+
 export interface MqttDataSourceOptions extends DataSourceJsonData {
   uri: string;
+  protocolVersion?: number;
   username?: string;
   clientID?: string;
+  maxBufferedMessages?: number;
+  maxBufferedBytes?: number;
+  historyRetention?: string;
+  publishEnabled?: boolean;
+  publishAllowedTopics?: string[];
+  publishQoS?: number;
+  publishRetain?: boolean;
+  allowedTopics?: string[];
+  deniedTopics?: string[];
+  protoDescriptors?: string;
+  compression?: Compression;
+  maxDecompressedBytes?: number;
   tlsAuth: boolean;
   tlsAuthWithCACert: boolean;
   tlsSkipVerify: boolean;